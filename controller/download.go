@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"MusicPlayerWeb/service"
+)
+
+// 下载权限按角色控制：guest（未登录）、user（已登录）、admin（管理员），
+// 由 DOWNLOAD_ROLES 环境变量以逗号分隔配置。登录状态只看 user_id Cookie，任何人都能伪造，
+// 默认只允许经 user_profiles 核实的管理员下载；开放给 user 等同于开放给所有人
+const defaultDownloadRoles = "admin"
+
+// downloadAllowed 判断当前请求者的角色是否允许下载，只在需要时查询管理员身份
+func downloadAllowed(r *http.Request) (allowed bool, loggedIn bool) {
+	conf := os.Getenv("DOWNLOAD_ROLES")
+	if conf == "" {
+		conf = defaultDownloadRoles
+	}
+	roles := map[string]bool{}
+	for _, role := range strings.Split(conf, ",") {
+		roles[strings.ToLower(strings.TrimSpace(role))] = true
+	}
+	userID, err := service.GetCurrentUserID(r)
+	if err != nil || userID == "" {
+		return roles["guest"], false
+	}
+	if roles["guest"] || roles["user"] {
+		return true, true
+	}
+	if roles["admin"] {
+		isAdmin, err := checkUserIsAdmin(userID)
+		return err == nil && isAdmin, true
+	}
+	return false, true
+}
+
+// HandleAlbumDownload 下载整张专辑：GET /api/albums/{id}/download
+// 返回不压缩的 ZIP（原始音频、封面与 .m3u8 播放列表），边读边发送；
+// 支持 HEAD、Range 续传和 If-Range，ETag 随专辑内文件变化。
+// GET /api/albums/{id}/export 导出专辑曲目列表，见 handleAlbumExport
+func HandleAlbumDownload(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/albums/")
+	idStr, action, _ := strings.Cut(rest, "/")
+	if action == "export" {
+		handleAlbumExport(w, r, idStr)
+		return
+	}
+	if action != "download" {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if allowed, loggedIn := downloadAllowed(r); !allowed {
+		if !loggedIn {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		} else {
+			writeErr(w, http.StatusForbidden, "download not allowed for your role")
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid album id")
+		return
+	}
+	archive, err := service.NewAlbumArchive(id)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archive.Name}))
+	serveMedia(w, r, mediaContent{
+		ContentType:  "application/zip",
+		ModTime:      archive.ModTime,
+		ETag:         archive.ETag,
+		CacheControl: "private, no-cache",
+		Content:      archive,
+	})
+}
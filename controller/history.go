@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"MusicPlayerWeb/service"
+)
+
+// HandleHistory 网页播放器记录一次完整播放：POST /api/history {id}，
+// 与 Subsonic scrobble 写入同一张播放历史表，供播放次数与智能歌单统计
+func HandleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, err := service.GetCurrentUserID(r)
+	if err != nil || userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if _, err := service.GetTrack(req.ID); err != nil {
+		writeErr(w, http.StatusNotFound, "track not found")
+		return
+	}
+	if err := service.RecordPlay(userID, req.ID, time.Now()); err != nil {
+		log.Printf("记录播放历史失败: %v", err)
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]int{"id": req.ID})
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"MusicPlayerWeb/service"
+)
+
+// HandleHLS 处理 HLS 点播请求：
+//
+//	GET /api/hls/{trackId}/index.m3u8            主播放列表（列出各码率档位）
+//	GET /api/hls/{trackId}/{variant}/index.m3u8  媒体播放列表
+//	GET /api/hls/{trackId}/{variant}/seg{n}.ts   分片，首次请求时生成
+//
+// trackId 为纯数字时是曲库曲目，否则是当前用户上传的音乐文件ID
+func HandleHLS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/hls/"), "/"), "/")
+	if len(parts) < 2 || parts[0] == "" {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	trackID := parts[0]
+	userID, _ := service.GetCurrentUserID(r)
+	if _, err := strconv.Atoi(trackID); err != nil && userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "index.m3u8":
+		playlist, err := service.HLSMasterPlaylist(r.Context(), trackID, userID)
+		writePlaylist(w, playlist, err)
+	case len(parts) == 3 && parts[2] == "index.m3u8":
+		playlist, err := service.HLSMediaPlaylist(r.Context(), trackID, userID, parts[1])
+		writePlaylist(w, playlist, err)
+	case len(parts) == 3 && strings.HasPrefix(parts[2], "seg") && strings.HasSuffix(parts[2], ".ts"):
+		index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(parts[2], "seg"), ".ts"))
+		if err != nil {
+			writeErr(w, http.StatusNotFound, "not found")
+			return
+		}
+		f, err := service.HLSSegment(r.Context(), trackID, userID, parts[1], index)
+		if err != nil {
+			writeErr(w, hlsErrorStatus(err), err.Error())
+			return
+		}
+		defer f.Close()
+		serveMediaFile(w, r, f, "video/mp2t", "private, max-age=86400")
+	default:
+		writeErr(w, http.StatusNotFound, "not found")
+	}
+}
+
+func writePlaylist(w http.ResponseWriter, playlist string, err error) {
+	if err != nil {
+		writeErr(w, hlsErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write([]byte(playlist))
+}
+
+func hlsErrorStatus(err error) int {
+	if errors.Is(err, service.ErrTranscodeUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusNotFound
+}
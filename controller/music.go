@@ -1,9 +1,10 @@
 package controller
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -19,13 +20,13 @@ func writeErr(w http.ResponseWriter, code int, msg string) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
-// GET /api/music
+// GET /api/music?root=...
 func HandleMusicList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	list, err := service.ListTracks()
+	list, err := service.ListTracksInRoot(r.URL.Query().Get("root"))
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, err.Error())
 		return
@@ -48,9 +49,9 @@ func HandleAlbums(w http.ResponseWriter, r *http.Request) {
 
 	// 处理真实数据，添加分类和封面URL
 	var result []map[string]interface{}
-	for i, album := range list {
+	for _, album := range list {
 		albumData := make(map[string]interface{})
-		albumData["id"] = i + 1
+		albumData["id"] = album.ID
 		albumData["name"] = album.Name
 		albumData["artist"] = album.Artist
 		albumData["songCount"] = album.Count
@@ -70,7 +71,7 @@ func HandleAlbums(w http.ResponseWriter, r *http.Request) {
 
 		// 如果有封面曲目ID，生成封面URL
 		if album.CoverTrackID >= 0 {
-			albumData["cover"] = fmt.Sprintf("/api/cover?id=%d", album.CoverTrackID)
+			albumData["cover"] = fmt.Sprintf("/api/cover?id=%d&size=256", album.CoverTrackID)
 		} else {
 			// 使用默认封面
 			albumData["cover"] = "https://picsum.photos/id/1015/300/300"
@@ -179,124 +180,101 @@ func HandleAlbumTracksByID(w http.ResponseWriter, r *http.Request) {
 }
 
 // GET /api/audio?id=...
+// 曲库是公开的，这里不校验分享令牌；分享的有效期、密码与次数上限只对上传文件生效
 func HandleAudio(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	idStr := r.URL.Query().Get("id")
 	id, _ := strconv.Atoi(idStr)
-	rc, ctype, err := service.ReadAudio(id)
+	var f *os.File
+	var ctype string
+	var err error
+	if profile := r.URL.Query().Get("profile"); profile != "" && profile != "original" {
+		// 转码档位：完整转码后（或命中缓存）返回文件，Range 处理与原文件一致
+		f, ctype, err = service.ReadTranscodedAudio(r.Context(), id, profile)
+		switch {
+		case errors.Is(err, service.ErrUnknownProfile):
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		case errors.Is(err, service.ErrTranscodeUnavailable):
+			writeErr(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+	} else {
+		f, ctype, err = service.ReadAudio(id)
+	}
 	if err != nil {
 		writeErr(w, http.StatusNotFound, err.Error())
 		return
 	}
-	defer rc.Close()
-
-	// 基础头
-	w.Header().Set("Content-Type", ctype)
-	w.Header().Set("Accept-Ranges", "bytes")
+	defer f.Close()
 
-	// 支持 Range 请求（用于拖动进度）
-	rangeHeader := r.Header.Get("Range")
-	// 只有当底层是 *os.File 时才能精确 Seek 与计算长度
-	if rangeHeader != "" {
-		if f, ok := rc.(*os.File); ok {
-			st, statErr := f.Stat()
-			if statErr != nil {
-				writeErr(w, http.StatusInternalServerError, statErr.Error())
-				return
-			}
-			size := st.Size()
-
-			// 解析 Range: bytes=start-end
-			// 兼容 "bytes=start-" 或 "bytes=-suffix"
-			if !strings.HasPrefix(rangeHeader, "bytes=") {
-				// 非法 Range，返回整个文件
-				w.WriteHeader(http.StatusOK)
-				_, _ = io.Copy(w, f)
-				return
-			}
-			spec := strings.TrimPrefix(rangeHeader, "bytes=")
-			var start, end int64
-			start = 0
-			end = size - 1
-
-			parts := strings.Split(spec, "-")
-			if len(parts) == 2 {
-				if parts[0] != "" {
-					if s, perr := strconv.ParseInt(parts[0], 10, 64); perr == nil {
-						start = s
-					}
-				} else {
-					// 形如 "bytes=-N" 表示最后 N 字节
-					if n, perr := strconv.ParseInt(parts[1], 10, 64); perr == nil {
-						if n < size {
-							start = size - n
-						} else {
-							start = 0
-						}
-					}
-				}
-				if parts[1] != "" {
-					if e, perr := strconv.ParseInt(parts[1], 10, 64); perr == nil {
-						end = e
-					}
-				}
-			}
-
-			// 边界校验
-			if start < 0 || start >= size {
-				// 无法满足
-				w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
-				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
-				return
-			}
-			if end < start {
-				end = size - 1
-			}
-			if end >= size {
-				end = size - 1
-			}
+	// Range、多段 Range 与条件请求统一交给 serveMedia；文件可能被替换，每次都需凭 ETag 校验
+	serveMediaFile(w, r, f, ctype, "no-cache")
+}
 
-			// 定位并发送分段
-			if _, seekErr := f.Seek(start, 0); seekErr != nil {
-				writeErr(w, http.StatusInternalServerError, seekErr.Error())
-				return
-			}
-			n := end - start + 1
-			w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
-			w.Header().Set("Content-Range",
-				"bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(size, 10))
-			w.WriteHeader(http.StatusPartialContent)
-			_, _ = io.CopyN(w, f, n)
-			return
-		}
+// GET /api/audio/profiles -> 可用的转码档位
+func HandleAudioProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
-
-	// 无 Range 或不可 Seek：返回整个文件
-	w.WriteHeader(http.StatusOK)
-	_, _ = io.Copy(w, rc)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"available": service.TranscodeAvailable(),
+		"profiles":  service.ListTranscodeProfiles(),
+	})
 }
 
 // GET /api/cover?id=...
 func HandleCover(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	idStr := r.URL.Query().Get("id")
-	id, _ := strconv.Atoi(idStr)
-	data, ctype, err := service.ReadCover(id)
+	q := r.URL.Query()
+	id, _ := strconv.Atoi(q.Get("id"))
+	size, _ := strconv.Atoi(q.Get("size"))
+	serveCover(w, r, id, size, q.Get("format"))
+}
+
+// serveCover 输出曲目封面缩略图，format 为 webp 时输出 WebP，否则输出 JPEG
+func serveCover(w http.ResponseWriter, r *http.Request, id, size int, format string) {
+	if format != "webp" {
+		format = "jpeg"
+	}
+
+	// 先用扫描时记录的封面哈希比对 ETag，命中时无需读取音频文件
+	etag, err := service.CoverETag(id, size, format)
 	if err != nil {
 		writeErr(w, http.StatusNotFound, err.Error())
 		return
 	}
-	w.Header().Set("Content-Type", ctype)
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(data)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", coverCacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	img, err := service.GetCoverImage(id, size, format)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	serveMedia(w, r, mediaContent{
+		ContentType:  img.MIME,
+		ModTime:      img.ModTime,
+		ETag:         img.ETag,
+		CacheControl: coverCacheControl,
+		Content:      bytes.NewReader(img.Data),
+	})
 }
 
+// coverCacheControl 封面响应的缓存策略：浏览器缓存一周，过期后凭 ETag 校验
+const coverCacheControl = "public, max-age=604800"
+
 // GET /api/lyrics?id=...
 func HandleLyrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -331,6 +309,46 @@ func HandleLyricsRaw(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"lyrics": lyrics})
 }
 
+// GET /api/lyrics/timed?id=... -> 结构化的 LRC 时间轴（毫秒）
+func HandleTimedLyrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	lyrics, err := service.GetTimedLyrics(id)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(lyrics)
+}
+
+// GET /api/waveform?id=...&points=... -> 波形峰值（每点为 [最小值, 最大值]，范围 -1~1）
+func HandleWaveform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	points, _ := strconv.Atoi(r.URL.Query().Get("points"))
+	if _, err := service.GetTrack(id); err != nil {
+		writeErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	wf, err := service.GetWaveform(r.Context(), id, points)
+	switch {
+	case errors.Is(err, service.ErrWaveformUnsupported):
+		writeErr(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
+
 // GET /api/track?id=...
 func HandleTrack(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -348,6 +366,47 @@ func HandleTrack(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(t)
 }
 
+// GET /api/track_id_map -> 旧版顺序ID到稳定ID的映射
+func HandleTrackIDMap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	idMap, err := service.LegacyTrackIDMap()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result := make(map[string]int, len(idMap))
+	for oldID, newID := range idMap {
+		result[strconv.Itoa(oldID)] = newID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// POST /api/admin/migrate_track_ids -> 将收藏与评论中的旧曲目ID迁移为稳定ID；失败后重新调用会从断点继续，成功后再次调用返回 409
+func HandleMigrateTrackIDs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	result, err := service.MigrateLegacyTrackIDs()
+	if errors.Is(err, service.ErrTrackIDsMigrated) {
+		writeErr(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "迁移完成",
+		"migrated": result,
+	})
+}
+
 // GET /api/artists
 func HandleArtistsAPI(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -431,7 +490,7 @@ func HandleArtistsAPI(w http.ResponseWriter, r *http.Request) {
 
 		// 如果有封面曲目ID，生成封面URL
 		if coverTrackID, ok := artist["coverTrackID"].(int); ok {
-			artistData["cover"] = fmt.Sprintf("/api/cover?id=%d", coverTrackID)
+			artistData["cover"] = fmt.Sprintf("/api/cover?id=%d&size=256", coverTrackID)
 		} else {
 			// 使用默认封面
 			artistData["cover"] = "https://picsum.photos/id/1015/300/300"
@@ -544,21 +603,127 @@ func HandleUpdateMusicDir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 更新音乐目录
+	// 更新音乐目录，重新扫描在后台进行，进度见 GET /api/rescan
 	if err := service.UpdateMusicDir(request.MusicDir); err != nil {
-		writeErr(w, http.StatusInternalServerError, "failed to update music directory")
+		writeErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// 重新扫描音乐文件
-	if err := service.RescanMusic(); err != nil {
-		writeErr(w, http.StatusInternalServerError, "failed to rescan music files")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Music directory updated successfully",
+		"progress": service.GetScanProgress(),
+	})
+}
+
+// GET /api/rescan -> 扫描进度；POST /api/rescan -> 在后台发起增量重扫；DELETE /api/rescan -> 取消扫描。
+// 发起与取消扫描仅限管理员
+func HandleRescan(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(service.GetScanProgress())
+	case http.MethodPost, http.MethodDelete:
+		AdminMiddleware(handleRescanControl)(w, r)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func handleRescanControl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodDelete {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"cancelled": service.CancelRescan(),
+		})
 		return
 	}
+	started := service.StartRescan()
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"started":  started,
+		"progress": service.GetScanProgress(),
+	})
+}
 
+// GET /api/library/scan_report -> 最近一次扫描新增、变更、删除与读取失败的文件（重启后从磁盘读取）
+func HandleScanReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	report, err := service.LastScanReport()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Music directory updated successfully",
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// GET /api/library/roots -> 曲库根目录列表；POST /api/library/roots -> 添加根目录（仅管理员）
+func HandleLibraryRoots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(service.ListLibraryRoots())
+	case http.MethodPost:
+		var root service.LibraryRoot
+		root.Enabled = true
+		if err := json.NewDecoder(r.Body).Decode(&root); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := service.AddLibraryRoot(root); err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeRootsAccepted(w)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// PUT /api/library/roots/{name} -> 启用/停用根目录；DELETE /api/library/roots/{name} -> 删除根目录
+func HandleLibraryRoot(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/library/roots/"), "/")
+	if name == "" {
+		writeErr(w, http.StatusBadRequest, "root name required")
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		var request struct {
+			Enabled bool `json:"enabled"`
+		}
+		if decodeErr := json.NewDecoder(r.Body).Decode(&request); decodeErr != nil {
+			writeErr(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		err = service.SetLibraryRootEnabled(name, request.Enabled)
+	case http.MethodDelete:
+		err = service.RemoveLibraryRoot(name)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeRootsAccepted(w)
+}
+
+// writeRootsAccepted 根目录变更后重扫在后台进行，返回 202、新的根目录列表与扫描进度
+func writeRootsAccepted(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"roots":    service.ListLibraryRoots(),
+		"progress": service.GetScanProgress(),
 	})
 }
 
@@ -862,6 +1027,10 @@ func HandleCheckFavorite(w http.ResponseWriter, r *http.Request) {
 
 // 处理单个收藏项的路由
 func HandleFavoriteItem(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/favorites/export" {
+		HandleFavoritesExport(w, r)
+		return
+	}
 	switch r.Method {
 	case http.MethodDelete:
 		HandleDeleteFavorite(w, r)
@@ -892,12 +1061,28 @@ func HandleSongInfo(w http.ResponseWriter, r *http.Request) {
 	
 	// 返回歌曲的完整信息
 	songInfo := map[string]interface{}{
-		"id":        track.ID,
-		"title":     track.Title,
-		"artist":    track.Artist,
-		"album":     track.Album,
-		"hasCover":  track.HasCover,
-		"hasLyrics": track.HasLyrics,
+		"id":          track.ID,
+		"title":       track.Title,
+		"artist":      track.Artist,
+		"artists":     track.Artists,
+		"album":       track.Album,
+		"albumArtist": track.AlbumArtist,
+		"trackNumber": track.TrackNumber,
+		"trackTotal":  track.TrackTotal,
+		"discNumber":  track.DiscNumber,
+		"discTotal":   track.DiscTotal,
+		"year":        track.Year,
+		"genre":       track.Genre,
+		"composer":    track.Composer,
+		"format":      track.Format,
+		"hasCover":    track.HasCover,
+		"hasLyrics":   track.HasLyrics,
+		"duration":    track.Duration,
+		"sampleRate":  track.SampleRate,
+		"bitDepth":    track.BitDepth,
+		"bitrate":     track.Bitrate,
+		"channels":    track.Channels,
+		"codec":       track.Codec,
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,293 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"MusicPlayerWeb/service"
+)
+
+// HandlePlaylists 歌单列表：
+//
+//	GET  /api/playlists            自己的全部歌单
+//	GET  /api/playlists?user={id}  某个用户的公开歌单（无需登录）
+//	POST /api/playlists            创建歌单 {name, description, public}
+func HandlePlaylists(w http.ResponseWriter, r *http.Request) {
+	userID, _ := service.GetCurrentUserID(r)
+	switch r.Method {
+	case http.MethodGet:
+		var list []service.Playlist
+		var err error
+		if owner := r.URL.Query().Get("user"); owner != "" && owner != userID {
+			list, err = service.ListPublicPlaylists(owner)
+		} else if userID != "" {
+			list, err = service.ListPlaylists(userID)
+		} else {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		var req struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Public      bool   `json:"public"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		p, err := service.CreatePlaylist(userID, req.Name, req.Description, req.Public)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, p)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// HandlePlaylistItem 单个歌单：
+//
+//	GET    /api/playlists/{id}         歌单信息与解析后的曲目（自己的或公开的歌单）
+//	PATCH  /api/playlists/{id}         修改名称、简介或公开状态
+//	DELETE /api/playlists/{id}         删除歌单
+//	POST   /api/playlists/{id}/tracks  添加曲目 {items: [{type, id}], position}
+//	DELETE /api/playlists/{id}/tracks  删除曲目 {positions: [...]}
+//	PUT    /api/playlists/{id}/tracks  调整顺序 {from, to} 或 {order: [...]}
+//	GET|PUT|DELETE /api/playlists/{id}/cover  读取、上传或删除自定义封面
+//	GET    /api/playlists/{id}/export  导出为 M3U8、PLS 或 XSPF
+//	POST   /api/playlists/import       从歌单文件导入
+func HandlePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/playlists/")
+	id, sub, _ := strings.Cut(rest, "/")
+	userID, _ := service.GetCurrentUserID(r)
+
+	if id == "import" && sub == "" {
+		handlePlaylistImport(w, r, userID)
+		return
+	}
+	switch sub {
+	case "":
+		handlePlaylist(w, r, id, userID)
+	case "tracks":
+		handlePlaylistTracks(w, r, id, userID)
+	case "cover":
+		handlePlaylistCover(w, r, id, userID)
+	case "export":
+		handlePlaylistExport(w, r, id, userID)
+	default:
+		writeErr(w, http.StatusNotFound, "not found")
+	}
+}
+
+func handlePlaylist(w http.ResponseWriter, r *http.Request, id, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		p, err := service.GetPlaylist(id, userID)
+		if err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writePlaylistDetail(w, http.StatusOK, p, userID)
+	case http.MethodPatch:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		var req service.PlaylistUpdate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		p, err := service.UpdatePlaylist(id, userID, req)
+		if err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	case http.MethodDelete:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		if err := service.DeletePlaylist(id, userID); err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "playlist deleted"})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func handlePlaylistTracks(w http.ResponseWriter, r *http.Request, id, userID string) {
+	if userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	var req struct {
+		Items     []service.PlaylistItem `json:"items"`
+		Position  *int                   `json:"position"`
+		Positions []int                  `json:"positions"`
+		From      *int                   `json:"from"`
+		To        *int                   `json:"to"`
+		Order     []int                  `json:"order"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	var p *service.Playlist
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		pos := -1
+		if req.Position != nil {
+			pos = *req.Position
+		}
+		p, err = service.AddPlaylistItems(id, userID, req.Items, pos)
+	case http.MethodDelete:
+		p, err = service.RemovePlaylistItems(id, userID, req.Positions)
+	case http.MethodPut:
+		switch {
+		case req.Order != nil:
+			p, err = service.ReorderPlaylist(id, userID, req.Order)
+		case req.From != nil && req.To != nil:
+			p, err = service.MovePlaylistItem(id, userID, *req.From, *req.To)
+		default:
+			err = errors.New("from/to or order required")
+		}
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err != nil {
+		writeErr(w, playlistErrorStatus(err), err.Error())
+		return
+	}
+	writePlaylistDetail(w, http.StatusOK, p, userID)
+}
+
+func handlePlaylistCover(w http.ResponseWriter, r *http.Request, id, userID string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		p, err := service.GetPlaylist(id, userID)
+		if err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		img, trackID, err := service.PlaylistCover(p)
+		if err != nil {
+			writeErr(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if img == nil {
+			// 没有自定义封面时使用第一首曲目的封面
+			http.Redirect(w, r, fmt.Sprintf("/api/cover?id=%d&size=512", trackID), http.StatusFound)
+			return
+		}
+		serveMedia(w, r, mediaContent{
+			ContentType:  img.MIME,
+			ModTime:      img.ModTime,
+			ETag:         img.ETag,
+			CacheControl: "no-cache",
+			Content:      bytes.NewReader(img.Data),
+		})
+	case http.MethodPut:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		data, err := readCoverUpload(w, r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := service.SetPlaylistCover(id, userID, data); err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "cover updated"})
+	case http.MethodDelete:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		if err := service.DeletePlaylistCover(id, userID); err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "cover removed"})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// readCoverUpload 读取封面图片：multipart 表单的 cover 字段，或直接以请求体上传的图片
+func readCoverUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxPlaylistCoverSize+1<<20)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("cover")
+		if err != nil {
+			return nil, errors.New("cover file required")
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("cover image required")
+	}
+	return data, nil
+}
+
+// writePlaylistDetail 输出歌单信息与解析后的曲目列表
+func writePlaylistDetail(w http.ResponseWriter, status int, p *service.Playlist, viewerID string) {
+	entries, err := service.ResolvePlaylistEntries(p, viewerID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"id":          p.ID,
+		"user_id":     p.UserID,
+		"name":        p.Name,
+		"description": p.Description,
+		"public":      p.Public,
+		"has_cover":   p.HasCover,
+		"cover":       "/api/playlists/" + p.ID + "/cover",
+		"created_at":  p.CreatedAt,
+		"updated_at":  p.UpdatedAt,
+		"owner":       p.UserID == viewerID,
+		"tracks":      entries,
+	})
+}
+
+func playlistErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrPlaylistNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrPlaylistConflict):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"MusicPlayerWeb/service"
+)
+
+// handlePlaylistImport 导入歌单文件：POST /api/playlists/import
+// multipart 表单字段：file（.m3u/.m3u8/.pls/.xspf）、name（新歌单名，默认取文件名）、
+// playlist（已有歌单ID，指定时追加到该歌单）、format（可选，覆盖按扩展名和内容的判断）
+func handlePlaylistImport(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxPlaylistFileSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "playlist file required")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, service.MaxPlaylistFileSize+1))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(data) > service.MaxPlaylistFileSize {
+		writeErr(w, http.StatusRequestEntityTooLarge, "playlist file too large")
+		return
+	}
+
+	format := strings.ToLower(r.FormValue("format"))
+	if format == "" {
+		format = service.DetectPlaylistFormat(header.Filename, data)
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		base := path.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+		name = strings.TrimSuffix(base, path.Ext(base))
+	}
+
+	res, err := service.ImportPlaylistFile(userID, r.FormValue("playlist"), name, format, data)
+	if err != nil {
+		writeErr(w, playlistErrorStatus(err), err.Error())
+		return
+	}
+	status := http.StatusOK
+	if r.FormValue("playlist") == "" {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, res)
+}
+
+// handlePlaylistExport 导出歌单：GET /api/playlists/{id}/export
+func handlePlaylistExport(w http.ResponseWriter, r *http.Request, id, userID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	p, err := service.GetPlaylist(id, userID)
+	if err != nil {
+		writeErr(w, playlistErrorStatus(err), err.Error())
+		return
+	}
+	entries, err := service.ExportPlaylistEntries(p, userID, exportBaseURL(r))
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writePlaylistExport(w, r, p.Name, entries)
+}
+
+// handleAlbumExport 导出专辑曲目列表：GET /api/albums/{id}/export
+func handleAlbumExport(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid album id")
+		return
+	}
+	name, entries, err := service.ExportAlbumEntries(id, exportBaseURL(r))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writePlaylistExport(w, r, name, entries)
+}
+
+// HandleFavoritesExport 导出自己的收藏：GET /api/favorites/export
+func HandleFavoritesExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, err := service.GetCurrentUserID(r)
+	if err != nil || userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	entries, err := service.ExportFavoritesEntries(userID, exportBaseURL(r))
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writePlaylistExport(w, r, "我的收藏", entries)
+}
+
+// exportBaseURL 决定导出文件中的曲目位置：locations=url 时使用本站的绝对播放地址，
+// 默认使用相对曲库目录的路径，便于在本地播放器中打开
+func exportBaseURL(r *http.Request) string {
+	if r.URL.Query().Get("locations") != "url" {
+		return ""
+	}
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writePlaylistExport 按 format 参数（m3u8、pls、xspf，默认 m3u8）输出歌单文件下载
+func writePlaylistExport(w http.ResponseWriter, r *http.Request, name string, entries []service.PlaylistExportEntry) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" || format == "m3u" {
+		format = service.PlaylistFormatM3U8
+	}
+	contentType, ext, ok := service.PlaylistContentType(format)
+	if !ok {
+		writeErr(w, http.StatusBadRequest, "format must be m3u8, pls or xspf")
+		return
+	}
+	var buf bytes.Buffer
+	if err := service.WritePlaylistFile(&buf, format, name, entries); err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	filename := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+	if filename == "" {
+		filename = "playlist"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + ext}))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"MusicPlayerWeb/service"
+)
+
+// HandleRatings 曲目评分（1 到 5，0 表示清除），供智能歌单按评分筛选：
+//
+//	GET /api/ratings           自己的全部评分 {曲目ID: 评分}
+//	GET /api/ratings?id={id}   单首曲目的评分
+//	PUT /api/ratings           设置评分 {id, rating}
+func HandleRatings(w http.ResponseWriter, r *http.Request) {
+	userID, err := service.GetCurrentUserID(r)
+	if err != nil || userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		ratings, err := service.GetUserRatings(userID)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if idStr := r.URL.Query().Get("id"); idStr != "" {
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "invalid song id")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]int{"id": id, "rating": ratings[id]})
+			return
+		}
+		writeJSON(w, http.StatusOK, ratings)
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			ID     int `json:"id"`
+			Rating int `json:"rating"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if err := service.SetTrackRating(userID, req.ID, req.Rating); err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"id": req.ID, "rating": req.Rating})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
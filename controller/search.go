@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"MusicPlayerWeb/service"
+)
+
+// GET /api/search?q=...&limit=...
+// 返回按相关度排序的曲目、专辑、歌手与论坛帖子
+func HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeErr(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := 20
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	result, err := service.Search(q, limit)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// 论坛不可用时只返回曲库结果
+	posts, err := searchForumPosts(q, limit)
+	if err != nil {
+		log.Printf("搜索论坛帖子失败: %v", err)
+		posts = []ForumPost{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   q,
+		"tracks":  result.Tracks,
+		"albums":  result.Albums,
+		"artists": result.Artists,
+		"posts":   posts,
+	})
+}
+
+// searchForumPosts 按标题和内容模糊查询论坛帖子，标题命中的排在前面
+func searchForumPosts(q string, limit int) ([]ForumPost, error) {
+	esc := url.QueryEscape(postgrestQuote("*" + likeEscape(q) + "*"))
+	reqURL := fmt.Sprintf("%s/rest/v1/forum_posts?select=*&or=(title.ilike.%s,content.ilike.%s)&order=created_at.desc&limit=%d",
+		os.Getenv("SUPABASE_URL"), esc, esc, limit)
+
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("apikey", os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SUPABASE_ANON_KEY"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("请求失败: %d", resp.StatusCode)
+	}
+
+	var posts []ForumPost
+	if err := json.NewDecoder(resp.Body).Decode(&posts); err != nil {
+		return nil, err
+	}
+
+	lower := strings.ToLower(q)
+	var inTitle, inContent []ForumPost
+	for _, p := range posts {
+		if strings.Contains(strings.ToLower(p.Title), lower) {
+			inTitle = append(inTitle, p)
+		} else {
+			inContent = append(inContent, p)
+		}
+	}
+	return append(append([]ForumPost{}, inTitle...), inContent...), nil
+}
+
+// likeEscape 转义 LIKE 模式中的 %、_ 与反斜杠，使其按字面匹配
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// postgrestQuote 按 PostgREST 规则给过滤值加双引号，值中的 ,().: 等保留字符不再被当作语法解析
+func postgrestQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,317 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"MusicPlayerWeb/service"
+)
+
+// shareReq 是创建分享链接的请求体
+type shareReq struct {
+	Type      string `json:"type"` // track | upload | album
+	ID        string `json:"id"`
+	ExpiresIn int64  `json:"expires_in"` // 有效期（秒），0 表示默认 7 天
+	MaxPlays  int    `json:"max_plays"`  // 0 表示不限次数
+	Password  string `json:"password"`
+}
+
+// HandleShares 管理自己的分享链接：
+//
+//	GET  /api/shares  列出创建过的链接（含播放次数与是否过期）
+//	POST /api/shares  创建链接，返回令牌与公开播放页地址
+func HandleShares(w http.ResponseWriter, r *http.Request) {
+	userID, err := service.GetCurrentUserID(r)
+	if err != nil || userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		links, err := service.ListShareLinks(userID)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		now := time.Now()
+		out := make([]map[string]interface{}, 0, len(links))
+		for _, l := range links {
+			out = append(out, shareLinkJSON(l, now))
+		}
+		writeJSON(w, http.StatusOK, out)
+	case http.MethodPost:
+		var req shareReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		link, err := service.CreateShareLink(userID, req.Type, req.ID,
+			time.Duration(req.ExpiresIn)*time.Second, req.MaxPlays, req.Password)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, shareLinkJSON(*link, time.Now()))
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// HandleShareItem 撤销分享链接：DELETE /api/shares/{id}
+func HandleShareItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, err := service.GetCurrentUserID(r)
+	if err != nil || userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/shares/")
+	if err := service.RevokeShareLink(userID, id); err != nil {
+		writeErr(w, shareErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "share link revoked"})
+}
+
+func shareLinkJSON(l service.ShareLink, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           l.ID,
+		"type":         l.Type,
+		"target_id":    l.TargetID,
+		"title":        l.Title,
+		"token":        l.Token,
+		"url":          "/s/" + l.Token,
+		"expires_at":   l.ExpiresAt,
+		"expired":      !now.Before(l.ExpiresAt),
+		"max_plays":    l.MaxPlays,
+		"play_count":   l.PlayCount,
+		"has_password": l.HasPassword,
+		"created_at":   l.CreatedAt,
+	}
+}
+
+// HandlePublicShare 公开的分享接口，无需登录：
+//
+//	GET  /api/share/{token}         分享内容与可播放的曲目列表（设了密码且未解锁时返回 401）
+//	POST /api/share/{token}/unlock  提交密码，通过后写入只对该链接有效的 Cookie（连续错误过多时返回 429）
+//	POST /api/share/{token}/play    开始播放一项 {id}：计一次播放并返回带播放凭证的地址
+func HandlePublicShare(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/share/")
+	token, action, _ := strings.Cut(rest, "/")
+	link, err := service.ResolveShareToken(token)
+	if err != nil {
+		writeErr(w, shareErrorStatus(err), err.Error())
+		return
+	}
+
+	switch {
+	case action == "unlock" && r.Method == http.MethodPost:
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		value, err := link.Unlock(req.Password)
+		if errors.Is(err, service.ErrSharePassword) {
+			writeErr(w, http.StatusUnauthorized, "wrong password")
+			return
+		}
+		if err != nil {
+			writeErr(w, shareErrorStatus(err), err.Error())
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareCookieName(link),
+			Value:    value,
+			Path:     "/",
+			Expires:  link.ExpiresAt,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		writeJSON(w, http.StatusOK, map[string]string{"message": "unlocked"})
+	case action == "play" && r.Method == http.MethodPost:
+		handleSharePlay(w, r, link, token)
+	case action == "" && r.Method == http.MethodGet:
+		if !shareUnlocked(r, link) {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"error":             service.ErrSharePassword.Error(),
+				"password_required": true,
+			})
+			return
+		}
+		info, err := shareInfo(link)
+		if err != nil {
+			writeErr(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// shareInfo 返回公开播放页需要的内容。播放地址需通过 POST /api/share/{token}/play 领取
+func shareInfo(link *service.ShareLink) (map[string]interface{}, error) {
+	tracks := []map[string]interface{}{}
+	addTrack := func(t service.Track) {
+		item := map[string]interface{}{
+			"id":       strconv.Itoa(t.ID),
+			"title":    t.Title,
+			"artist":   t.Artist,
+			"album":    t.Album,
+			"duration": t.Duration,
+		}
+		if t.HasCover {
+			item["cover"] = fmt.Sprintf("/api/cover?id=%d&size=256", t.ID)
+		}
+		tracks = append(tracks, item)
+	}
+
+	switch link.Type {
+	case service.ShareTrack:
+		var id int
+		fmt.Sscan(link.TargetID, &id)
+		t, err := service.GetTrack(id)
+		if err != nil {
+			return nil, err
+		}
+		addTrack(t)
+	case service.ShareAlbum:
+		var id int
+		fmt.Sscan(link.TargetID, &id)
+		list, err := service.ListAlbumTracksByID(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			addTrack(t)
+		}
+	case service.ShareUpload:
+		mf, err := service.GetMusicFileByID(link.TargetID, link.UserID)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, map[string]interface{}{
+			"id":     mf.ID,
+			"title":  mf.Title,
+			"artist": mf.Artist,
+			"album":  mf.Album,
+		})
+	}
+
+	return map[string]interface{}{
+		"type":       link.Type,
+		"title":      link.Title,
+		"expires_at": link.ExpiresAt,
+		"max_plays":  link.MaxPlays,
+		"play_count": link.PlayCount,
+		"tracks":     tracks,
+	}, nil
+}
+
+// handleSharePlay 访客开始播放分享中的一项：计一次播放（达到上限时返回 403）并返回播放地址。
+// 上传文件的地址带短期播放凭证，凭证有效期内的 Range 与拖动请求不再计数；
+// 曲库曲目本身可公开播放，返回普通的 /api/audio 地址，计数只统计分享页的播放
+func handleSharePlay(w http.ResponseWriter, r *http.Request, link *service.ShareLink, token string) {
+	if !shareUnlocked(r, link) {
+		writeErr(w, http.StatusUnauthorized, service.ErrSharePassword.Error())
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if !link.CoversItem(req.ID) {
+		writeErr(w, http.StatusForbidden, "not covered by this share link")
+		return
+	}
+	if err := service.CountSharePlay(link); err != nil {
+		writeErr(w, shareErrorStatus(err), err.Error())
+		return
+	}
+	src := "/api/audio?id=" + url.QueryEscape(req.ID)
+	if link.Type == service.ShareUpload {
+		ticket, err := link.PlayTicket(req.ID)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		src = "/api/cloud/stream?id=" + url.QueryEscape(req.ID) +
+			"&share=" + url.QueryEscape(token) + "&ticket=" + url.QueryEscape(ticket)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"src":        src,
+		"max_plays":  link.MaxPlays,
+		"play_count": link.PlayCount,
+	})
+}
+
+func shareCookieName(link *service.ShareLink) string {
+	return "share_" + link.ID
+}
+
+func shareUnlocked(r *http.Request, link *service.ShareLink) bool {
+	value := ""
+	if c, err := r.Cookie(shareCookieName(link)); err == nil {
+		value = c.Value
+	}
+	return link.Unlocked(value)
+}
+
+// authorizeShare 校验请求中的分享令牌（有效期、撤销、密码）与 handleSharePlay 签发的播放凭证；
+// item 为请求的曲库曲目ID或上传文件ID。失败时已写出错误响应
+func authorizeShare(w http.ResponseWriter, r *http.Request, token, item string) (*service.ShareLink, bool) {
+	link, err := service.ResolveShareToken(token)
+	if err != nil {
+		writeErr(w, shareErrorStatus(err), err.Error())
+		return nil, false
+	}
+	if !shareUnlocked(r, link) {
+		writeErr(w, http.StatusUnauthorized, service.ErrSharePassword.Error())
+		return nil, false
+	}
+	if !link.CoversItem(item) {
+		writeErr(w, http.StatusForbidden, "not covered by this share link")
+		return nil, false
+	}
+	if !link.CheckPlayTicket(item, r.URL.Query().Get("ticket")) {
+		writeErr(w, http.StatusForbidden, "play ticket missing or expired")
+		return nil, false
+	}
+	return link, true
+}
+
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrShareNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrShareExpired):
+		return http.StatusGone
+	case errors.Is(err, service.ErrSharePlayLimit):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrSharePassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrShareUnlockThrottled):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// HandleSharePage 公开播放页：GET /s/{token}
+func HandleSharePage(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "web/share.html")
+}
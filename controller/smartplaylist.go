@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"MusicPlayerWeb/service"
+)
+
+// HandleSmartPlaylists 智能歌单列表：
+//
+//	GET  /api/smart-playlists            自己的全部智能歌单（只有定义，不求值）
+//	GET  /api/smart-playlists?user={id}  某个用户的公开智能歌单
+//	POST /api/smart-playlists            创建 {name, description, public, rules, sort, limit}
+//	POST /api/smart-playlists/preview    不保存，直接按 {rules, sort, limit} 求值
+func HandleSmartPlaylists(w http.ResponseWriter, r *http.Request) {
+	userID, _ := service.GetCurrentUserID(r)
+	switch r.Method {
+	case http.MethodGet:
+		var list []service.SmartPlaylist
+		var err error
+		if owner := r.URL.Query().Get("user"); owner != "" && owner != userID {
+			list, err = service.ListSmartPlaylists(owner, true)
+		} else if userID != "" {
+			list, err = service.ListSmartPlaylists(userID, false)
+		} else {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		var req service.SmartPlaylistInput
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		p, err := service.CreateSmartPlaylist(userID, req)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, p)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// HandleSmartPlaylistItem 单个智能歌单：
+//
+//	GET    /api/smart-playlists/{id}         定义与当前曲库下的求值结果（自己的或公开的）
+//	PATCH  /api/smart-playlists/{id}         修改名称、简介、公开状态、规则、排序或数量上限
+//	DELETE /api/smart-playlists/{id}         删除
+//	GET    /api/smart-playlists/{id}/export  以 M3U8、PLS 或 XSPF 导出当前结果
+func HandleSmartPlaylistItem(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/smart-playlists/")
+	id, sub, _ := strings.Cut(rest, "/")
+	userID, _ := service.GetCurrentUserID(r)
+
+	if id == "preview" && sub == "" {
+		handleSmartPlaylistPreview(w, r, userID)
+		return
+	}
+	switch {
+	case sub == "export":
+		handleSmartPlaylistExport(w, r, id, userID)
+	case sub != "":
+		writeErr(w, http.StatusNotFound, "not found")
+	case r.Method == http.MethodGet:
+		p, err := service.GetSmartPlaylist(id, userID)
+		if err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writeSmartPlaylistDetail(w, p, userID)
+	case r.Method == http.MethodPatch:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		var req service.SmartPlaylistInput
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		p, err := service.UpdateSmartPlaylist(id, userID, req)
+		if err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writeSmartPlaylistDetail(w, p, userID)
+	case r.Method == http.MethodDelete:
+		if userID == "" {
+			writeErr(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		if err := service.DeleteSmartPlaylist(id, userID); err != nil {
+			writeErr(w, playlistErrorStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "smart playlist deleted"})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func handleSmartPlaylistPreview(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	var req struct {
+		Rules service.SmartRule `json:"rules"`
+		Sort  string            `json:"sort"`
+		Limit int               `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	list, err := service.NewSmartEvaluator(userID).Evaluate(req.Rules, req.Sort, req.Limit)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":  len(list),
+		"tracks": service.SmartPlaylistEntries(list),
+	})
+}
+
+func handleSmartPlaylistExport(w http.ResponseWriter, r *http.Request, id, userID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	p, err := service.GetSmartPlaylist(id, userID)
+	if err != nil {
+		writeErr(w, playlistErrorStatus(err), err.Error())
+		return
+	}
+	list, err := service.EvaluateSmartPlaylist(p)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writePlaylistExport(w, r, p.Name, service.ExportSmartPlaylistEntries(list, exportBaseURL(r)))
+}
+
+// writeSmartPlaylistDetail 输出智能歌单定义与求值结果
+func writeSmartPlaylistDetail(w http.ResponseWriter, p *service.SmartPlaylist, viewerID string) {
+	list, err := service.EvaluateSmartPlaylist(p)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var duration float64
+	for _, t := range list {
+		duration += t.Duration
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":          p.ID,
+		"user_id":     p.UserID,
+		"name":        p.Name,
+		"description": p.Description,
+		"public":      p.Public,
+		"rules":       p.Rules,
+		"sort":        p.Sort,
+		"limit":       p.Limit,
+		"created_at":  p.CreatedAt,
+		"updated_at":  p.UpdatedAt,
+		"owner":       p.UserID == viewerID,
+		"count":       len(list),
+		"duration":    duration,
+		"tracks":      service.SmartPlaylistEntries(list),
+	})
+}
@@ -0,0 +1,1099 @@
+package controller
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"MusicPlayerWeb/service"
+)
+
+// subsonicAPIVersion 是兼容的 Subsonic REST API 版本
+const subsonicAPIVersion = "1.16.1"
+
+// Subsonic 错误码，见 http://www.subsonic.org/pages/api.jsp
+const (
+	subsonicErrGeneric      = 0
+	subsonicErrMissingParam = 10
+	subsonicErrAuth         = 40
+	subsonicErrNotFound     = 70
+)
+
+// subsonicFavoritesPlaylist 是把收藏列表作为歌单提供给客户端时使用的歌单ID
+const subsonicFavoritesPlaylist = "favorites"
+
+// subsonicResponse 是所有 Subsonic 接口的响应外壳，同时支持 XML 与 JSON（f=json）输出
+type subsonicResponse struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns         string   `xml:"xmlns,attr" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error                  *subsonicError         `xml:"error,omitempty" json:"error,omitempty"`
+	License                *subsonicLicense       `xml:"license,omitempty" json:"license,omitempty"`
+	OpenSubsonicExtensions []subsonicExtension    `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	Artists                *subsonicArtists       `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist                 *subsonicArtist        `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album                  *subsonicAlbum         `xml:"album,omitempty" json:"album,omitempty"`
+	Song                   *subsonicChild         `xml:"song,omitempty" json:"song,omitempty"`
+	Lyrics                 *subsonicLyrics        `xml:"lyrics,omitempty" json:"lyrics,omitempty"`
+	LyricsList             *subsonicLyricsList    `xml:"lyricsList,omitempty" json:"lyricsList,omitempty"`
+	Playlists              *subsonicPlaylists     `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist               *subsonicPlaylist      `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	SearchResult3          *subsonicSearchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+}
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicExtension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+type subsonicArtists struct {
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []subsonicIndex `xml:"index" json:"index"`
+}
+
+type subsonicIndex struct {
+	Name   string           `xml:"name,attr" json:"name"`
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID         string          `xml:"id,attr" json:"id"`
+	Name       string          `xml:"name,attr" json:"name"`
+	CoverArt   string          `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int             `xml:"albumCount,attr" json:"albumCount"`
+	Album      []subsonicAlbum `xml:"album,omitempty" json:"album,omitempty"`
+}
+
+type subsonicAlbum struct {
+	ID        string          `xml:"id,attr" json:"id"`
+	Name      string          `xml:"name,attr" json:"name"`
+	Artist    string          `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string          `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string          `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int             `xml:"songCount,attr" json:"songCount"`
+	Duration  int             `xml:"duration,attr" json:"duration"`
+	Created   string          `xml:"created,attr" json:"created"`
+	Year      int             `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre     string          `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	Song      []subsonicChild `xml:"song,omitempty" json:"song,omitempty"`
+}
+
+// subsonicChild 是一首歌曲（Subsonic 的 Child 元素）
+type subsonicChild struct {
+	ID           string `xml:"id,attr" json:"id"`
+	Parent       string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir        bool   `xml:"isDir,attr" json:"isDir"`
+	Title        string `xml:"title,attr" json:"title"`
+	Album        string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist       string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track        int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year         int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre        string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt     string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size         int64  `xml:"size,attr" json:"size"`
+	ContentType  string `xml:"contentType,attr" json:"contentType"`
+	Suffix       string `xml:"suffix,attr" json:"suffix"`
+	Duration     int    `xml:"duration,attr" json:"duration"`
+	BitRate      int    `xml:"bitRate,attr,omitempty" json:"bitRate,omitempty"`
+	DiscNumber   int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	AlbumID      string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID     string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type         string `xml:"type,attr" json:"type"`
+	Starred      string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	Created      string `xml:"created,attr,omitempty" json:"created,omitempty"`
+	SamplingRate int    `xml:"samplingRate,attr,omitempty" json:"samplingRate,omitempty"`
+	BitDepth     int    `xml:"bitDepth,attr,omitempty" json:"bitDepth,omitempty"`
+	ChannelCount int    `xml:"channelCount,attr,omitempty" json:"channelCount,omitempty"`
+}
+
+type subsonicLyrics struct {
+	Artist string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Title  string `xml:"title,attr,omitempty" json:"title,omitempty"`
+	Value  string `xml:",chardata" json:"value"`
+}
+
+// subsonicLyricsList 是 OpenSubsonic songLyrics 扩展的结构化歌词
+type subsonicLyricsList struct {
+	StructuredLyrics []subsonicStructuredLyrics `xml:"structuredLyrics" json:"structuredLyrics"`
+}
+
+type subsonicStructuredLyrics struct {
+	Lang          string              `xml:"lang,attr" json:"lang"`
+	Synced        bool                `xml:"synced,attr" json:"synced"`
+	DisplayArtist string              `xml:"displayArtist,attr,omitempty" json:"displayArtist,omitempty"`
+	DisplayTitle  string              `xml:"displayTitle,attr,omitempty" json:"displayTitle,omitempty"`
+	Line          []subsonicLyricLine `xml:"line" json:"line"`
+}
+
+type subsonicLyricLine struct {
+	Start *int   `xml:"start,attr,omitempty" json:"start,omitempty"`
+	Value string `xml:",chardata" json:"value"`
+}
+
+type subsonicPlaylists struct {
+	Playlist []subsonicPlaylist `xml:"playlist" json:"playlist"`
+}
+
+type subsonicPlaylist struct {
+	ID        string          `xml:"id,attr" json:"id"`
+	Name      string          `xml:"name,attr" json:"name"`
+	Owner     string          `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Public    bool            `xml:"public,attr" json:"public"`
+	SongCount int             `xml:"songCount,attr" json:"songCount"`
+	Duration  int             `xml:"duration,attr" json:"duration"`
+	Created   string          `xml:"created,attr" json:"created"`
+	Changed   string          `xml:"changed,attr" json:"changed"`
+	Entry     []subsonicChild `xml:"entry,omitempty" json:"entry,omitempty"`
+}
+
+type subsonicSearchResult3 struct {
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+	Album  []subsonicAlbum  `xml:"album" json:"album"`
+	Song   []subsonicChild  `xml:"song" json:"song"`
+}
+
+// subsonicLibrary 是处理一次请求时用到的曲库关联信息：曲目所属专辑/歌手ID、专辑统计与用户收藏
+type subsonicLibrary struct {
+	refs    map[int]service.TrackRef
+	albums  map[int]*albumStats
+	starred map[string]string // song_id -> 收藏时间
+}
+
+type albumStats struct {
+	duration float64
+	year     int
+	genre    string
+	modTime  int64
+}
+
+// HandleSubsonic 处理 Subsonic REST API：/rest/{method}.view（也接受不带 .view 的写法），
+// 参数可以在查询串或 POST 表单中。认证使用 u + t/s（token+salt）或 u + p，
+// 见 service.AuthenticateSubsonic
+func HandleSubsonic(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	method := strings.TrimSuffix(path.Base(r.URL.Path), ".view")
+
+	// 扩展列表按 OpenSubsonic 规范无需认证
+	if method == "getOpenSubsonicExtensions" {
+		writeSubsonic(w, r, &subsonicResponse{OpenSubsonicExtensions: []subsonicExtension{{Name: "songLyrics", Versions: []int{1}}}})
+		return
+	}
+
+	q := r.Form
+	if q.Get("u") == "" || (q.Get("t") == "" && q.Get("p") == "") {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: u, t/s or p")
+		return
+	}
+	userID, err := service.AuthenticateSubsonic(q.Get("u"), q.Get("t"), q.Get("s"), q.Get("p"))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrAuth, err.Error())
+		return
+	}
+
+	switch method {
+	case "ping":
+		writeSubsonic(w, r, &subsonicResponse{})
+	case "getLicense":
+		writeSubsonic(w, r, &subsonicResponse{License: &subsonicLicense{Valid: true}})
+	case "getArtists":
+		subsonicGetArtists(w, r)
+	case "getArtist":
+		subsonicGetArtist(w, r)
+	case "getAlbum":
+		subsonicGetAlbum(w, r, userID)
+	case "getSong":
+		subsonicGetSong(w, r, userID)
+	case "stream":
+		subsonicStream(w, r, false)
+	case "download":
+		subsonicStream(w, r, true)
+	case "getCoverArt":
+		subsonicGetCoverArt(w, r)
+	case "getLyrics":
+		subsonicGetLyrics(w, r)
+	case "getLyricsBySongId":
+		subsonicGetLyricsBySongID(w, r)
+	case "star":
+		subsonicStar(w, r, userID, true)
+	case "unstar":
+		subsonicStar(w, r, userID, false)
+	case "setRating":
+		subsonicSetRating(w, r, userID)
+	case "getPlaylists":
+		subsonicGetPlaylists(w, r, userID)
+	case "getPlaylist":
+		subsonicGetPlaylist(w, r, userID)
+	case "search3":
+		subsonicSearch3(w, r, userID)
+	case "scrobble":
+		subsonicScrobble(w, r, userID)
+	default:
+		writeSubsonicError(w, r, subsonicErrNotFound, "unknown method: "+method)
+	}
+}
+
+// jsonpCallbackPattern 限制 JSONP 回调名为 JavaScript 标识符（可带 . 访问属性）
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+// writeSubsonic 按 f 参数输出 XML（默认）、JSON 或 JSONP
+func writeSubsonic(w http.ResponseWriter, r *http.Request, resp *subsonicResponse) {
+	f, cb := r.Form.Get("f"), r.Form.Get("callback")
+	if f == "jsonp" && cb != "" && !jsonpCallbackPattern.MatchString(cb) {
+		// 回调名会原样写入脚本，不合法时以普通 JSON 返回错误
+		f, cb = "json", ""
+		resp = &subsonicResponse{Status: "failed", Error: &subsonicError{Code: subsonicErrGeneric, Message: "invalid callback"}}
+	}
+	resp.Xmlns = "http://subsonic.org/restapi"
+	if resp.Status == "" {
+		resp.Status = "ok"
+	}
+	resp.Version = subsonicAPIVersion
+	resp.Type = "musicplayerweb"
+	resp.ServerVersion = "1.0.0"
+	resp.OpenSubsonic = true
+
+	switch f {
+	case "json", "jsonp":
+		body, err := json.Marshal(map[string]interface{}{"subsonic-response": resp})
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if f == "jsonp" && cb != "" {
+			w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+			_, _ = w.Write([]byte(cb + "("))
+			_, _ = w.Write(body)
+			_, _ = w.Write([]byte(");"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write(body)
+	default:
+		body, err := xml.Marshal(resp)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		_, _ = w.Write([]byte(xml.Header))
+		_, _ = w.Write(body)
+	}
+}
+
+// writeSubsonicError 输出 Subsonic 错误，HTTP 状态码按协议保持 200
+func writeSubsonicError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	writeSubsonic(w, r, &subsonicResponse{Status: "failed", Error: &subsonicError{Code: code, Message: msg}})
+}
+
+// Subsonic ID：歌曲直接使用曲目ID，专辑与歌手加前缀以便 getCoverArt 区分
+func subsonicAlbumID(id int) string  { return "al-" + strconv.Itoa(id) }
+func subsonicArtistID(id int) string { return "ar-" + strconv.Itoa(id) }
+
+// parseSubsonicID 解析带前缀的专辑/歌手ID，同时接受不带前缀的纯数字
+func parseSubsonicID(s, prefix string) (int, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(s, prefix))
+	return id, err == nil
+}
+
+// loadSubsonicLibrary 读取曲目关联与用户收藏，收藏读取失败时视为没有收藏
+func loadSubsonicLibrary(userID string) (*subsonicLibrary, error) {
+	refs, err := service.ListTrackRefs()
+	if err != nil {
+		return nil, err
+	}
+	list, err := service.ListTracks()
+	if err != nil {
+		return nil, err
+	}
+	lib := &subsonicLibrary{refs: refs, albums: map[int]*albumStats{}, starred: map[string]string{}}
+	for _, t := range list {
+		id := refs[t.ID].AlbumID
+		if id == 0 {
+			continue
+		}
+		st := lib.albums[id]
+		if st == nil {
+			st = &albumStats{}
+			lib.albums[id] = st
+		}
+		st.duration += t.Duration
+		st.year = max(st.year, t.Year)
+		if st.genre == "" {
+			st.genre = t.Genre
+		}
+		st.modTime = max(st.modTime, t.ModTime)
+	}
+	if userID != "" {
+		if favs, err := service.GetUserFavorites(userID); err == nil {
+			for _, f := range favs {
+				lib.starred[f.SongID] = subsonicTime(f.CreatedAt)
+			}
+		}
+	}
+	return lib, nil
+}
+
+// subsonicTime 把收藏时间（2006-01-02 15:04:05）转为 ISO 8601
+func subsonicTime(s string) string {
+	if t, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func (lib *subsonicLibrary) child(t service.Track) subsonicChild {
+	ref := lib.refs[t.ID]
+	suffix := strings.TrimPrefix(strings.ToLower(path.Ext(t.RelPath)), ".")
+	if suffix == "" {
+		suffix = t.Format
+	}
+	c := subsonicChild{
+		ID:           strconv.Itoa(t.ID),
+		Title:        t.Title,
+		Album:        t.Album,
+		Artist:       t.Artist,
+		Track:        t.TrackNumber,
+		Year:         t.Year,
+		Genre:        t.Genre,
+		CoverArt:     strconv.Itoa(t.ID),
+		Size:         t.Size,
+		ContentType:  subsonicContentType(suffix),
+		Suffix:       suffix,
+		Duration:     int(t.Duration + 0.5),
+		BitRate:      t.Bitrate,
+		DiscNumber:   t.DiscNumber,
+		Type:         "music",
+		Starred:      lib.starred[strconv.Itoa(t.ID)],
+		Created:      time.Unix(0, t.ModTime).UTC().Format(time.RFC3339),
+		SamplingRate: t.SampleRate,
+		BitDepth:     t.BitDepth,
+		ChannelCount: t.Channels,
+	}
+	if ref.AlbumID != 0 {
+		c.Parent = subsonicAlbumID(ref.AlbumID)
+		c.AlbumID = c.Parent
+	}
+	if ref.ArtistID != 0 {
+		c.ArtistID = subsonicArtistID(ref.ArtistID)
+	}
+	return c
+}
+
+func (lib *subsonicLibrary) album(al service.Album, artistIDs map[string]int) subsonicAlbum {
+	out := subsonicAlbum{
+		ID:        subsonicAlbumID(al.ID),
+		Name:      al.Name,
+		Artist:    al.Artist,
+		CoverArt:  subsonicAlbumID(al.ID),
+		SongCount: al.Count,
+	}
+	if id, ok := artistIDs[strings.ToLower(al.Artist)]; ok {
+		out.ArtistID = subsonicArtistID(id)
+	}
+	if st := lib.albums[al.ID]; st != nil {
+		out.Duration = int(st.duration + 0.5)
+		out.Year = st.year
+		out.Genre = st.genre
+		out.Created = time.Unix(0, st.modTime).UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// subsonicContentType 根据后缀返回 MIME 类型，供客户端判断是否能直接播放
+func subsonicContentType(suffix string) string {
+	switch suffix {
+	case "mp3":
+		return "audio/mpeg"
+	case "flac":
+		return "audio/flac"
+	case "m4a", "m4b", "mp4", "alac":
+		return "audio/mp4"
+	case "aac":
+		return "audio/aac"
+	case "ogg", "oga":
+		return "audio/ogg"
+	case "opus":
+		return "audio/ogg; codecs=opus"
+	case "wav":
+		return "audio/wav"
+	case "dsf":
+		return "audio/x-dsf"
+	}
+	return "application/octet-stream"
+}
+
+// subsonicArtistList 返回歌手列表及按小写名称索引的歌手ID
+func subsonicArtistList() ([]subsonicArtist, map[string]int, error) {
+	artists, err := service.ListArtists()
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make([]subsonicArtist, 0, len(artists))
+	ids := make(map[string]int, len(artists))
+	for _, a := range artists {
+		name, _ := a["name"].(string)
+		id, _ := a["id"].(int)
+		albumCount, _ := a["albumCount"].(int)
+		ids[strings.ToLower(name)] = id
+		out = append(out, subsonicArtist{
+			ID:         subsonicArtistID(id),
+			Name:       name,
+			CoverArt:   subsonicArtistID(id),
+			AlbumCount: albumCount,
+		})
+	}
+	return out, ids, nil
+}
+
+// getArtists：按首字母（中文按拼音）分组的歌手索引
+func subsonicGetArtists(w http.ResponseWriter, r *http.Request) {
+	artists, _, err := subsonicArtistList()
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	groups := map[string][]subsonicArtist{}
+	for _, a := range artists {
+		letter := service.IndexLetter(a.Name)
+		groups[letter] = append(groups[letter], a)
+	}
+	letters := make([]string, 0, len(groups))
+	for l := range groups {
+		letters = append(letters, l)
+	}
+	// "#" 排在字母之后
+	sort.Slice(letters, func(i, j int) bool {
+		if (letters[i] == "#") != (letters[j] == "#") {
+			return letters[j] == "#"
+		}
+		return letters[i] < letters[j]
+	})
+	res := &subsonicArtists{Index: []subsonicIndex{}}
+	for _, l := range letters {
+		res.Index = append(res.Index, subsonicIndex{Name: l, Artist: groups[l]})
+	}
+	writeSubsonic(w, r, &subsonicResponse{Artists: res})
+}
+
+// getArtist：歌手信息及其参与的专辑
+func subsonicGetArtist(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseSubsonicID(r.Form.Get("id"), "ar-")
+	if !ok {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: id")
+		return
+	}
+	artist, err := service.GetArtistByID(id)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, "artist not found")
+		return
+	}
+	name, _ := artist["name"].(string)
+	albums, err := service.ListArtistAlbums(name)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	lib, err := loadSubsonicLibrary("")
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	_, artistIDs, err := subsonicArtistList()
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	res := &subsonicArtist{ID: subsonicArtistID(id), Name: name, CoverArt: subsonicArtistID(id), AlbumCount: len(albums)}
+	for _, al := range albums {
+		res.Album = append(res.Album, lib.album(al, artistIDs))
+	}
+	writeSubsonic(w, r, &subsonicResponse{Artist: res})
+}
+
+// getAlbum：专辑信息及按碟号、音轨号排序的歌曲
+func subsonicGetAlbum(w http.ResponseWriter, r *http.Request, userID string) {
+	id, ok := parseSubsonicID(r.Form.Get("id"), "al-")
+	if !ok {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: id")
+		return
+	}
+	al, err := service.GetAlbum(id)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, "album not found")
+		return
+	}
+	list, err := service.ListAlbumTracksByID(id)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, err.Error())
+		return
+	}
+	lib, err := loadSubsonicLibrary(userID)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	_, artistIDs, err := subsonicArtistList()
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	res := lib.album(al, artistIDs)
+	res.Song = make([]subsonicChild, 0, len(list))
+	for _, t := range list {
+		res.Song = append(res.Song, lib.child(t))
+	}
+	writeSubsonic(w, r, &subsonicResponse{Album: &res})
+}
+
+func subsonicGetSong(w http.ResponseWriter, r *http.Request, userID string) {
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: id")
+		return
+	}
+	t, err := service.GetTrack(id)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+		return
+	}
+	lib, err := loadSubsonicLibrary(userID)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	c := lib.child(t)
+	writeSubsonic(w, r, &subsonicResponse{Song: &c})
+}
+
+// stream：按 maxBitRate / format 选择转码档位，无法转码时退回原文件；download 总是输出原文件
+func subsonicStream(w http.ResponseWriter, r *http.Request, raw bool) {
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: id")
+		return
+	}
+	t, err := service.GetTrack(id)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+		return
+	}
+	maxBitRate, _ := strconv.Atoi(r.Form.Get("maxBitRate"))
+
+	var f *os.File
+	var ctype string
+	if profile := subsonicProfile(t, r.Form.Get("format"), maxBitRate); profile != "" && !raw {
+		f, ctype, err = service.ReadTranscodedAudio(r.Context(), id, profile)
+		if errors.Is(err, service.ErrTranscodeUnavailable) {
+			f, ctype, err = service.ReadAudio(id)
+		}
+	} else {
+		f, ctype, err = service.ReadAudio(id)
+	}
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, err.Error())
+		return
+	}
+	defer f.Close()
+	serveMediaFile(w, r, f, ctype, "no-cache")
+}
+
+// subsonicProfile 选择转码档位：format 指定编码时使用对应档位；
+// 只给出 maxBitRate 且原文件码率更高时，选不超过该码率的最高档位（都超过时选最低档）
+func subsonicProfile(t service.Track, format string, maxBitRate int) string {
+	profiles := service.ListTranscodeProfiles()
+	if format == "raw" {
+		return ""
+	}
+	if format != "" {
+		for _, p := range profiles {
+			if p.Codec == format || p.Name == format {
+				return p.Name
+			}
+		}
+	}
+	if maxBitRate <= 0 || (t.Bitrate > 0 && t.Bitrate <= maxBitRate) {
+		return ""
+	}
+	best, lowest := "", ""
+	bestRate, lowestRate := 0, 0
+	for _, p := range profiles {
+		if p.Bitrate <= maxBitRate && p.Bitrate > bestRate {
+			best, bestRate = p.Name, p.Bitrate
+		}
+		if lowest == "" || p.Bitrate < lowestRate {
+			lowest, lowestRate = p.Name, p.Bitrate
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return lowest
+}
+
+// getCoverArt：id 可以是歌曲ID、专辑ID（al-）或歌手ID（ar-）
+func subsonicGetCoverArt(w http.ResponseWriter, r *http.Request) {
+	raw := r.Form.Get("id")
+	trackID := 0
+	switch {
+	case strings.HasPrefix(raw, "al-"):
+		id, _ := parseSubsonicID(raw, "al-")
+		al, err := service.GetAlbum(id)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrNotFound, "cover art not found")
+			return
+		}
+		trackID = al.CoverTrackID
+	case strings.HasPrefix(raw, "ar-"):
+		id, _ := parseSubsonicID(raw, "ar-")
+		artist, err := service.GetArtistByID(id)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrNotFound, "cover art not found")
+			return
+		}
+		trackID, _ = artist["coverTrackID"].(int)
+	default:
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: id")
+			return
+		}
+		trackID = id
+	}
+	size, _ := strconv.Atoi(r.Form.Get("size"))
+	serveCover(w, r, trackID, size, "jpeg")
+}
+
+// getLyrics：按歌手和标题查找歌词，找不到时按协议返回空歌词
+func subsonicGetLyrics(w http.ResponseWriter, r *http.Request) {
+	artist, title := r.Form.Get("artist"), r.Form.Get("title")
+	res := &subsonicLyrics{}
+	if t, err := service.FindTrack(artist, title); err == nil {
+		if lyrics, err := service.GetTimedLyrics(t.ID); err == nil {
+			res.Artist, res.Title = t.Artist, t.Title
+			lines := make([]string, 0, len(lyrics.Lines))
+			for _, l := range lyrics.Lines {
+				lines = append(lines, l.Text)
+			}
+			res.Value = strings.Join(lines, "\n")
+		}
+	}
+	writeSubsonic(w, r, &subsonicResponse{Lyrics: res})
+}
+
+// getLyricsBySongId（OpenSubsonic）：逐句时间轴歌词，翻译作为第二份歌词返回
+func subsonicGetLyricsBySongID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: id")
+		return
+	}
+	t, err := service.GetTrack(id)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+		return
+	}
+	res := &subsonicLyricsList{StructuredLyrics: []subsonicStructuredLyrics{}}
+	if lyrics, err := service.GetTimedLyrics(id); err == nil && len(lyrics.Lines) > 0 {
+		orig := subsonicStructuredLyrics{Lang: "xxx", Synced: lyrics.Synced, DisplayArtist: t.Artist, DisplayTitle: t.Title}
+		trans := subsonicStructuredLyrics{Lang: "und", Synced: lyrics.Synced, DisplayArtist: t.Artist, DisplayTitle: t.Title}
+		for _, l := range lyrics.Lines {
+			var start *int
+			if lyrics.Synced {
+				start = &l.Time
+			}
+			orig.Line = append(orig.Line, subsonicLyricLine{Start: start, Value: l.Text})
+			if l.Translation != "" {
+				trans.Line = append(trans.Line, subsonicLyricLine{Start: start, Value: l.Translation})
+			}
+		}
+		res.StructuredLyrics = append(res.StructuredLyrics, orig)
+		if len(trans.Line) > 0 {
+			res.StructuredLyrics = append(res.StructuredLyrics, trans)
+		}
+	}
+	writeSubsonic(w, r, &subsonicResponse{LyricsList: res})
+}
+
+// star / unstar：歌曲写入收藏表；收藏表只记录歌曲，albumId 与 artistId 会被忽略
+func subsonicStar(w http.ResponseWriter, r *http.Request, userID string, star bool) {
+	for _, raw := range r.Form["id"] {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			continue
+		}
+		t, err := service.GetTrack(id)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrNotFound, "song not found: "+raw)
+			return
+		}
+		songID := strconv.Itoa(t.ID)
+		if star {
+			if exists, err := service.CheckUserFavorite(userID, songID); err == nil && exists {
+				continue
+			}
+			err = service.AddUserFavorite(userID, songID, t.Title, t.Artist, t.Album)
+		} else {
+			err = service.DeleteUserFavorite(userID, songID)
+		}
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+	}
+	writeSubsonic(w, r, &subsonicResponse{})
+}
+
+// favoritesPlaylist 把用户收藏组装成一个只读歌单，曲库中已不存在的歌曲被跳过
+func favoritesPlaylist(userID, owner string, withEntries bool) (*subsonicPlaylist, error) {
+	favs, err := service.GetUserFavorites(userID)
+	if err != nil {
+		return nil, err
+	}
+	lib, err := loadSubsonicLibrary(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	pl := &subsonicPlaylist{ID: subsonicFavoritesPlaylist, Name: "我的收藏", Owner: owner, Created: now, Changed: now}
+	var duration float64
+	for i, f := range favs {
+		id, err := strconv.Atoi(f.SongID)
+		if err != nil {
+			continue
+		}
+		t, err := service.GetTrack(id)
+		if err != nil {
+			continue
+		}
+		pl.SongCount++
+		duration += t.Duration
+		if i == 0 {
+			pl.Changed = subsonicTime(f.CreatedAt)
+		}
+		pl.Created = subsonicTime(f.CreatedAt)
+		if withEntries {
+			pl.Entry = append(pl.Entry, lib.child(t))
+		}
+	}
+	pl.Duration = int(duration + 0.5)
+	return pl, nil
+}
+
+// 服务端歌单与智能歌单在 Subsonic 中的ID前缀
+const (
+	subsonicPlaylistPrefix      = "pl-"
+	subsonicSmartPlaylistPrefix = "sp-"
+)
+
+// serverPlaylist 把服务端歌单转换为 Subsonic 歌单。Subsonic 歌曲ID只对应曲库曲目，
+// 上传文件与曲库中已不存在的曲目被跳过
+func serverPlaylist(p *service.Playlist, lib *subsonicLibrary, owner string, withEntries bool) subsonicPlaylist {
+	var list []service.Track
+	for _, it := range p.Items {
+		if it.Type != service.PlaylistTrack {
+			continue
+		}
+		id, err := strconv.Atoi(it.ID)
+		if err != nil {
+			continue
+		}
+		if t, err := service.GetTrack(id); err == nil {
+			list = append(list, t)
+		}
+	}
+	pl := subsonicPlaylist{
+		ID:      subsonicPlaylistPrefix + p.ID,
+		Name:    p.Name,
+		Owner:   owner,
+		Public:  p.Public,
+		Created: p.CreatedAt.UTC().Format(time.RFC3339),
+		Changed: p.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+	fillSubsonicPlaylist(&pl, list, lib, withEntries)
+	return pl
+}
+
+// smartPlaylist 把智能歌单的求值结果转换为 Subsonic 歌单，结果随曲库变化，修改时间取当前时间
+func smartPlaylist(p *service.SmartPlaylist, list []service.Track, lib *subsonicLibrary, owner string, withEntries bool) subsonicPlaylist {
+	pl := subsonicPlaylist{
+		ID:      subsonicSmartPlaylistPrefix + p.ID,
+		Name:    p.Name,
+		Owner:   owner,
+		Public:  p.Public,
+		Created: p.CreatedAt.UTC().Format(time.RFC3339),
+		Changed: time.Now().UTC().Format(time.RFC3339),
+	}
+	fillSubsonicPlaylist(&pl, list, lib, withEntries)
+	return pl
+}
+
+func fillSubsonicPlaylist(pl *subsonicPlaylist, list []service.Track, lib *subsonicLibrary, withEntries bool) {
+	var duration float64
+	for _, t := range list {
+		pl.SongCount++
+		duration += t.Duration
+		if withEntries {
+			pl.Entry = append(pl.Entry, lib.child(t))
+		}
+	}
+	pl.Duration = int(duration + 0.5)
+}
+
+// getPlaylists：由收藏生成的歌单，加上用户在服务端创建的歌单与智能歌单
+func subsonicGetPlaylists(w http.ResponseWriter, r *http.Request, userID string) {
+	pl, err := favoritesPlaylist(userID, r.Form.Get("u"), false)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	out := []subsonicPlaylist{*pl}
+	if list, err := service.ListPlaylists(userID); err == nil {
+		for i := range list {
+			out = append(out, serverPlaylist(&list[i], nil, r.Form.Get("u"), false))
+		}
+	}
+	if list, err := service.ListSmartPlaylists(userID, false); err == nil {
+		eval := service.NewSmartEvaluator(userID)
+		for i := range list {
+			tracks, err := eval.Evaluate(list[i].Rules, list[i].Sort, list[i].Limit)
+			if err != nil {
+				continue
+			}
+			out = append(out, smartPlaylist(&list[i], tracks, nil, r.Form.Get("u"), false))
+		}
+	}
+	writeSubsonic(w, r, &subsonicResponse{Playlists: &subsonicPlaylists{Playlist: out}})
+}
+
+func subsonicGetPlaylist(w http.ResponseWriter, r *http.Request, userID string) {
+	id := r.Form.Get("id")
+	switch {
+	case strings.HasPrefix(id, subsonicPlaylistPrefix):
+		p, err := service.GetPlaylist(strings.TrimPrefix(id, subsonicPlaylistPrefix), userID)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrNotFound, "playlist not found")
+			return
+		}
+		lib, err := loadSubsonicLibrary(userID)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+		pl := serverPlaylist(p, lib, r.Form.Get("u"), true)
+		writeSubsonic(w, r, &subsonicResponse{Playlist: &pl})
+		return
+	case strings.HasPrefix(id, subsonicSmartPlaylistPrefix):
+		p, err := service.GetSmartPlaylist(strings.TrimPrefix(id, subsonicSmartPlaylistPrefix), userID)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrNotFound, "playlist not found")
+			return
+		}
+		tracks, err := service.EvaluateSmartPlaylist(p)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+		lib, err := loadSubsonicLibrary(userID)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+		pl := smartPlaylist(p, tracks, lib, r.Form.Get("u"), true)
+		writeSubsonic(w, r, &subsonicResponse{Playlist: &pl})
+		return
+	case id != subsonicFavoritesPlaylist:
+		writeSubsonicError(w, r, subsonicErrNotFound, "playlist not found")
+		return
+	}
+	pl, err := favoritesPlaylist(userID, r.Form.Get("u"), true)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	writeSubsonic(w, r, &subsonicResponse{Playlist: pl})
+}
+
+// setRating：评分 1-5，0 表示清除；评分可用于智能歌单
+func subsonicSetRating(w http.ResponseWriter, r *http.Request, userID string) {
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: id")
+		return
+	}
+	rating, err := strconv.Atoi(r.Form.Get("rating"))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing: rating")
+		return
+	}
+	if _, err := service.GetTrack(id); err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+		return
+	}
+	if err := service.SetTrackRating(userID, id, rating); err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	writeSubsonic(w, r, &subsonicResponse{})
+}
+
+// search3：复用曲库搜索；query 为空（或 ""）时按顺序分页返回全部内容，供客户端同步整个曲库
+func subsonicSearch3(w http.ResponseWriter, r *http.Request, userID string) {
+	q := r.Form
+	query := strings.Trim(strings.TrimSpace(q.Get("query")), `"`)
+	count := func(key string) (int, int) {
+		n, err := strconv.Atoi(q.Get(key + "Count"))
+		if err != nil || n < 0 {
+			n = 20
+		}
+		off, _ := strconv.Atoi(q.Get(key + "Offset"))
+		return n, max(off, 0)
+	}
+	artistCount, artistOffset := count("artist")
+	albumCount, albumOffset := count("album")
+	songCount, songOffset := count("song")
+
+	lib, err := loadSubsonicLibrary(userID)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	allArtists, artistIDs, err := subsonicArtistList()
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+
+	var artists []subsonicArtist
+	var albums []service.Album
+	var songs []service.Track
+	if query == "" {
+		artists = allArtists
+		if albums, err = service.ListAlbums(); err == nil {
+			songs, err = service.ListTracks()
+		}
+	} else {
+		limit := max(artistOffset+artistCount, albumOffset+albumCount, songOffset+songCount)
+		var res *service.SearchResult
+		if res, err = service.Search(query, limit); err == nil {
+			for _, a := range res.Artists {
+				artists = append(artists, subsonicArtist{ID: subsonicArtistID(a.ID), Name: a.Name, CoverArt: subsonicArtistID(a.ID)})
+			}
+			for _, a := range res.Albums {
+				albums = append(albums, a.Album)
+			}
+			for _, t := range res.Tracks {
+				songs = append(songs, t.Track)
+			}
+		}
+	}
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+
+	res := &subsonicSearchResult3{Artist: []subsonicArtist{}, Album: []subsonicAlbum{}, Song: []subsonicChild{}}
+	res.Artist = append(res.Artist, page(artists, artistOffset, artistCount)...)
+	for _, al := range page(albums, albumOffset, albumCount) {
+		res.Album = append(res.Album, lib.album(al, artistIDs))
+	}
+	for _, t := range page(songs, songOffset, songCount) {
+		res.Song = append(res.Song, lib.child(t))
+	}
+	writeSubsonic(w, r, &subsonicResponse{SearchResult3: res})
+}
+
+// page 返回 list[offset:offset+count]，越界时返回空
+func page[T any](list []T, offset, count int) []T {
+	if offset >= len(list) {
+		return nil
+	}
+	return list[offset:min(len(list), offset+count)]
+}
+
+// scrobble：submission=false 只表示正在播放，不记录；否则把每个 id 写入播放历史，time 为毫秒时间戳
+func subsonicScrobble(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Form.Get("submission") == "false" {
+		writeSubsonic(w, r, &subsonicResponse{})
+		return
+	}
+	times := r.Form["time"]
+	for i, raw := range r.Form["id"] {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			continue
+		}
+		playedAt := time.Now()
+		if i < len(times) {
+			if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil && ms > 0 {
+				playedAt = time.UnixMilli(ms)
+			}
+		}
+		if err := service.RecordPlay(userID, id, playedAt); err != nil {
+			log.Printf("记录播放历史失败: %v", err)
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+	}
+	writeSubsonic(w, r, &subsonicResponse{})
+}
+
+// HandleSubsonicCredentials 查看或重新生成当前用户的 Subsonic 客户端密码：
+//
+//	GET  /api/subsonic/credentials  返回用户名（登录邮箱）与是否已生成密码，不返回密码本身
+//	POST /api/subsonic/credentials  生成新密码并只在本次响应中返回，旧密码立即失效
+func HandleSubsonicCredentials(w http.ResponseWriter, r *http.Request) {
+	userID, err := service.GetCurrentUserID(r)
+	if err != nil || userID == "" {
+		writeErr(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		username, hasPassword, err := service.GetSubsonicCredentials(userID)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"server":       "/rest",
+			"username":     username,
+			"has_password": hasPassword,
+		})
+	case http.MethodPost:
+		username, password, err := service.ResetSubsonicPassword(userID)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"server":   "/rest",
+			"username": username,
+			"password": password,
+		})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -2,7 +2,6 @@ package controller
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -127,53 +126,9 @@ func HandleDeleteMusicFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// HandlePlayUploadedMusic 播放上传的音乐文件
+// HandlePlayUploadedMusic 播放上传的音乐文件：GET /api/upload/play?id=...
 func HandlePlayUploadedMusic(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeErrUpload(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
-	// 从URL参数中获取音乐文件ID
-	musicFileID := r.URL.Query().Get("id")
-	if musicFileID == "" {
-		writeErrUpload(w, http.StatusBadRequest, "music file id required")
-		return
-	}
-
-	// 检查用户是否已登录
-	userID, err := service.GetCurrentUserID(r)
-	if err != nil {
-		writeErrUpload(w, http.StatusUnauthorized, "user not authenticated")
-		return
-	}
-
-	// 获取用户音乐文件列表
-	musicFiles, err := service.GetUserMusicFiles(userID)
-	if err != nil {
-		writeErrUpload(w, http.StatusInternalServerError, "failed to get music files: "+err.Error())
-		return
-	}
-
-	// 查找指定的音乐文件
-	var targetFile *service.MusicFile
-	for _, file := range musicFiles {
-		if file.ID == musicFileID {
-			targetFile = &file
-			break
-		}
-	}
-
-	if targetFile == nil {
-		writeErrUpload(w, http.StatusNotFound, "music file not found")
-		return
-	}
-
-	// 获取音乐文件的URL
-	musicURL := service.GetMusicFileURL(targetFile.StoragePath)
-
-	// 重定向到音乐文件URL
-	http.Redirect(w, r, musicURL, http.StatusFound)
+	serveUploadedMusic(w, r)
 }
 
 // HandleCloudMusicList 获取云端音乐列表（包含本地和云端音乐）
@@ -217,9 +172,15 @@ func HandleCloudMusicList(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// HandleCloudMusicStream 流式播放云端音乐
+// HandleCloudMusicStream 流式播放云端音乐：GET /api/cloud/stream?id=...
 func HandleCloudMusicStream(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	serveUploadedMusic(w, r)
+}
+
+// serveUploadedMusic 由服务端代理输出用户上传的文件，不向客户端暴露存储地址。
+// 每个请求都重新校验文件归属，Range 按需转换为对存储的分段请求
+func serveUploadedMusic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		writeErrUpload(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
@@ -231,35 +192,39 @@ func HandleCloudMusicStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 检查用户是否已登录
-	userID, err := service.GetCurrentUserID(r)
-	if err != nil {
-		writeErrUpload(w, http.StatusUnauthorized, "user not authenticated")
-		return
+	var userID string
+	if token := r.URL.Query().Get("share"); token != "" {
+		// 通过分享链接播放：凭播放凭证以分享者的身份读取文件
+		link, ok := authorizeShare(w, r, token, musicFileID)
+		if !ok {
+			return
+		}
+		userID = link.UserID
+	} else {
+		// 检查用户是否已登录
+		var err error
+		userID, err = service.GetCurrentUserID(r)
+		if err != nil || userID == "" {
+			writeErrUpload(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
 	}
 
-	// 获取音乐文件信息
-	musicFile, err := service.GetMusicFileByID(musicFileID, userID)
+	musicFile, obj, err := service.OpenUserMusicFile(r.Context(), musicFileID, userID)
 	if err != nil {
 		writeErrUpload(w, http.StatusNotFound, "music file not found")
 		return
 	}
+	defer obj.Close()
 
-	// 设置流媒体响应头
-	w.Header().Set("Content-Type", "audio/mpeg")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", musicFile.FileSize))
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-
-	// 处理范围请求（支持断点续传）
-	rangeHeader := r.Header.Get("Range")
-	if rangeHeader != "" {
-		service.HandleRangeRequest(w, r, musicFile)
-		return
-	}
-
-	// 直接返回音乐文件URL
-	http.Redirect(w, r, service.GetMusicFileURL(musicFile.StoragePath), http.StatusFound)
+	// 私有内容：只允许浏览器缓存，且每次都需凭 ETag 重新校验（同时完成归属校验）
+	serveMedia(w, r, mediaContent{
+		ContentType:  service.MusicFileMIME(musicFile),
+		ModTime:      musicFile.UploadedAt,
+		ETag:         fileETag(musicFile.UploadedAt, obj.Size()),
+		CacheControl: "private, no-cache",
+		Content:      obj,
+	})
 }
 
 // isValidMusicFileType 检查文件类型是否为有效的音乐文件
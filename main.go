@@ -8,6 +8,7 @@ import (
 	"MusicPlayerWeb/controller"
 	"MusicPlayerWeb/db"
 	"MusicPlayerWeb/middleware"
+	"MusicPlayerWeb/service"
 )
 
 func main() {
@@ -17,6 +18,16 @@ func main() {
 		log.Println("继续运行，但用户认证功能将不可用")
 	}
 
+	// 后台加载曲库并监听音乐目录变化
+	go func() {
+		if err := service.LoadMusicCache(); err != nil {
+			log.Printf("扫描音乐目录失败: %v", err)
+		}
+		if err := service.StartLibraryWatcher(); err != nil {
+			log.Printf("监听音乐目录失败: %v", err)
+		}
+	}()
+
 	// 创建自定义多路复用器
 	mux := http.NewServeMux()
 
@@ -56,6 +67,7 @@ func main() {
 	mux.HandleFunc("/forum/post/", controller.HandleForumPostPage)
 	mux.HandleFunc("/playlists", controller.HandlePlaylistsPage)
 	mux.HandleFunc("/requirements", controller.HandleRequirementsPage)
+	mux.HandleFunc("/s/", controller.HandleSharePage)
 	mux.HandleFunc("/", controller.HandleIndex)
 
 	// API 路由
@@ -67,16 +79,37 @@ func main() {
 	mux.HandleFunc("/api/music", controller.HandleMusicList)
 	mux.HandleFunc("/api/artists", controller.HandleArtistsAPI)
 	mux.HandleFunc("/api/albums", controller.HandleAlbums)
+	mux.HandleFunc("/api/albums/", controller.HandleAlbumDownload)
 	mux.HandleFunc("/api/album_tracks", controller.HandleAlbumTracks)
+	mux.HandleFunc("/api/album_by_id", controller.HandleAlbumByID)
+	mux.HandleFunc("/api/album_tracks_by_id", controller.HandleAlbumTracksByID)
 	mux.HandleFunc("/api/audio", controller.HandleAudio)
+	mux.HandleFunc("/api/audio/profiles", controller.HandleAudioProfiles)
+	mux.HandleFunc("/api/hls/", controller.HandleHLS)
 	mux.HandleFunc("/api/cover", controller.HandleCover)
 	mux.HandleFunc("/api/lyrics", controller.HandleLyrics)
 	mux.HandleFunc("/api/lyrics_raw", controller.HandleLyricsRaw)
+	mux.HandleFunc("/api/lyrics/timed", controller.HandleTimedLyrics)
+	mux.HandleFunc("/api/waveform", controller.HandleWaveform)
 	mux.HandleFunc("/api/track", controller.HandleTrack)
+	mux.HandleFunc("/api/track_id_map", controller.HandleTrackIDMap)
+	mux.HandleFunc("/api/search", controller.HandleSearch)
 	mux.HandleFunc("/api/artist_detail/", controller.HandleArtistDetail)
 	mux.HandleFunc("/api/artist_tracks/", controller.HandleArtistTracks)
 	mux.HandleFunc("/api/get_music_dir", controller.HandleGetMusicDir)
-	mux.HandleFunc("/api/update_music_dir", controller.HandleUpdateMusicDir)
+	mux.HandleFunc("/api/update_music_dir", controller.AdminMiddleware(controller.HandleUpdateMusicDir))
+	mux.HandleFunc("/api/library/scan_report", controller.HandleScanReport)
+	mux.HandleFunc("/api/rescan", controller.HandleRescan)
+	mux.HandleFunc("/api/library/roots", controller.AdminMiddleware(controller.HandleLibraryRoots))
+	mux.HandleFunc("/api/library/roots/", controller.AdminMiddleware(controller.HandleLibraryRoot))
+
+	// 歌单 API
+	mux.HandleFunc("/api/playlists", controller.HandlePlaylists)
+	mux.HandleFunc("/api/playlists/", controller.HandlePlaylistItem)
+	mux.HandleFunc("/api/smart-playlists", controller.HandleSmartPlaylists)
+	mux.HandleFunc("/api/smart-playlists/", controller.HandleSmartPlaylistItem)
+	mux.HandleFunc("/api/ratings", controller.HandleRatings)
+	mux.HandleFunc("/api/history", controller.HandleHistory)
 
 	// 评论功能 API
 	mux.HandleFunc("/api/comments", controller.HandleComments)
@@ -113,6 +146,16 @@ func main() {
 	mux.HandleFunc("/api/admin/posts/", controller.AdminMiddleware(controller.HandleAdminPosts))
 	mux.HandleFunc("/api/admin/replies", controller.AdminMiddleware(controller.HandleAdminReplies))
 	mux.HandleFunc("/api/admin/replies/", controller.AdminMiddleware(controller.HandleAdminReplies))
+	mux.HandleFunc("/api/admin/migrate_track_ids", controller.AdminMiddleware(controller.HandleMigrateTrackIDs))
+
+	// Subsonic 兼容 API，供 DSub、Symfonium 等客户端使用
+	mux.HandleFunc("/rest/", controller.HandleSubsonic)
+	mux.HandleFunc("/api/subsonic/credentials", controller.HandleSubsonicCredentials)
+
+	// 分享链接 API：/api/shares 管理自己的链接，/api/share/{token} 供未登录访客使用
+	mux.HandleFunc("/api/shares", controller.HandleShares)
+	mux.HandleFunc("/api/shares/", controller.HandleShareItem)
+	mux.HandleFunc("/api/share/", controller.HandlePublicShare)
 
 	// AI助手功能 API
 	mux.HandleFunc("/api/ai/chat", controller.HandleAIChat)
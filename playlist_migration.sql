@@ -0,0 +1,17 @@
+-- 服务端歌单所需的表结构
+
+CREATE TABLE IF NOT EXISTS playlists (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    is_public BOOLEAN NOT NULL DEFAULT false,
+    items JSONB NOT NULL DEFAULT '[]',   -- 有序的 [{type: track|upload, id, added_at}]
+    version INTEGER NOT NULL DEFAULT 0,  -- 每次修改递增，用于并发修改检测
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_playlists_user_updated ON playlists (user_id, updated_at DESC);
+
+SELECT '歌单表结构已创建' as status;
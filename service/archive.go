@@ -0,0 +1,551 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlbumArchive 是专辑下载用的 ZIP 包（Store 方式，不压缩），实现 io.ReadSeeker。
+// 包内每个位置的内容都由曲目文件和少量头部确定，任意偏移都能直接定位到对应文件，
+// 因此可以交给 http.ServeContent 处理 Range 续传，而无需在内存或磁盘上生成整个压缩包。
+// 本地文件头之后使用数据描述符记录 CRC32，顺序下载时边读边算；
+// 从中间开始的 Range 请求需要的 CRC 优先取缓存，没有时单独读取文件计算
+type AlbumArchive struct {
+	Name    string // 下载文件名
+	ModTime time.Time
+	ETag    string
+
+	entries []*zipEntry
+	zip64   bool
+	cdOff   int64 // 中央目录起始偏移
+	size    int64
+	central []byte // 中央目录与目录结束记录，需要全部 CRC，首次读到时生成
+
+	off int64
+	// 当前打开的曲目文件，顺序读取时复用
+	file      *os.File
+	fileEntry *zipEntry
+	// 从文件开头连续读取时同步计算 CRC
+	crc      hash.Hash32
+	crcEntry *zipEntry
+	crcPos   int64
+}
+
+// zipEntry 是压缩包中的一个文件，内容来自磁盘文件（path）或内存（data）
+type zipEntry struct {
+	name     string
+	path     string
+	data     []byte
+	size     int64
+	modTime  time.Time
+	offset   int64 // 本地文件头偏移
+	header   []byte
+	crc      uint32
+	crcKnown bool
+}
+
+const (
+	zipLocalHeaderLen   = 30
+	zipCentralHeaderLen = 46
+	zipEndLen           = 22
+	zip64EndLen         = 56
+	zip64LocatorLen     = 20
+	zip64LocalExtraLen  = 20 // 标记+长度+原始大小+压缩后大小
+	zip64CentralExtra   = 28 // 再加本地文件头偏移
+	zipDescriptorLen    = 16
+	zip64DescriptorLen  = 24
+	zipFlags            = 0x0808 // bit 3：数据描述符；bit 11：文件名为 UTF-8
+	zipMaxUint32        = 0xFFFFFFFF
+)
+
+// crcCache 缓存曲目文件的 CRC32，键包含路径、大小与修改时间，文件变化后自然失效
+var (
+	crcCacheMu sync.Mutex
+	crcCache   = map[string]uint32{}
+)
+
+func crcCacheKey(path string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s|%d|%d", path, size, modTime.UnixNano())
+}
+
+// NewAlbumArchive 为专辑生成 ZIP 包：原始音频文件、封面图片和一个引用包内文件的 .m3u8 播放列表，
+// 均放在 "专辑艺人 - 专辑名" 目录下
+func NewAlbumArchive(albumID int) (*AlbumArchive, error) {
+	album, err := findAlbumByID(albumID)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ListAlbumTracks(album.Name, album.Artist)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, errors.New("album has no tracks")
+	}
+
+	folder := archiveName(album.Name)
+	if album.Artist != "" {
+		folder = archiveName(album.Artist + " - " + album.Name)
+	}
+	a := &AlbumArchive{Name: folder + ".zip"}
+	etag := sha1.New()
+	fmt.Fprintf(etag, "album:%d\n", album.ID)
+
+	used := map[string]bool{}
+	uniqueName := func(name string) string {
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for i := 2; used[strings.ToLower(name)]; i++ {
+			name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		used[strings.ToLower(name)] = true
+		return name
+	}
+
+	var m3u strings.Builder
+	m3u.WriteString("#EXTM3U\n")
+	for _, t := range list {
+		modTime := time.Unix(0, t.ModTime)
+		name := uniqueName(archiveName(filepath.Base(t.Path)))
+		a.entries = append(a.entries, &zipEntry{
+			name:    folder + "/" + name,
+			path:    t.Path,
+			size:    t.Size,
+			modTime: modTime,
+		})
+		if modTime.After(a.ModTime) {
+			a.ModTime = modTime
+		}
+		fmt.Fprintf(etag, "%d|%d|%d\n", t.ID, t.Size, t.ModTime)
+
+		title := t.Title
+		if t.Artist != "" {
+			title = t.Artist + " - " + t.Title
+		}
+		fmt.Fprintf(&m3u, "#EXTINF:%d,%s\n%s\n", int(t.Duration+0.5), title, name)
+	}
+
+	// 封面取专辑封面曲目的原图，没有封面时跳过
+	if album.CoverTrackID >= 0 {
+		if img, err := GetCoverImage(album.CoverTrackID, 0, ""); err == nil {
+			ext := ".jpg"
+			switch img.MIME {
+			case "image/png":
+				ext = ".png"
+			case "image/webp":
+				ext = ".webp"
+			case "image/gif":
+				ext = ".gif"
+			}
+			a.entries = append(a.entries, memoryZipEntry(folder+"/"+uniqueName("cover"+ext), img.Data, a.ModTime))
+			fmt.Fprintf(etag, "cover:%s\n", img.ETag)
+		}
+	}
+	playlist := uniqueName(archiveName(album.Name) + ".m3u8")
+	a.entries = append(a.entries, memoryZipEntry(folder+"/"+playlist, []byte(m3u.String()), a.ModTime))
+
+	a.layout()
+	a.ETag = `"` + hex.EncodeToString(etag.Sum(nil)) + `"`
+	return a, nil
+}
+
+func memoryZipEntry(name string, data []byte, modTime time.Time) *zipEntry {
+	return &zipEntry{
+		name:     name,
+		data:     data,
+		size:     int64(len(data)),
+		modTime:  modTime,
+		crc:      crc32.ChecksumIEEE(data),
+		crcKnown: true,
+	}
+}
+
+// archiveName 去掉文件名中在常见文件系统上不合法的字符
+func archiveName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		name = "album"
+	}
+	return name
+}
+
+// layout 计算每个文件的偏移与总大小。先按普通 ZIP 排布，按实际的文件头、数据描述符与中央目录长度
+// 算出的总大小超过 4 GiB（或文件数过多）时整体改用 ZIP64 重新排布
+func (a *AlbumArchive) layout() {
+	a.zip64 = len(a.entries) >= 0xFFFF
+	a.place()
+	if !a.zip64 && a.size >= zipMaxUint32 {
+		a.zip64 = true
+		a.place()
+	}
+}
+
+// place 按当前的 zip64 设置计算偏移、中央目录位置与总大小
+func (a *AlbumArchive) place() {
+	var off int64
+	for _, e := range a.entries {
+		e.offset = off
+		e.header = a.localHeader(e)
+		off += int64(len(e.header)) + e.size + a.descriptorLen()
+	}
+	a.cdOff = off
+	for _, e := range a.entries {
+		off += zipCentralHeaderLen + int64(len(e.name))
+		if a.zip64 {
+			off += zip64CentralExtra
+		}
+	}
+	off += zipEndLen
+	if a.zip64 {
+		off += zip64EndLen + zip64LocatorLen
+	}
+	a.size = off
+}
+
+func (a *AlbumArchive) descriptorLen() int64 {
+	if a.zip64 {
+		return zip64DescriptorLen
+	}
+	return zipDescriptorLen
+}
+
+func (a *AlbumArchive) version() uint16 {
+	if a.zip64 {
+		return 45
+	}
+	return 20
+}
+
+// dosTime 把时间转换为 ZIP 使用的 MS-DOS 日期与时间
+func dosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.Local)
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+func (a *AlbumArchive) localHeader(e *zipEntry) []byte {
+	date, clock := dosTime(e.modTime)
+	b := binary.LittleEndian.AppendUint32(nil, 0x04034b50)
+	b = binary.LittleEndian.AppendUint16(b, a.version())
+	b = binary.LittleEndian.AppendUint16(b, zipFlags)
+	b = binary.LittleEndian.AppendUint16(b, 0) // Store
+	b = binary.LittleEndian.AppendUint16(b, clock)
+	b = binary.LittleEndian.AppendUint16(b, date)
+	b = binary.LittleEndian.AppendUint32(b, 0) // CRC 在数据描述符中
+	if a.zip64 {
+		b = binary.LittleEndian.AppendUint32(b, zipMaxUint32)
+		b = binary.LittleEndian.AppendUint32(b, zipMaxUint32)
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(e.name)))
+	if a.zip64 {
+		b = binary.LittleEndian.AppendUint16(b, zip64LocalExtraLen)
+	} else {
+		b = binary.LittleEndian.AppendUint16(b, 0)
+	}
+	b = append(b, e.name...)
+	if a.zip64 {
+		b = binary.LittleEndian.AppendUint16(b, 0x0001)
+		b = binary.LittleEndian.AppendUint16(b, 16)
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+	}
+	return b
+}
+
+func (a *AlbumArchive) descriptor(e *zipEntry) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 0x08074b50)
+	b = binary.LittleEndian.AppendUint32(b, e.crc)
+	if a.zip64 {
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+	}
+	return b
+}
+
+// centralDirectory 生成中央目录与结束记录，调用前所有文件的 CRC 都必须已知
+func (a *AlbumArchive) centralDirectory() []byte {
+	var b []byte
+	for _, e := range a.entries {
+		date, clock := dosTime(e.modTime)
+		b = binary.LittleEndian.AppendUint32(b, 0x02014b50)
+		b = binary.LittleEndian.AppendUint16(b, 3<<8|a.version()) // Unix
+		b = binary.LittleEndian.AppendUint16(b, a.version())
+		b = binary.LittleEndian.AppendUint16(b, zipFlags)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = binary.LittleEndian.AppendUint16(b, clock)
+		b = binary.LittleEndian.AppendUint16(b, date)
+		b = binary.LittleEndian.AppendUint32(b, e.crc)
+		if a.zip64 {
+			b = binary.LittleEndian.AppendUint32(b, zipMaxUint32)
+			b = binary.LittleEndian.AppendUint32(b, zipMaxUint32)
+		} else {
+			b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+			b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+		}
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(e.name)))
+		if a.zip64 {
+			b = binary.LittleEndian.AppendUint16(b, zip64CentralExtra)
+		} else {
+			b = binary.LittleEndian.AppendUint16(b, 0)
+		}
+		b = binary.LittleEndian.AppendUint16(b, 0)        // 注释长度
+		b = binary.LittleEndian.AppendUint16(b, 0)        // 起始磁盘
+		b = binary.LittleEndian.AppendUint16(b, 0)        // 内部属性
+		b = binary.LittleEndian.AppendUint32(b, 0644<<16) // 外部属性：rw-r--r--
+		if a.zip64 {
+			b = binary.LittleEndian.AppendUint32(b, zipMaxUint32)
+		} else {
+			b = binary.LittleEndian.AppendUint32(b, uint32(e.offset))
+		}
+		b = append(b, e.name...)
+		if a.zip64 {
+			b = binary.LittleEndian.AppendUint16(b, 0x0001)
+			b = binary.LittleEndian.AppendUint16(b, 24)
+			b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+			b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+			b = binary.LittleEndian.AppendUint64(b, uint64(e.offset))
+		}
+	}
+
+	cdLen := int64(len(b))
+	count := uint64(len(a.entries))
+	if a.zip64 {
+		end64 := a.cdOff + cdLen
+		b = binary.LittleEndian.AppendUint32(b, 0x06064b50)
+		b = binary.LittleEndian.AppendUint64(b, zip64EndLen-12)
+		b = binary.LittleEndian.AppendUint16(b, 3<<8|45)
+		b = binary.LittleEndian.AppendUint16(b, 45)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, count)
+		b = binary.LittleEndian.AppendUint64(b, count)
+		b = binary.LittleEndian.AppendUint64(b, uint64(cdLen))
+		b = binary.LittleEndian.AppendUint64(b, uint64(a.cdOff))
+
+		b = binary.LittleEndian.AppendUint32(b, 0x07064b50)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, uint64(end64))
+		b = binary.LittleEndian.AppendUint32(b, 1)
+
+		b = binary.LittleEndian.AppendUint32(b, 0x06054b50)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = binary.LittleEndian.AppendUint16(b, 0xFFFF)
+		b = binary.LittleEndian.AppendUint16(b, 0xFFFF)
+		b = binary.LittleEndian.AppendUint32(b, zipMaxUint32)
+		b = binary.LittleEndian.AppendUint32(b, zipMaxUint32)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		return b
+	}
+	b = binary.LittleEndian.AppendUint32(b, 0x06054b50)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, uint16(count))
+	b = binary.LittleEndian.AppendUint16(b, uint16(count))
+	b = binary.LittleEndian.AppendUint32(b, uint32(cdLen))
+	b = binary.LittleEndian.AppendUint32(b, uint32(a.cdOff))
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	return b
+}
+
+// Size 返回压缩包的总大小
+func (a *AlbumArchive) Size() int64 {
+	return a.size
+}
+
+func (a *AlbumArchive) Read(p []byte) (int, error) {
+	if a.off >= a.size {
+		return 0, io.EOF
+	}
+	if a.off >= a.cdOff {
+		if a.central == nil {
+			for _, e := range a.entries {
+				if err := a.ensureCRC(e); err != nil {
+					return 0, err
+				}
+			}
+			a.central = a.centralDirectory()
+		}
+		n := copy(p, a.central[a.off-a.cdOff:])
+		a.off += int64(n)
+		return n, nil
+	}
+
+	for _, e := range a.entries {
+		dataOff := e.offset + int64(len(e.header))
+		end := dataOff + e.size + a.descriptorLen()
+		if a.off >= end {
+			continue
+		}
+		var n int
+		var err error
+		switch {
+		case a.off < dataOff:
+			n = copy(p, e.header[a.off-e.offset:])
+		case a.off < dataOff+e.size:
+			pos := a.off - dataOff
+			if rest := e.size - pos; int64(len(p)) > rest {
+				p = p[:rest]
+			}
+			n, err = a.readData(e, pos, p)
+		default:
+			if err = a.ensureCRC(e); err == nil {
+				n = copy(p, a.descriptor(e)[a.off-dataOff-e.size:])
+			}
+		}
+		a.off += int64(n)
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+// readData 读取文件内容；从文件开头连续读取时同时计算 CRC
+func (a *AlbumArchive) readData(e *zipEntry, pos int64, p []byte) (int, error) {
+	var n int
+	if e.data != nil {
+		n = copy(p, e.data[pos:])
+	} else {
+		if a.fileEntry != e || a.file == nil {
+			a.closeFile()
+			f, err := openArchiveFile(e)
+			if err != nil {
+				return 0, err
+			}
+			a.file, a.fileEntry = f, e
+		}
+		var err error
+		n, err = a.file.ReadAt(p, pos)
+		if err == io.EOF && n < len(p) {
+			return n, io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+
+	if !e.crcKnown {
+		if pos == 0 {
+			a.crc, a.crcEntry, a.crcPos = crc32.NewIEEE(), e, 0
+		}
+		if a.crcEntry == e && a.crcPos == pos {
+			a.crc.Write(p[:n])
+			a.crcPos += int64(n)
+			if a.crcPos == e.size {
+				e.crc, e.crcKnown = a.crc.Sum32(), true
+				crcCacheMu.Lock()
+				crcCache[crcCacheKey(e.path, e.size, e.modTime)] = e.crc
+				crcCacheMu.Unlock()
+			}
+		}
+	}
+	return n, nil
+}
+
+// openArchiveFile 打开曲目文件，并确认它与扫描时一致（大小、修改时间），否则包内偏移不再可信
+func openArchiveFile(e *zipEntry) (*os.File, error) {
+	f, err := os.Open(e.path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if st.Size() != e.size || !st.ModTime().Equal(e.modTime) {
+		f.Close()
+		return nil, fmt.Errorf("%s changed since the last scan, please rescan the library", filepath.Base(e.path))
+	}
+	return f, nil
+}
+
+// ensureCRC 确保文件的 CRC 已知：优先使用缓存，否则完整读取一遍文件
+func (a *AlbumArchive) ensureCRC(e *zipEntry) error {
+	if e.crcKnown {
+		return nil
+	}
+	key := crcCacheKey(e.path, e.size, e.modTime)
+	crcCacheMu.Lock()
+	crc, ok := crcCache[key]
+	crcCacheMu.Unlock()
+	if !ok {
+		f, err := openArchiveFile(e)
+		if err != nil {
+			return err
+		}
+		h := crc32.NewIEEE()
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if n != e.size {
+			return io.ErrUnexpectedEOF
+		}
+		crc = h.Sum32()
+		crcCacheMu.Lock()
+		crcCache[key] = crc
+		crcCacheMu.Unlock()
+	}
+	e.crc, e.crcKnown = crc, true
+	return nil
+}
+
+func (a *AlbumArchive) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += a.off
+	case io.SeekEnd:
+		offset += a.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	a.off = offset
+	return offset, nil
+}
+
+// Close 关闭正在读取的曲目文件
+func (a *AlbumArchive) Close() error {
+	a.closeFile()
+	return nil
+}
+
+func (a *AlbumArchive) closeFile() {
+	if a.file != nil {
+		a.file.Close()
+		a.file, a.fileEntry = nil, nil
+	}
+}
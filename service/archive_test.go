@@ -0,0 +1,66 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAlbumArchiveLayoutZip64Boundary(t *testing.T) {
+	// 普通 ZIP 的总大小 = 文件大小 + 本地文件头 31 + 数据描述符 16 + 中央目录 47 + 结束记录 22
+	const overhead = zipLocalHeaderLen + 1 + zipDescriptorLen + zipCentralHeaderLen + 1 + zipEndLen
+	tests := []struct {
+		size  int64
+		zip64 bool
+	}{
+		{zipMaxUint32 - overhead - 1, false},
+		{zipMaxUint32 - overhead, true},
+		{zipMaxUint32 - zipLocalHeaderLen - 1 - 1, true}, // 只有加上数据描述符才超过 4 GiB
+	}
+	for _, tt := range tests {
+		a := &AlbumArchive{entries: []*zipEntry{{name: "a", size: tt.size}}}
+		a.layout()
+		if a.zip64 != tt.zip64 {
+			t.Errorf("size %d: zip64 = %v, want %v", tt.size, a.zip64, tt.zip64)
+		}
+		if !a.zip64 && a.size >= zipMaxUint32 {
+			t.Errorf("size %d: plain ZIP of %d bytes exceeds 4 GiB", tt.size, a.size)
+		}
+	}
+}
+
+func TestAlbumArchiveReadable(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	a := &AlbumArchive{entries: []*zipEntry{
+		memoryZipEntry("Album/cover.jpg", []byte("cover bytes"), now),
+		memoryZipEntry("Album/Album.m3u8", []byte("#EXTM3U\n"), now),
+	}}
+	a.layout()
+	data, err := io.ReadAll(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != a.Size() {
+		t.Fatalf("read %d bytes, Size() = %d", len(data), a.Size())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if !bytes.Equal(got, a.entries[i].data) {
+			t.Errorf("%s = %q, want %q", f.Name, got, a.entries[i].data)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// defaultArtistSeparators 是拆分多歌手标签时使用的默认分隔符，
+// 可通过环境变量 MUSIC_ARTIST_SEPARATORS 覆盖，多个分隔符之间用 | 隔开
+const defaultArtistSeparators = "/|;|；|、|feat.|ft.|&|＆"
+
+var artistSeparators = loadArtistSeparators()
+
+func loadArtistSeparators() []string {
+	raw := getEnvDefault("MUSIC_ARTIST_SEPARATORS", defaultArtistSeparators)
+	var seps []string
+	for _, s := range strings.Split(raw, "|") {
+		if s = strings.TrimSpace(s); s != "" {
+			seps = append(seps, strings.ToLower(s))
+		}
+	}
+	return seps
+}
+
+// splitArtists 把一个署名字符串拆成独立的歌手名，保持原有顺序并去重。
+// 含字母的分隔符（如 feat.）不区分大小写，且只在单词边界处生效
+func splitArtists(credit string) []string {
+	credit = strings.TrimSpace(credit)
+	if credit == "" {
+		return nil
+	}
+	lower := strings.ToLower(credit)
+	var parts []string
+	start := 0
+	for i := 0; i < len(lower); {
+		sep := matchArtistSeparator(lower, i)
+		if sep == 0 {
+			i++
+			continue
+		}
+		parts = append(parts, credit[start:i])
+		i += sep
+		start = i
+	}
+	parts = append(parts, credit[start:])
+
+	var out []string
+	seen := map[string]bool{}
+	for _, p := range parts {
+		p = trimArtistPart(p)
+		if p == "" || seen[strings.ToLower(p)] {
+			continue
+		}
+		seen[strings.ToLower(p)] = true
+		out = append(out, p)
+	}
+	return out
+}
+
+// matchArtistSeparator 返回 lower[i:] 开头匹配到的分隔符长度，未匹配返回 0
+func matchArtistSeparator(lower string, i int) int {
+	for _, sep := range artistSeparators {
+		if !strings.HasPrefix(lower[i:], sep) {
+			continue
+		}
+		if isWordSeparator(sep) {
+			// "feat." 不能匹配 "defeat." 之类单词内部的片段
+			if i > 0 && isWordByte(lower[i-1]) {
+				continue
+			}
+		}
+		return len(sep)
+	}
+	return 0
+}
+
+func isWordSeparator(sep string) bool {
+	for _, r := range sep {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9'
+}
+
+// trimArtistPart 去掉拆分后残留的空白与括号，如 "A (feat. B)" 拆出的 "A (" 与 "B)"
+func trimArtistPart(p string) string {
+	p = strings.TrimSpace(p)
+	for {
+		switch {
+		case strings.HasSuffix(p, "(") || strings.HasSuffix(p, "（") || strings.HasSuffix(p, "["):
+			_, size := lastRune(p)
+			p = strings.TrimSpace(p[:len(p)-size])
+			continue
+		case strings.HasSuffix(p, ")") && strings.Count(p, ")") > strings.Count(p, "("),
+			strings.HasSuffix(p, "）") && strings.Count(p, "）") > strings.Count(p, "（"),
+			strings.HasSuffix(p, "]") && strings.Count(p, "]") > strings.Count(p, "["):
+			_, size := lastRune(p)
+			p = strings.TrimSpace(p[:len(p)-size])
+			continue
+		}
+		return strings.Trim(p, ",，")
+	}
+}
+
+func lastRune(s string) (rune, int) {
+	r := []rune(s)
+	if len(r) == 0 {
+		return 0, 0
+	}
+	last := r[len(r)-1]
+	return last, len(string(last))
+}
+
+// trackArtists 计算曲目的歌手列表：优先使用多值 ARTIST 字段，再逐个按分隔符拆分
+func trackArtists(credit string, values []string) []string {
+	if len(values) < 2 {
+		return splitArtists(credit)
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, a := range splitArtists(v) {
+			if !seen[strings.ToLower(a)] {
+				seen[strings.ToLower(a)] = true
+				out = append(out, a)
+			}
+		}
+	}
+	return out
+}
+
+// hasArtist 判断曲目是否署名了指定歌手（完整署名字符串同样视为匹配，兼容旧链接）
+func hasArtist(t Track, name string) bool {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return false
+	}
+	if strings.EqualFold(strings.TrimSpace(t.Artist), name) {
+		return true
+	}
+	for _, a := range t.Artists {
+		if strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readVorbisArtists 读取 FLAC / Ogg 中 Vorbis Comment 的全部 ARTIST 值。
+// tag 库遇到重复字段只保留最后一个，多值署名需要自行解析
+func readVorbisArtists(r io.ReadSeeker, format audioFormat) []string {
+	var block []byte
+	var err error
+	switch format.Name {
+	case "flac":
+		block, err = readFLACVorbisComment(r)
+	case "ogg", "opus":
+		block, err = readOggCommentPacket(r)
+	default:
+		return nil
+	}
+	if err != nil || len(block) == 0 {
+		return nil
+	}
+	return vorbisCommentValues(block, "artist")
+}
+
+// readFLACVorbisComment 遍历 FLAC 元数据块，返回 VORBIS_COMMENT（类型 4）的内容
+func readFLACVorbisComment(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := skipID3v2(r); err != nil {
+		return nil, err
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if string(head[:]) != "fLaC" {
+		return nil, errors.New("not a flac stream")
+	}
+	for {
+		var bh [4]byte
+		if _, err := io.ReadFull(r, bh[:]); err != nil {
+			return nil, err
+		}
+		last := bh[0]&0x80 != 0
+		typ := bh[0] & 0x7f
+		n := int64(bh[1])<<16 | int64(bh[2])<<8 | int64(bh[3])
+		if typ == 4 {
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil, err
+			}
+			return buf, nil
+		}
+		if last {
+			return nil, nil
+		}
+		if _, err := r.Seek(n, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// maxOggCommentSize 限制 Ogg 注释包的读取大小；内嵌封面时注释包可能很大，
+// 截断后仍能解析出位于封面之前的字段
+const maxOggCommentSize = 256 * 1024
+
+// readOggCommentPacket 按页重组 Ogg 的第二个逻辑包（Vorbis/Opus 注释头），返回去掉包头后的注释内容
+func readOggCommentPacket(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var packet []byte
+	packetIndex := 0
+	for pages := 0; pages < 64; pages++ {
+		var hdr [27]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+		if string(hdr[:4]) != "OggS" {
+			return nil, errors.New("bad ogg page")
+		}
+		lacing := make([]byte, hdr[26])
+		if _, err := io.ReadFull(r, lacing); err != nil {
+			return nil, err
+		}
+		for _, l := range lacing {
+			seg := make([]byte, l)
+			if _, err := io.ReadFull(r, seg); err != nil {
+				return nil, err
+			}
+			if packetIndex == 1 {
+				packet = append(packet, seg...)
+			}
+			if l < 255 {
+				if packetIndex == 1 {
+					return trimCommentHeader(packet), nil
+				}
+				packetIndex++
+			}
+			if len(packet) >= maxOggCommentSize {
+				return trimCommentHeader(packet), nil
+			}
+		}
+	}
+	if len(packet) > 0 {
+		return trimCommentHeader(packet), nil
+	}
+	return nil, errors.New("comment packet not found")
+}
+
+func trimCommentHeader(p []byte) []byte {
+	switch {
+	case bytes.HasPrefix(p, []byte("\x03vorbis")):
+		return p[7:]
+	case bytes.HasPrefix(p, []byte("OpusTags")):
+		return p[8:]
+	}
+	return nil
+}
+
+// vorbisCommentValues 解析 Vorbis Comment 并返回指定字段（不区分大小写）的所有值，
+// 数据被截断时返回已完整读到的部分
+func vorbisCommentValues(b []byte, field string) []string {
+	readLen := func() (int, bool) {
+		if len(b) < 4 {
+			return 0, false
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		b = b[4:]
+		return n, n >= 0 && n <= len(b)
+	}
+	n, ok := readLen()
+	if !ok {
+		return nil
+	}
+	b = b[n:] // vendor
+	if len(b) < 4 {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	var out []string
+	for i := 0; i < count; i++ {
+		n, ok := readLen()
+		if !ok {
+			break
+		}
+		k, v, found := strings.Cut(string(b[:n]), "=")
+		b = b[n:]
+		if found && strings.EqualFold(k, field) {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// loadArtistCredit 供扫描时调用：返回展示用的完整署名与拆分后的歌手列表
+func loadArtistCredit(f *os.File, format audioFormat, tagged string) (string, []string) {
+	values := readVorbisArtists(f, format)
+	credit := strings.TrimSpace(tagged)
+	if len(values) > 1 {
+		credit = strings.Join(values, " / ")
+	}
+	return credit, trackArtists(credit, values)
+}
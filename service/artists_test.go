@@ -0,0 +1,34 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArtists(t *testing.T) {
+	tests := []struct {
+		credit string
+		want   []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"周杰伦", []string{"周杰伦"}},
+		{"周杰伦/费玉清", []string{"周杰伦", "费玉清"}},
+		{"A; B；C、D", []string{"A", "B", "C", "D"}},
+		{"A & B ＆ C", []string{"A", "B", "C"}},
+		{"A feat. B", []string{"A", "B"}},
+		{"A FEAT. B", []string{"A", "B"}},
+		{"A ft. B", []string{"A", "B"}},
+		{"A (feat. B)", []string{"A", "B"}},
+		{"A [ft. B]", []string{"A", "B"}},
+		{"Defeat. Band", []string{"Defeat. Band"}},
+		{"A / a / B", []string{"A", "B"}},
+		{"A//B", []string{"A", "B"}},
+		{"A, / B", []string{"A", "B"}},
+	}
+	for _, tt := range tests {
+		if got := splitArtists(tt.credit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArtists(%q) = %q, want %q", tt.credit, got, tt.want)
+		}
+	}
+}
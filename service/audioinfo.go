@@ -0,0 +1,498 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// audioInfo 是从音频流头部解析出的技术参数
+type audioInfo struct {
+	Duration   float64 // 秒
+	SampleRate int     // Hz
+	BitDepth   int     // 位深，有损格式为 0
+	Bitrate    int     // kbps
+	Channels   int
+	Codec      string
+}
+
+// readAudioInfo 根据格式解析音频流头部；无法识别时返回空信息。
+// size 为文件大小，用于估算平均码率和 CBR 文件的时长。
+func readAudioInfo(r io.ReadSeeker, format audioFormat, size int64) audioInfo {
+	var info audioInfo
+	var err error
+	switch format.Name {
+	case "flac":
+		info, err = readFLACInfo(r)
+	case "mp3":
+		info, err = readMP3Info(r, size)
+	case "m4a", "alac":
+		info, err = readMP4Info(r)
+	case "ogg", "opus":
+		info, err = readOggInfo(r, size)
+	case "wav":
+		info, err = readWAVInfo(r)
+	default:
+		return audioInfo{Codec: format.Name}
+	}
+	if err != nil {
+		return audioInfo{Codec: format.Name}
+	}
+	if info.Bitrate == 0 && info.Duration > 0 {
+		info.Bitrate = int(float64(size) * 8 / info.Duration / 1000)
+	}
+	return info
+}
+
+// readFLACInfo 解析 FLAC STREAMINFO 块
+func readFLACInfo(r io.ReadSeeker) (audioInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return audioInfo{}, err
+	}
+	if err := skipID3v2(r); err != nil {
+		return audioInfo{}, err
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return audioInfo{}, err
+	}
+	if string(head[:]) != "fLaC" {
+		return audioInfo{}, errors.New("not a flac stream")
+	}
+	// 第一个元数据块必须是 STREAMINFO（类型 0，长度 34）
+	var blockHeader [4]byte
+	if _, err := io.ReadFull(r, blockHeader[:]); err != nil {
+		return audioInfo{}, err
+	}
+	if blockHeader[0]&0x7f != 0 {
+		return audioInfo{}, errors.New("missing streaminfo")
+	}
+	var si [34]byte
+	if _, err := io.ReadFull(r, si[:]); err != nil {
+		return audioInfo{}, err
+	}
+	// 字节 10 起：采样率 20 位、声道数-1 3 位、位深-1 5 位、总采样数 36 位
+	v := binary.BigEndian.Uint64(si[10:18])
+	info := audioInfo{
+		SampleRate: int(v >> 44),
+		Channels:   int((v>>41)&0x7) + 1,
+		BitDepth:   int((v>>36)&0x1f) + 1,
+		Codec:      "flac",
+	}
+	totalSamples := v & 0xfffffffff
+	if info.SampleRate > 0 {
+		info.Duration = float64(totalSamples) / float64(info.SampleRate)
+	}
+	return info, nil
+}
+
+var (
+	mp3Bitrates = [2][3][16]int{
+		{ // MPEG-1: Layer I, II, III
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		},
+		{ // MPEG-2/2.5: Layer I, II, III
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		},
+	}
+	mp3SampleRates = [3][3]int{
+		{44100, 48000, 32000}, // MPEG-1
+		{22050, 24000, 16000}, // MPEG-2
+		{11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// readMP3Info 解析第一帧的帧头，优先使用 Xing/Info 或 VBRI 帧中的总帧数计算时长，否则按 CBR 估算
+func readMP3Info(r io.ReadSeeker, size int64) (audioInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return audioInfo{}, err
+	}
+	if err := skipID3v2(r); err != nil {
+		return audioInfo{}, err
+	}
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return audioInfo{}, err
+	}
+
+	// 在开头 64KB 内寻找帧同步
+	buf := make([]byte, 64*1024)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		h := binary.BigEndian.Uint32(buf[i:])
+		versionBits := (h >> 19) & 0x3
+		layerBits := (h >> 17) & 0x3
+		bitrateIdx := (h >> 12) & 0xf
+		rateIdx := (h >> 10) & 0x3
+		if versionBits == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 0xf || rateIdx == 3 {
+			continue
+		}
+
+		var version int // 0: MPEG-1, 1: MPEG-2, 2: MPEG-2.5
+		switch versionBits {
+		case 3:
+			version = 0
+		case 2:
+			version = 1
+		default:
+			version = 2
+		}
+		layer := 3 - int(layerBits) // 0: Layer I, 1: Layer II, 2: Layer III
+		table := 0
+		if version > 0 {
+			table = 1
+		}
+		mono := (h>>6)&0x3 == 3
+
+		info := audioInfo{
+			SampleRate: mp3SampleRates[version][rateIdx],
+			Bitrate:    mp3Bitrates[table][layer][bitrateIdx],
+			Channels:   2,
+			Codec:      "mp3",
+		}
+		if mono {
+			info.Channels = 1
+		}
+
+		samplesPerFrame := 1152
+		if layer == 0 {
+			samplesPerFrame = 384
+		} else if layer == 2 && version > 0 {
+			samplesPerFrame = 576
+		}
+
+		// Xing/Info 帧位于边信息之后
+		sideInfo := 32
+		if version == 0 && mono {
+			sideInfo = 17
+		} else if version > 0 && !mono {
+			sideInfo = 17
+		} else if version > 0 && mono {
+			sideInfo = 9
+		}
+		frames := 0
+		if x := i + 4 + sideInfo; x+12 <= len(buf) {
+			tag := string(buf[x : x+4])
+			if (tag == "Xing" || tag == "Info") && buf[x+7]&0x1 != 0 {
+				frames = int(binary.BigEndian.Uint32(buf[x+8:]))
+			}
+		}
+		// VBRI 帧固定位于帧头后 32 字节
+		if x := i + 4 + 32; frames == 0 && x+18 <= len(buf) && string(buf[x:x+4]) == "VBRI" {
+			frames = int(binary.BigEndian.Uint32(buf[x+14:]))
+		}
+
+		audioBytes := size - start - int64(i)
+		if frames > 0 {
+			info.Duration = float64(frames*samplesPerFrame) / float64(info.SampleRate)
+			if info.Duration > 0 {
+				info.Bitrate = int(float64(audioBytes) * 8 / info.Duration / 1000)
+			}
+		} else if info.Bitrate > 0 {
+			info.Duration = float64(audioBytes) * 8 / float64(info.Bitrate*1000)
+		}
+		return info, nil
+	}
+	return audioInfo{}, errors.New("no mpeg frame found")
+}
+
+// readMP4Info 解析 moov/mvhd 得到时长，并从 stsd 的音频采样描述中取采样率、声道与编码
+func readMP4Info(r io.ReadSeeker) (audioInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return audioInfo{}, err
+	}
+	var info audioInfo
+	found := false
+	err := walkMP4Boxes(r, -1, func(typ string, body []byte) {
+		switch typ {
+		case "mvhd":
+			if len(body) < 20 {
+				return
+			}
+			var timescale uint32
+			var duration uint64
+			if body[0] == 1 && len(body) >= 32 {
+				timescale = binary.BigEndian.Uint32(body[20:])
+				duration = binary.BigEndian.Uint64(body[24:])
+			} else {
+				timescale = binary.BigEndian.Uint32(body[12:])
+				duration = uint64(binary.BigEndian.Uint32(body[16:]))
+			}
+			if timescale > 0 {
+				info.Duration = float64(duration) / float64(timescale)
+				found = true
+			}
+		case "stsd":
+			// version/flags(4) + 条目数(4)，之后是第一个采样描述
+			if len(body) < 8+36 || info.Codec != "" {
+				return
+			}
+			entry := body[8:]
+			format := string(entry[4:8])
+			switch format {
+			case "mp4a":
+				info.Codec = "aac"
+			case "alac":
+				info.Codec = "alac"
+			case "ac-3", "ec-3":
+				info.Codec = format
+			default:
+				return
+			}
+			// 8 字节盒头 + 6 保留 + 2 数据引用索引 + 8 保留，之后为声道数、采样位数、预留、采样率(16.16)
+			info.Channels = int(binary.BigEndian.Uint16(entry[24:]))
+			if info.Codec == "alac" {
+				info.BitDepth = int(binary.BigEndian.Uint16(entry[26:]))
+			}
+			info.SampleRate = int(binary.BigEndian.Uint32(entry[32:]) >> 16)
+		}
+	})
+	if err != nil && !found {
+		return audioInfo{}, err
+	}
+	if !found {
+		return audioInfo{}, errors.New("mvhd not found")
+	}
+	return info, nil
+}
+
+// mp4Containers 是需要进入内部继续查找的容器盒
+var mp4Containers = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+// walkMP4Boxes 遍历盒结构，对 mvhd 与 stsd 回调其内容；limit 为 -1 表示读到文件末尾
+func walkMP4Boxes(r io.ReadSeeker, limit int64, fn func(typ string, body []byte)) error {
+	var read int64
+	for limit < 0 || read+8 <= limit {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+		headerLen := int64(8)
+		if size == 1 {
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(ext[:]))
+			headerLen = 16
+		} else if size == 0 {
+			// 盒一直延伸到文件末尾，不会再包含需要的信息
+			return nil
+		}
+		if size < headerLen {
+			return errors.New("invalid mp4 box")
+		}
+		bodyLen := size - headerLen
+
+		switch {
+		case mp4Containers[typ]:
+			if err := walkMP4Boxes(r, bodyLen, fn); err != nil {
+				return err
+			}
+		case (typ == "mvhd" || typ == "stsd") && bodyLen < 1<<20:
+			body := make([]byte, bodyLen)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return err
+			}
+			fn(typ, body)
+		default:
+			if _, err := r.Seek(bodyLen, io.SeekCurrent); err != nil {
+				return err
+			}
+		}
+		read += size
+	}
+	return nil
+}
+
+// readOggInfo 从第一个页面的 Vorbis/Opus 标识头读取采样率与声道，从最后一个页面的颗粒位置计算时长
+func readOggInfo(r io.ReadSeeker, size int64) (audioInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return audioInfo{}, err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	head = head[:n]
+	if !bytes.HasPrefix(head, []byte("OggS")) || len(head) < 27 {
+		return audioInfo{}, errors.New("not an ogg stream")
+	}
+	segments := int(head[26])
+	payload := 27 + segments
+	if payload >= len(head) {
+		return audioInfo{}, errors.New("short ogg page")
+	}
+	packet := head[payload:]
+
+	var info audioInfo
+	preSkip := 0
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		info.Codec = "vorbis"
+		info.Channels = int(packet[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:]))
+		if len(packet) >= 24 {
+			info.Bitrate = int(int32(binary.LittleEndian.Uint32(packet[20:]))) / 1000
+		}
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 16:
+		info.Codec = "opus"
+		info.Channels = int(packet[9])
+		preSkip = int(binary.LittleEndian.Uint16(packet[10:]))
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:]))
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")) && len(packet) >= 17+34:
+		info.Codec = "flac"
+		// 映射头(13) + "fLaC" 之后的元数据块头(4) 之后为 STREAMINFO
+		v := binary.BigEndian.Uint64(packet[17+10:])
+		info.SampleRate = int(v >> 44)
+		info.Channels = int((v>>41)&0x7) + 1
+		info.BitDepth = int((v>>36)&0x1f) + 1
+	default:
+		return audioInfo{}, errors.New("unknown ogg codec")
+	}
+	if info.Bitrate < 0 {
+		info.Bitrate = 0
+	}
+
+	// 颗粒位置以解码采样率计，Opus 固定为 48kHz
+	granuleRate := info.SampleRate
+	if info.Codec == "opus" {
+		granuleRate = 48000
+	}
+	tailLen := int64(64 * 1024)
+	if tailLen > size {
+		tailLen = size
+	}
+	if _, err := r.Seek(size-tailLen, io.SeekStart); err != nil {
+		return info, nil
+	}
+	tail := make([]byte, tailLen)
+	n, _ = io.ReadFull(r, tail)
+	tail = tail[:n]
+	if idx := bytes.LastIndex(tail, []byte("OggS")); idx >= 0 && idx+14 <= len(tail) && granuleRate > 0 {
+		granule := int64(binary.LittleEndian.Uint64(tail[idx+6:]))
+		if granule > int64(preSkip) {
+			info.Duration = float64(granule-int64(preSkip)) / float64(granuleRate)
+		}
+	}
+	return info, nil
+}
+
+// readWAVInfo 解析 RIFF/WAVE 的 fmt 与 data 块
+func readWAVInfo(r io.ReadSeeker) (audioInfo, error) {
+	wf, dataSize, err := readWAVHeader(r)
+	if err != nil && !(errors.Is(err, errWAVNoData) && wf.SampleRate > 0) {
+		return audioInfo{}, err
+	}
+	info := audioInfo{
+		Codec:      "pcm",
+		Channels:   wf.Channels,
+		SampleRate: wf.SampleRate,
+		BitDepth:   wf.BitDepth,
+		Bitrate:    wf.ByteRate * 8 / 1000,
+	}
+	if err == nil && wf.ByteRate > 0 {
+		info.Duration = float64(dataSize) / float64(wf.ByteRate)
+	}
+	return info, nil
+}
+
+// maxWAVFmtRead fmt 块最多读取的字节数（WAVE_FORMAT_EXTENSIBLE 为 40 字节），超出部分直接跳过
+const maxWAVFmtRead = 40
+
+// errWAVNoData 表示文件中没有 data 块
+var errWAVNoData = errors.New("data chunk not found")
+
+// wavFormat 是 WAV fmt 块中的格式信息
+type wavFormat struct {
+	Format     int // 1 为整数 PCM，3 为浮点；WAVE_FORMAT_EXTENSIBLE 已换成子格式
+	Channels   int
+	SampleRate int
+	ByteRate   int
+	BitDepth   int
+}
+
+// readWAVHeader 从头遍历 RIFF/WAVE 块，解析 fmt 块并停在 data 块开头，返回格式与 data 块大小。
+// 块大小来自文件本身，只用于 Seek 跳过，不据此分配内存
+func readWAVHeader(r io.ReadSeeker) (wavFormat, int64, error) {
+	var wf wavFormat
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return wf, 0, err
+	}
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return wf, 0, err
+	}
+	if string(riff[:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return wf, 0, errors.New("not a wave file")
+	}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return wf, 0, errWAVNoData
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		// 块按偶数字节对齐
+		skip := size + size%2
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return wf, 0, errors.New("invalid fmt chunk")
+			}
+			body := make([]byte, min(size, maxWAVFmtRead))
+			if _, err := io.ReadFull(r, body); err != nil {
+				return wf, 0, errors.New("invalid fmt chunk")
+			}
+			skip -= int64(len(body))
+			wf.Format = int(binary.LittleEndian.Uint16(body))
+			wf.Channels = int(binary.LittleEndian.Uint16(body[2:]))
+			wf.SampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+			wf.ByteRate = int(binary.LittleEndian.Uint32(body[8:]))
+			wf.BitDepth = int(binary.LittleEndian.Uint16(body[14:]))
+			if wf.Format == 0xFFFE && len(body) >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE：子格式 GUID 的前两个字节是实际编码
+				wf.Format = int(binary.LittleEndian.Uint16(body[24:]))
+			}
+		case "data":
+			if wf.Channels == 0 || wf.BitDepth == 0 {
+				return wf, 0, errors.New("fmt chunk not found")
+			}
+			return wf, size, nil
+		}
+		if _, err := r.Seek(skip, io.SeekCurrent); err != nil {
+			return wf, 0, err
+		}
+	}
+}
+
+// skipID3v2 跳过文件开头的 ID3v2 标签（如有），定位到音频数据
+func skipID3v2(r io.ReadSeeker) error {
+	var h [10]byte
+	n, err := io.ReadFull(r, h[:])
+	if err != nil && n < 10 {
+		_, err = r.Seek(-int64(n), io.SeekCurrent)
+		return err
+	}
+	if string(h[:3]) != "ID3" {
+		_, err = r.Seek(-10, io.SeekCurrent)
+		return err
+	}
+	size := int64(h[6]&0x7f)<<21 | int64(h[7]&0x7f)<<14 | int64(h[8]&0x7f)<<7 | int64(h[9]&0x7f)
+	if h[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	_, err = r.Seek(size, io.SeekCurrent)
+	return err
+}
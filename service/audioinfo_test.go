@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// wavFile 拼出一个 RIFF/WAVE 文件，chunks 按顺序写入（各块已含块头）
+func wavFile(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	out := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(out, body...)
+}
+
+func riffChunk(id string, size uint32, body []byte) []byte {
+	out := append([]byte(id), binary.LittleEndian.AppendUint32(nil, size)...)
+	return append(out, body...)
+}
+
+func wavFmtBody(format, channels, rate, bits int) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, uint16(format))
+	b = binary.LittleEndian.AppendUint16(b, uint16(channels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(rate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(rate*channels*bits/8))
+	b = binary.LittleEndian.AppendUint16(b, uint16(channels*bits/8))
+	return binary.LittleEndian.AppendUint16(b, uint16(bits))
+}
+
+func flacFile(rate, channels, bits int, samples uint64) []byte {
+	si := make([]byte, 34)
+	v := uint64(rate)<<44 | uint64(channels-1)<<41 | uint64(bits-1)<<36 | samples
+	binary.BigEndian.PutUint64(si[10:], v)
+	return append([]byte("fLaC\x80\x00\x00\x22"), si...)
+}
+
+// mp3File 返回以给定帧头开始、总长 size 的数据，extra 写在帧头之后
+func mp3File(prefix []byte, header uint32, extra []byte, size int) []byte {
+	b := append([]byte{}, prefix...)
+	b = binary.BigEndian.AppendUint32(b, header)
+	b = append(b, extra...)
+	return append(b, make([]byte, size-len(b))...)
+}
+
+func TestReadAudioInfo(t *testing.T) {
+	pcm := wavFmtBody(1, 2, 44100, 16)
+	extensible := append(wavFmtBody(0xFFFE, 2, 48000, 24), make([]byte, 24)...)
+	binary.LittleEndian.PutUint16(extensible[24:], 1)
+	xing := append(make([]byte, 32), "Xing\x00\x00\x00\x01"...)
+	xing = binary.BigEndian.AppendUint32(xing, 125)
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x64"), make([]byte, 100)...)
+
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+		size   int64 // 0 表示使用 len(data)
+		want   audioInfo
+	}{
+		{
+			name:   "wav pcm",
+			format: "wav",
+			data:   wavFile(riffChunk("fmt ", 16, pcm), riffChunk("data", 352800, nil)),
+			want:   audioInfo{Codec: "pcm", Channels: 2, SampleRate: 44100, BitDepth: 16, Bitrate: 1411, Duration: 2},
+		},
+		{
+			name:   "wav odd sized chunk before data is padded",
+			format: "wav",
+			data: wavFile(riffChunk("LIST", 3, []byte("abc\x00")), riffChunk("fmt ", 16, pcm),
+				riffChunk("data", 176400, nil)),
+			want: audioInfo{Codec: "pcm", Channels: 2, SampleRate: 44100, BitDepth: 16, Bitrate: 1411, Duration: 1},
+		},
+		{
+			name:   "wav extensible",
+			format: "wav",
+			data:   wavFile(riffChunk("fmt ", 40, extensible), riffChunk("data", 288000, nil)),
+			want:   audioInfo{Codec: "pcm", Channels: 2, SampleRate: 48000, BitDepth: 24, Bitrate: 2304, Duration: 1},
+		},
+		{
+			name:   "wav oversized fmt chunk is skipped, not read",
+			format: "wav",
+			data:   wavFile(riffChunk("fmt ", 0xFFFFFFF0, append(pcm, make([]byte, 24)...))),
+			want:   audioInfo{Codec: "pcm", Channels: 2, SampleRate: 44100, BitDepth: 16, Bitrate: 1411},
+		},
+		{
+			name:   "wav data before fmt",
+			format: "wav",
+			data:   wavFile(riffChunk("data", 4, []byte("\x00\x00\x00\x00")), riffChunk("fmt ", 16, pcm)),
+			want:   audioInfo{Codec: "wav"},
+		},
+		{
+			name:   "wav short fmt chunk",
+			format: "wav",
+			data:   wavFile(riffChunk("fmt ", 8, pcm[:8])),
+			want:   audioInfo{Codec: "wav"},
+		},
+		{
+			name:   "flac",
+			format: "flac",
+			data:   flacFile(44100, 2, 16, 132300),
+			size:   300000,
+			want:   audioInfo{Codec: "flac", Channels: 2, SampleRate: 44100, BitDepth: 16, Bitrate: 800, Duration: 3},
+		},
+		{
+			name:   "flac with id3 tag",
+			format: "flac",
+			data:   append(append([]byte{}, id3...), flacFile(96000, 1, 24, 96000)...),
+			size:   120000,
+			want:   audioInfo{Codec: "flac", Channels: 1, SampleRate: 96000, BitDepth: 24, Bitrate: 960, Duration: 1},
+		},
+		{
+			name:   "mp3 cbr",
+			format: "mp3",
+			data:   mp3File(nil, 0xFFFB9000, nil, 64000),
+			want:   audioInfo{Codec: "mp3", Channels: 2, SampleRate: 44100, Bitrate: 128, Duration: 4},
+		},
+		{
+			name:   "mp3 cbr after id3 tag",
+			format: "mp3",
+			data:   mp3File(id3, 0xFFFB90C0, nil, 64000+len(id3)),
+			want:   audioInfo{Codec: "mp3", Channels: 1, SampleRate: 44100, Bitrate: 128, Duration: 4},
+		},
+		{
+			name:   "mp3 xing frame count",
+			format: "mp3",
+			data:   mp3File(nil, 0xFFFB9400, xing, 48000),
+			want:   audioInfo{Codec: "mp3", Channels: 2, SampleRate: 48000, Bitrate: 128, Duration: 3},
+		},
+		{
+			name:   "garbage",
+			format: "flac",
+			data:   []byte("not audio at all"),
+			want:   audioInfo{Codec: "flac"},
+		},
+		{
+			name:   "unsupported format",
+			format: "dsf",
+			data:   []byte("DSD "),
+			want:   audioInfo{Codec: "dsf"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := tt.size
+			if size == 0 {
+				size = int64(len(tt.data))
+			}
+			got := readAudioInfo(bytes.NewReader(tt.data), audioFormat{Name: tt.format}, size)
+			if got != tt.want {
+				t.Errorf("readAudioInfo = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadWAVHeaderExtensibleSubformat(t *testing.T) {
+	fmtBody := append(wavFmtBody(0xFFFE, 2, 48000, 32), make([]byte, 24)...)
+	binary.LittleEndian.PutUint16(fmtBody[24:], 3)
+	wf, size, err := readWAVHeader(bytes.NewReader(wavFile(riffChunk("fmt ", 40, fmtBody), riffChunk("data", 1024, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wf.Format != 3 || size != 1024 {
+		t.Errorf("readWAVHeader = format %d, size %d; want format 3, size 1024", wf.Format, size)
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// coverSizes 是缩略图可选的边长（像素），请求的尺寸向上取到最接近的一档
+var coverSizes = []int{64, 128, 256, 512, 1024}
+
+// coverJPEGQuality 缩略图的 JPEG 质量
+const coverJPEGQuality = 85
+
+// CoverImage 是封面图片及用于 HTTP 缓存校验的信息
+type CoverImage struct {
+	Data    []byte
+	MIME    string
+	ETag    string
+	ModTime time.Time
+}
+
+// contentHash 返回数据的 SHA-1 十六进制摘要，用作封面缓存键
+func contentHash(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// coverSize 把请求的尺寸规整到固定档位，0 表示原图
+func coverSize(size int) int {
+	if size <= 0 {
+		return 0
+	}
+	for _, s := range coverSizes {
+		if size <= s {
+			return s
+		}
+	}
+	return coverSizes[len(coverSizes)-1]
+}
+
+func coverCacheDir() string {
+	return filepath.Join(dataDir, "covers")
+}
+
+// CoverETag 返回封面的 ETag，只依赖扫描时记录的封面哈希，无需读取音频文件。
+// 缩略图的 ETag 含尺寸与输出格式；原图无法解码而改返回原图时，GetCoverImage 换用原图格式生成 ETag
+func CoverETag(id, size int, format string) (string, error) {
+	t, err := getTrackByID(id)
+	if err != nil {
+		return "", err
+	}
+	if t.CoverHash == "" {
+		return "", errors.New("no cover")
+	}
+	size = coverSize(size)
+	if size == 0 {
+		return `"` + t.CoverHash + `"`, nil
+	}
+	return fmt.Sprintf(`"%s-%d-%s"`, t.CoverHash, size, format), nil
+}
+
+// GetCoverImage 返回曲目封面。size 为 0 时返回原图，否则返回按固定档位缩放的缩略图，
+// format 为 "jpeg" 或 "webp"。缩略图以封面哈希为键缓存在数据目录下，同一封面的曲目共用缓存
+func GetCoverImage(id, size int, format string) (*CoverImage, error) {
+	if format != "webp" {
+		format = "jpeg"
+	}
+	t, err := getTrackByID(id)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := CoverETag(id, size, format)
+	if err != nil {
+		return nil, err
+	}
+	size = coverSize(size)
+
+	if size == 0 {
+		data, ctype, err := ReadCover(id)
+		if err != nil {
+			return nil, err
+		}
+		return &CoverImage{Data: data, MIME: ctype, ETag: etag, ModTime: time.Unix(0, t.ModTime)}, nil
+	}
+
+	ext, ctype := ".jpg", "image/jpeg"
+	if format == "webp" {
+		ext, ctype = ".webp", "image/webp"
+	}
+	cachePath := filepath.Join(coverCacheDir(), fmt.Sprintf("%s_%d%s", t.CoverHash, size, ext))
+	if data, err := os.ReadFile(cachePath); err == nil {
+		modTime := time.Now()
+		if info, err := os.Stat(cachePath); err == nil {
+			modTime = info.ModTime()
+		}
+		return &CoverImage{Data: data, MIME: ctype, ETag: etag, ModTime: modTime}, nil
+	}
+
+	src, srcType, err := ReadCover(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := resizeCover(src, size, format)
+	if err != nil {
+		// 无法解码的图片直接返回原图，ETag 按原图的格式生成，不与缩略图的混用
+		srcFormat := strings.TrimPrefix(srcType, "image/")
+		return &CoverImage{Data: src, MIME: srcType, ETag: fmt.Sprintf(`"%s-%d-%s"`, t.CoverHash, size, srcFormat), ModTime: time.Unix(0, t.ModTime)}, nil
+	}
+	if err := writeFileAtomic(cachePath, data); err != nil {
+		fmt.Printf("保存封面缓存失败: %v\n", err)
+	}
+	return &CoverImage{Data: data, MIME: ctype, ETag: etag, ModTime: time.Now()}, nil
+}
+
+// resizeCover 把图片等比缩放到最长边不超过 size（不放大），编码为 JPEG 或 WebP
+func resizeCover(src []byte, size int, format string) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return nil, errors.New("empty image")
+	}
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if format == "webp" {
+		err = nativewebp.Encode(&buf, dst, nil)
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: coverJPEGQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic 先写临时文件再重命名，并发写同一文件时不会留下半截内容
+func writeFileAtomic(p string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
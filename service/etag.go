@@ -0,0 +1,87 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+	"sync"
+)
+
+// mediaETagEntry 是按路径缓存的文件摘要，修改时间或大小变化后失效
+type mediaETagEntry struct {
+	modTime int64
+	size    int64
+	etag    string
+}
+
+// maxMediaETags 内存中缓存的摘要条数上限，超出时清空重新累积
+const maxMediaETags = 4096
+
+var (
+	mediaETagsMu sync.Mutex
+	mediaETags   = map[string]mediaETagEntry{}
+)
+
+// MediaETag 返回本地媒体文件的强 ETag（已带引号），取自整个文件内容的 SHA-256。
+// 曲库文件使用扫描时计算并保存在索引中的摘要；转码缓存、HLS 分片等其他文件
+// 按（路径、修改时间、大小）缓存，只在文件变化后重新计算一次
+func MediaETag(p string, info os.FileInfo) (string, error) {
+	mtime, size := info.ModTime().UnixNano(), info.Size()
+	mediaETagsMu.Lock()
+	e, ok := mediaETags[p]
+	mediaETagsMu.Unlock()
+	if ok && e.modTime == mtime && e.size == size {
+		return e.etag, nil
+	}
+
+	sum := libraryContentHash(p, mtime, size)
+	if sum == "" {
+		var err error
+		if sum, err = fileContentHash(p); err != nil {
+			return "", err
+		}
+	}
+	etag := `"` + sum + `"`
+
+	mediaETagsMu.Lock()
+	if len(mediaETags) >= maxMediaETags {
+		mediaETags = map[string]mediaETagEntry{}
+	}
+	mediaETags[p] = mediaETagEntry{modTime: mtime, size: size, etag: etag}
+	mediaETagsMu.Unlock()
+	return etag, nil
+}
+
+// libraryContentHash 返回曲库中该文件扫描时记录的内容摘要，文件已变化或不在曲库中时返回空串
+func libraryContentHash(p string, mtime, size int64) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, t := range tracks {
+		if t.Path == p {
+			if t.ModTime == mtime && t.Size == size {
+				return t.ContentHash
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// fileContentHash 计算整个文件的 SHA-256，取前 18 字节做 base64url 编码
+func fileContentHash(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readerContentHash(f)
+}
+
+func readerContentHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]), nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMediaETag(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte, mtime time.Time) (string, os.FileInfo) {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p, info
+	}
+	etag := func(p string, info os.FileInfo) string {
+		tag, err := MediaETag(p, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tag
+	}
+
+	mtime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := bytes.Repeat([]byte{1}, 300<<10)
+	a, infoA := write("a.flac", data, mtime)
+
+	// 只改动中间的字节：头尾相同、大小相同的两个文件必须得到不同的 ETag
+	middle := bytes.Clone(data)
+	middle[len(middle)/2] = 2
+	b, infoB := write("b.flac", middle, mtime)
+	tagA, tagB := etag(a, infoA), etag(b, infoB)
+	if tagA == tagB {
+		t.Errorf("files differing only in the middle share ETag %s", tagA)
+	}
+	if tagA[0] != '"' || tagA[len(tagA)-1] != '"' {
+		t.Errorf("ETag %s is not quoted", tagA)
+	}
+
+	// 修改时间与大小不变时使用缓存，变化后重新计算
+	if got := etag(a, infoA); got != tagA {
+		t.Errorf("cached ETag = %s, want %s", got, tagA)
+	}
+	a, infoA = write("a.flac", middle, mtime.Add(time.Second))
+	if got := etag(a, infoA); got != tagB {
+		t.Errorf("ETag after rewrite = %s, want %s", got, tagB)
+	}
+
+	// 曲库文件直接使用扫描时记录的摘要
+	mu.Lock()
+	prevTracks := tracks
+	tracks = []Track{{Path: a, ModTime: infoA.ModTime().UnixNano(), Size: infoA.Size(), ContentHash: "from-index"}}
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		tracks = prevTracks
+		mu.Unlock()
+	}()
+	mediaETagsMu.Lock()
+	delete(mediaETags, a)
+	mediaETagsMu.Unlock()
+	if got := etag(a, infoA); got != `"from-index"` {
+		t.Errorf("library ETag = %s, want the indexed content hash", got)
+	}
+}
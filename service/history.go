@@ -0,0 +1,65 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RecordPlay 把一次完整播放写入 play_history 表，同时保存曲目信息，曲库变化后记录仍可读
+func RecordPlay(userUUID string, trackID int, playedAt time.Time) error {
+	t, err := getTrackByID(trackID)
+	if err != nil {
+		return err
+	}
+	_, err = supabaseREST("POST", "play_history", map[string]interface{}{
+		"user_id":     userUUID,
+		"song_id":     strconv.Itoa(t.ID),
+		"song_title":  t.Title,
+		"song_artist": t.Artist,
+		"song_album":  t.Album,
+		"played_at":   playedAt.UTC().Format(time.RFC3339),
+	})
+	return err
+}
+
+// PlayStat 是用户对一首曲目的播放统计
+type PlayStat struct {
+	Count    int
+	LastPlay time.Time
+}
+
+// GetPlayStats 按曲目汇总用户的播放次数与最近播放时间。汇总由数据库中的 play_stats 视图完成，
+// 返回的行数只与播放过的曲目数有关，不随播放历史增长，因此分页读取全部行，不做截断。
+// play_history 由客户端上报（网页播放器与 Subsonic scrobble），用户可以伪造播放记录，
+// 播放次数只适合用于用户自己的智能歌单，不能作为可信的统计
+func GetPlayStats(userUUID string) (map[int]PlayStat, error) {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return nil, fmt.Errorf("invalid user id")
+	}
+	const pageSize = 1000
+	out := map[int]PlayStat{}
+	for offset := 0; ; offset += pageSize {
+		rows, err := supabaseREST("GET", fmt.Sprintf("play_stats?user_id=eq.%s&select=song_id,play_count,last_played&order=song_id&limit=%d&offset=%d",
+			userUUID, pageSize, offset), nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			id, err := strconv.Atoi(getStringFromMap(row, "song_id", ""))
+			if err != nil {
+				continue
+			}
+			st := out[id]
+			st.Count += getIntFromMapUpload(row, "play_count", 0)
+			if at, err := time.Parse(time.RFC3339, getStringFromMap(row, "last_played", "")); err == nil && at.After(st.LastPlay) {
+				st.LastPlay = at
+			}
+			out[id] = st
+		}
+		if len(rows) < pageSize {
+			break
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlsSegmentSeconds 每个 HLS 分片的时长（秒）
+const hlsSegmentSeconds = 6
+
+// HLSVariant 是一个 HLS 码率档位，分片统一为 MPEG-TS 封装的 AAC
+type HLSVariant struct {
+	Name    string
+	Bitrate int // kbps
+}
+
+// hlsVariants 由 HLS_VARIANTS 配置（逗号分隔的 kbps，如 "64,128,256"），默认只提供 128k 单一码率
+var hlsVariants = loadHLSVariants()
+
+func loadHLSVariants() []HLSVariant {
+	var out []HLSVariant
+	for _, s := range strings.Split(getEnvDefault("HLS_VARIANTS", "128"), ",") {
+		if kbps, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && kbps > 0 {
+			out = append(out, HLSVariant{Name: strconv.Itoa(kbps) + "k", Bitrate: kbps})
+		}
+	}
+	if len(out) == 0 {
+		out = []HLSVariant{{Name: "128k", Bitrate: 128}}
+	}
+	return out
+}
+
+var (
+	// ErrHLSSegmentNotFound 表示请求的分片或档位不存在
+	ErrHLSSegmentNotFound = errors.New("hls segment not found")
+
+	// uploadIDPattern 限制上传文件ID的字符，避免拼进存储查询时被注入
+	uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// hlsSource 是 HLS 切片的输入：本地曲库中的文件，或缓存到本地的上传文件
+type hlsSource struct {
+	key      string // 缓存目录名，源文件变化时随之变化
+	path     string
+	duration float64 // 秒，未知时为 0
+}
+
+// hlsSourceTTL 解析结果的缓存时间：播放器逐个请求分片，避免每个请求都查询数据库
+const hlsSourceTTL = 5 * time.Minute
+
+type hlsSourceEntry struct {
+	src     *hlsSource
+	expires time.Time
+}
+
+var (
+	hlsSourcesMu sync.Mutex
+	hlsSources   = map[string]hlsSourceEntry{}
+)
+
+// resolveHLSSource 根据路径中的ID找到输入文件：纯数字为曲库曲目ID，否则为当前用户上传的文件ID。
+// 结果按用户与曲目缓存 hlsSourceTTL
+func resolveHLSSource(ctx context.Context, trackID, userID string) (*hlsSource, error) {
+	cacheKey := userID + "/" + trackID
+	now := time.Now()
+	hlsSourcesMu.Lock()
+	if e, ok := hlsSources[cacheKey]; ok && now.Before(e.expires) {
+		hlsSourcesMu.Unlock()
+		return e.src, nil
+	}
+	hlsSourcesMu.Unlock()
+
+	src, err := lookupHLSSource(ctx, trackID, userID)
+	if err != nil {
+		return nil, err
+	}
+	hlsSourcesMu.Lock()
+	for k, e := range hlsSources {
+		if !now.Before(e.expires) {
+			delete(hlsSources, k)
+		}
+	}
+	hlsSources[cacheKey] = hlsSourceEntry{src: src, expires: now.Add(hlsSourceTTL)}
+	hlsSourcesMu.Unlock()
+	return src, nil
+}
+
+func lookupHLSSource(ctx context.Context, trackID, userID string) (*hlsSource, error) {
+	if id, err := strconv.Atoi(trackID); err == nil {
+		t, err := getTrackByID(id)
+		if err != nil {
+			return nil, err
+		}
+		return &hlsSource{key: fmt.Sprintf("%d_%d", t.ID, t.ModTime), path: t.Path, duration: t.Duration}, nil
+	}
+	if !uploadIDPattern.MatchString(trackID) {
+		return nil, errors.New("invalid track id")
+	}
+	if userID == "" {
+		return nil, errors.New("user not authenticated")
+	}
+	mf, err := GetMusicFileByID(trackID, userID)
+	if err != nil {
+		return nil, err
+	}
+	p, err := cacheUploadSource(ctx, mf)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	format, _ := lookupAudioFormat(mf.FileName)
+	ai := readAudioInfo(f, format, info.Size())
+	return &hlsSource{key: "u_" + mf.ID, path: p, duration: ai.Duration}, nil
+}
+
+// cacheUploadSource 把上传文件从存储下载到转码缓存目录，供 ffmpeg 按时间定位读取
+func cacheUploadSource(ctx context.Context, mf *MusicFile) (string, error) {
+	p := filepath.Join(transcodeCacheDir(), "hls", "src", "u_"+mf.ID+strings.ToLower(filepath.Ext(mf.FileName)))
+	if info, err := os.Stat(p); err == nil && (mf.FileSize == 0 || info.Size() == mf.FileSize) {
+		return p, nil
+	}
+	obj, err := OpenStorageObject(ctx, mf.StoragePath, mf.FileSize)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, obj); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return p, nil
+}
+
+func findHLSVariant(name string) (HLSVariant, bool) {
+	for _, v := range hlsVariants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return HLSVariant{}, false
+}
+
+// HLSMasterPlaylist 生成主播放列表，列出各码率档位的媒体播放列表（相对路径）
+func HLSMasterPlaylist(ctx context.Context, trackID, userID string) (string, error) {
+	if _, err := resolveHLSSource(ctx, trackID, userID); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range hlsVariants {
+		// BANDWIDTH 包含 MPEG-TS 封装开销，按码率的 110% 估算
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"mp4a.40.2\"\n%s/index.m3u8\n", v.Bitrate*1100, v.Name)
+	}
+	return b.String(), nil
+}
+
+// hlsPollInterval 等待分片写完时检查文件的间隔
+const hlsPollInterval = 100 * time.Millisecond
+
+// HLSMediaPlaylist 返回某个档位的点播媒体播放列表，不等待编码：
+// 编码完成后返回 ffmpeg 写出的实际列表，之前按时长生成列表并在后台开始编码
+func HLSMediaPlaylist(ctx context.Context, trackID, userID, variant string) (string, error) {
+	src, v, dir, err := hlsVariantDir(ctx, trackID, userID, variant)
+	if err != nil {
+		return "", err
+	}
+	playlist := filepath.Join(dir, "index.m3u8")
+	if data, err := os.ReadFile(playlist); err == nil {
+		touchCacheFile(playlist)
+		return rewriteHLSPlaylist(data), nil
+	}
+	if !TranscodeAvailable() {
+		return "", ErrTranscodeUnavailable
+	}
+	job := startHLSEncode(src, v, dir)
+	if src.duration > 0 {
+		return synthHLSPlaylist(src.duration), nil
+	}
+	// 时长未知时无法预先列出分片，只能等编码完成
+	select {
+	case <-job.done:
+		if job.err != nil {
+			return "", job.err
+		}
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		return "", err
+	}
+	return rewriteHLSPlaylist(data), nil
+}
+
+// rewriteHLSPlaylist 把 ffmpeg 写出的分片地址统一为与播放列表同目录的相对路径
+func rewriteHLSPlaylist(data []byte) string {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines[i] = filepath.Base(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// synthHLSPlaylist 按时长生成点播列表，分片划分与 ffmpeg 按 hlsSegmentSeconds 切片一致
+func synthHLSPlaylist(duration float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", hlsSegmentSeconds)
+	for i := 0; float64(i*hlsSegmentSeconds) < duration; i++ {
+		d := min(float64(hlsSegmentSeconds), duration-float64(i*hlsSegmentSeconds))
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\nseg%d.ts\n", d, i)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// HLSSegment 返回指定分片文件。档位还在编码时等待该分片写完后立即返回，不必等整首编码结束
+func HLSSegment(ctx context.Context, trackID, userID, variant string, index int) (*os.File, error) {
+	if index < 0 {
+		return nil, ErrHLSSegmentNotFound
+	}
+	src, v, dir, err := hlsVariantDir(ctx, trackID, userID, variant)
+	if err != nil {
+		return nil, err
+	}
+	// 时长已知时超出范围的分片直接返回，不为它启动编码
+	if src.duration > 0 && float64(index*hlsSegmentSeconds) >= src.duration+hlsSegmentSeconds {
+		return nil, ErrHLSSegmentNotFound
+	}
+	name := fmt.Sprintf("seg%d.ts", index)
+	for {
+		if f, err := os.Open(filepath.Join(dir, name)); err == nil {
+			return f, nil
+		}
+		playlist := filepath.Join(dir, "index.m3u8")
+		if data, err := os.ReadFile(playlist); err == nil {
+			if !strings.Contains(string(data), name+"\n") {
+				return nil, ErrHLSSegmentNotFound
+			}
+			// 播放列表中有该分片但文件已被缓存清理删除时，整个档位重新生成
+			os.Remove(playlist)
+		}
+		if !TranscodeAvailable() {
+			return nil, ErrTranscodeUnavailable
+		}
+		job := startHLSEncode(src, v, dir)
+		// 编码期间分片先写到 .tmp，写完才改名，能打开即是完整的分片
+		if f, err := os.Open(filepath.Join(dir+".tmp", name)); err == nil {
+			return f, nil
+		}
+		select {
+		case <-job.done:
+			if job.err != nil {
+				return nil, job.err
+			}
+			// 编码完成后临时目录已改名为正式目录，回到开头重新查找
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(hlsPollInterval):
+		}
+	}
+}
+
+// hlsVariantDir 解析输入文件与档位，返回该档位的切片目录（可能尚未生成）
+func hlsVariantDir(ctx context.Context, trackID, userID, variant string) (*hlsSource, HLSVariant, string, error) {
+	v, ok := findHLSVariant(variant)
+	if !ok {
+		return nil, HLSVariant{}, "", ErrHLSSegmentNotFound
+	}
+	src, err := resolveHLSSource(ctx, trackID, userID)
+	if err != nil {
+		return nil, HLSVariant{}, "", err
+	}
+	return src, v, filepath.Join(transcodeCacheDir(), "hls", src.key, v.Name), nil
+}
+
+// startHLSEncode 在后台启动（或返回已在进行的）档位编码：一次连续的 ffmpeg 编码写出全部分片与播放列表，
+// 分片来自同一个编码器，边界处没有重新起播的填充采样；与转码共用并发名额和缓存清理。
+// 分片写在 dir+".tmp" 中，每个分片写完才从 .tmp 文件改名，请求可以边编码边读取
+func startHLSEncode(src *hlsSource, v HLSVariant, dir string) *transcodeJob {
+	return startFFmpegJob(dir, func(tmp string) []string {
+		_ = os.MkdirAll(tmp, 0755)
+		return []string{
+			"-i", src.path, "-map", "0:a:0", "-vn", "-c:a", "aac", "-b:a", strconv.Itoa(v.Bitrate) + "k",
+			"-f", "hls", "-hls_time", strconv.Itoa(hlsSegmentSeconds), "-hls_playlist_type", "vod", "-hls_list_size", "0",
+			"-hls_flags", "temp_file",
+			"-hls_segment_filename", filepath.Join(tmp, "seg%d.ts"),
+			filepath.Join(tmp, "index.m3u8"),
+		}
+	})
+}
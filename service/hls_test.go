@@ -0,0 +1,26 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSynthHLSPlaylist(t *testing.T) {
+	got := synthHLSPlaylist(14.5)
+	for _, want := range []string{
+		"#EXT-X-TARGETDURATION:6\n",
+		"#EXTINF:6.000,\nseg0.ts\n",
+		"#EXTINF:6.000,\nseg1.ts\n",
+		"#EXTINF:2.500,\nseg2.ts\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("playlist missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "seg3.ts") {
+		t.Errorf("playlist lists a segment past the end:\n%s", got)
+	}
+	if !strings.HasSuffix(got, "#EXT-X-ENDLIST\n") {
+		t.Errorf("playlist not terminated:\n%s", got)
+	}
+}
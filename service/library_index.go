@@ -0,0 +1,160 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// libraryIndexVersion 索引文件格式版本，结构变化时递增以强制全量重扫
+const libraryIndexVersion = 10
+
+// dataDir 存放曲库索引等本地数据的目录，可通过 MUSIC_DATA_DIR 环境变量修改
+var dataDir = getEnvDefault("MUSIC_DATA_DIR", "data")
+
+// ScanReport 记录一次扫描相对上次索引新增、变更和删除的文件（“根目录名/相对路径”），
+// 以及无法读取而未收录的文件
+type ScanReport struct {
+	Added     []string      `json:"added"`
+	Changed   []string      `json:"changed"`
+	Removed   []string      `json:"removed"`
+	Failed    []ScanFailure `json:"failed"`
+	Total     int           `json:"total"`
+	StartedAt time.Time     `json:"startedAt"`
+	Duration  string        `json:"duration"`
+}
+
+// ScanFailure 是扫描时读取失败的文件及原因
+type ScanFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// lastScanReport 最近一次扫描的结果，与索引一起保存到磁盘，重启后仍可查询
+var lastScanReport *ScanReport
+
+func scanReportPath() string {
+	return filepath.Join(dataDir, "scan_report.json")
+}
+
+// loadScanReport 读取磁盘上的扫描结果，不存在或无法解析时返回 nil
+func loadScanReport() *ScanReport {
+	data, err := os.ReadFile(scanReportPath())
+	if err != nil {
+		return nil
+	}
+	var report ScanReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil
+	}
+	return &report
+}
+
+func saveScanReport(report *ScanReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(scanReportPath(), data)
+}
+
+// libraryIndex 是持久化到磁盘的曲库索引
+type libraryIndex struct {
+	Version int          `json:"version"`
+	Entries []indexEntry `json:"entries"`
+}
+
+// indexEntry 在 Track 基础上保存文件路径、修改时间与大小，用于判断文件是否需要重新读取标签
+type indexEntry struct {
+	Track
+	Path    string `json:"path"`
+	RelPath string `json:"rel"`
+	Lyrics  string `json:"lyrics,omitempty"`
+	ModTime int64  `json:"mtime"`
+	Size    int64  `json:"size"`
+	// 外部歌词与封面文件
+	LyricsFile string `json:"lyricsFile,omitempty"`
+	CoverFile  string `json:"coverFile,omitempty"`
+	CoverHash  string `json:"coverHash,omitempty"`
+	Sidecars   string `json:"sidecars,omitempty"`
+	// 整个文件的内容摘要
+	ContentHash string `json:"contentHash,omitempty"`
+}
+
+// LastScanReport 返回最近一次扫描的结果
+func LastScanReport() (*ScanReport, error) {
+	if err := InitMusicCache(); err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if lastScanReport == nil {
+		return &ScanReport{}, nil
+	}
+	report := *lastScanReport
+	return &report, nil
+}
+
+func libraryIndexPath() string {
+	return filepath.Join(dataDir, "library_index.json")
+}
+
+// loadLibraryIndex 读取磁盘上的曲库索引；文件不存在或版本不符时返回 nil
+func loadLibraryIndex() []Track {
+	data, err := os.ReadFile(libraryIndexPath())
+	if err != nil {
+		return nil
+	}
+	var idx libraryIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil
+	}
+	if idx.Version != libraryIndexVersion {
+		return nil
+	}
+	out := make([]Track, 0, len(idx.Entries))
+	for _, e := range idx.Entries {
+		t := e.Track
+		t.Path = e.Path
+		t.RelPath = e.RelPath
+		t.LyricsText = e.Lyrics
+		t.ModTime = e.ModTime
+		t.Size = e.Size
+		t.LyricsFile = e.LyricsFile
+		t.CoverFile = e.CoverFile
+		t.CoverHash = e.CoverHash
+		t.Sidecars = e.Sidecars
+		t.ContentHash = e.ContentHash
+		out = append(out, t)
+	}
+	return out
+}
+
+// saveLibraryIndex 将曲目写入索引文件，先写临时文件再重命名，避免中途失败或并发保存留下损坏的索引
+func saveLibraryIndex(list []Track) error {
+	idx := libraryIndex{
+		Version: libraryIndexVersion,
+		Entries: make([]indexEntry, 0, len(list)),
+	}
+	for _, t := range list {
+		idx.Entries = append(idx.Entries, indexEntry{
+			Track: t, Path: t.Path, RelPath: t.RelPath, Lyrics: t.LyricsText, ModTime: t.ModTime, Size: t.Size,
+			LyricsFile: t.LyricsFile, CoverFile: t.CoverFile, CoverHash: t.CoverHash, Sidecars: t.Sidecars,
+			ContentHash: t.ContentHash,
+		})
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(libraryIndexPath(), data)
+}
+
+// getEnvDefault 读取环境变量，未设置时返回默认值
+func getEnvDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
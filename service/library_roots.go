@@ -0,0 +1,256 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultRootName 默认曲库根目录的名称。该根目录下曲目的ID只由相对路径决定，
+// 与引入多根目录之前生成的ID保持一致。
+const defaultRootName = "default"
+
+// LibraryRoot 是一个命名的曲库根目录
+type LibraryRoot struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Enabled bool   `json:"enabled"`
+}
+
+var (
+	// roots 当前配置的曲库根目录，由 mu 保护
+	roots = loadLibraryRoots()
+	// rootsGen 每次修改根目录配置时递增，扫描结束时据此判断结果是否已过期
+	rootsGen int
+)
+
+func libraryRootsPath() string {
+	return filepath.Join(dataDir, "library_roots.json")
+}
+
+// loadLibraryRoots 读取根目录配置；没有配置文件时使用 MUSIC_DIR 环境变量（或内置路径）作为默认根目录
+func loadLibraryRoots() []LibraryRoot {
+	if data, err := os.ReadFile(libraryRootsPath()); err == nil {
+		var list []LibraryRoot
+		if err := json.Unmarshal(data, &list); err == nil {
+			return list
+		}
+	}
+	return []LibraryRoot{{
+		Name:    defaultRootName,
+		Path:    getEnvDefault("MUSIC_DIR", `C:\Users\28890\Desktop\music`),
+		Enabled: true,
+	}}
+}
+
+// saveLibraryRoots 持久化根目录配置，调用方需持有 mu
+func saveLibraryRoots() error {
+	data, err := json.MarshalIndent(roots, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(libraryRootsPath(), data, 0644)
+}
+
+// ListLibraryRoots 返回所有曲库根目录
+func ListLibraryRoots() []LibraryRoot {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]LibraryRoot, len(roots))
+	copy(out, roots)
+	return out
+}
+
+// enabledRoots 返回启用的根目录，调用方需持有 mu
+func enabledRoots() []LibraryRoot {
+	var out []LibraryRoot
+	for _, r := range roots {
+		if r.Enabled {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// AddLibraryRoot 添加一个曲库根目录并在后台重新扫描
+func AddLibraryRoot(root LibraryRoot) error {
+	root.Name = strings.TrimSpace(root.Name)
+	if root.Name == "" || strings.ContainsAny(root.Name, `/\`) {
+		return errors.New("invalid root name")
+	}
+	dir, err := cleanRootDir(root.Path)
+	if err != nil {
+		return err
+	}
+	root.Path = dir
+
+	mu.Lock()
+	for _, r := range roots {
+		if r.Name == root.Name {
+			mu.Unlock()
+			return fmt.Errorf("root already exists: %s", root.Name)
+		}
+	}
+	if err := checkRootOverlap(dir, ""); err != nil {
+		mu.Unlock()
+		return err
+	}
+	roots = append(roots, root)
+	err = commitRootsChange()
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	reloadLibrary()
+	return nil
+}
+
+// RemoveLibraryRoot 删除一个曲库根目录，其下的曲目会在重扫后移除
+func RemoveLibraryRoot(name string) error {
+	mu.Lock()
+	idx := findRoot(name)
+	if idx < 0 {
+		mu.Unlock()
+		return errors.New("root not found")
+	}
+	roots = append(roots[:idx], roots[idx+1:]...)
+	err := commitRootsChange()
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	reloadLibrary()
+	return nil
+}
+
+// SetLibraryRootEnabled 启用或停用一个曲库根目录
+func SetLibraryRootEnabled(name string, enabled bool) error {
+	mu.Lock()
+	idx := findRoot(name)
+	if idx < 0 {
+		mu.Unlock()
+		return errors.New("root not found")
+	}
+	roots[idx].Enabled = enabled
+	err := commitRootsChange()
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	reloadLibrary()
+	return nil
+}
+
+// setLibraryRootPath 修改根目录路径，不存在时新建该根目录
+func setLibraryRootPath(name, dir string) error {
+	dir, err := cleanRootDir(dir)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	if err := checkRootOverlap(dir, name); err != nil {
+		mu.Unlock()
+		return err
+	}
+	if idx := findRoot(name); idx >= 0 {
+		roots[idx].Path = dir
+	} else {
+		roots = append(roots, LibraryRoot{Name: name, Path: dir, Enabled: true})
+	}
+	err = commitRootsChange()
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	reloadLibrary()
+	return nil
+}
+
+// findRoot 返回根目录下标，调用方需持有 mu
+func findRoot(name string) int {
+	for i, r := range roots {
+		if r.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// commitRootsChange 使进行中的扫描结果失效并保存配置，调用方需持有 mu
+func commitRootsChange() error {
+	rootsGen++
+	return saveLibraryRoots()
+}
+
+// reloadLibrary 根目录变更后取消旧扫描，在后台重新扫描并重新监听。
+// 进行中的扫描被取消后会因 rescanPending 再扫一次，结果以新配置为准
+func reloadLibrary() {
+	CancelRescan()
+	StartRescan()
+	go func() {
+		if err := StartLibraryWatcher(); err != nil {
+			log.Printf("监听音乐目录失败: %v", err)
+		}
+	}()
+}
+
+// cleanRootDir 校验根目录路径：必须是已存在的目录，且不能是文件系统根目录，返回清理后的绝对路径
+func cleanRootDir(dir string) (string, error) {
+	if strings.TrimSpace(dir) == "" {
+		return "", errors.New("directory cannot be empty")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid directory: %s", dir)
+	}
+	info, err := os.Stat(abs)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("directory does not exist: %s", dir)
+	}
+	if filepath.Dir(abs) == abs {
+		return "", errors.New("the filesystem root cannot be a library root")
+	}
+	return abs, nil
+}
+
+// checkRootOverlap 检查目录是否与已有根目录重叠（相同、位于其中或包含它），
+// except 为正在修改路径的根目录名，不参与比较。调用方需持有 mu
+func checkRootOverlap(dir, except string) error {
+	for _, r := range roots {
+		if r.Name == except {
+			continue
+		}
+		existing, err := filepath.Abs(r.Path)
+		if err != nil {
+			continue
+		}
+		if pathWithin(dir, existing) || pathWithin(existing, dir) {
+			return fmt.Errorf("directory overlaps library root %q", r.Name)
+		}
+	}
+	return nil
+}
+
+// pathWithin 判断 p 是否等于 parent 或位于其中
+func pathWithin(p, parent string) bool {
+	rel, err := filepath.Rel(parent, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// rootTrackKey 生成计算曲目ID用的路径键：默认根目录只用相对路径，其他根目录加上根目录名
+func rootTrackKey(rootName, rel string) string {
+	if rootName == defaultRootName {
+		return rel
+	}
+	return rootName + "/" + rel
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TimedLyrics 是解析后的 LRC 歌词，时间均为毫秒，且已按 [offset:] 标签校正
+type TimedLyrics struct {
+	ID     int               `json:"id"`
+	Synced bool              `json:"synced"` // 没有任何时间戳时为 false，此时各行 time 为 0
+	Offset int               `json:"offset"` // [offset:] 标签的值，正数表示歌词提前
+	Meta   map[string]string `json:"meta"`   // ti、ar、al、by 等元信息
+	Lines  []LyricLine       `json:"lines"`
+}
+
+// LyricLine 是一句歌词，同一时间戳的第二行作为翻译合并进来
+type LyricLine struct {
+	Time        int         `json:"time"`
+	End         int         `json:"end,omitempty"` // 下一句的开始时间，最后一句为 0
+	Text        string      `json:"text"`
+	Translation string      `json:"translation,omitempty"`
+	Words       []LyricWord `json:"words,omitempty"` // 增强 LRC 的逐字时间
+}
+
+// LyricWord 是增强 LRC（<mm:ss.xx>）中的一个词或字
+type LyricWord struct {
+	Time int    `json:"time"`
+	End  int    `json:"end,omitempty"`
+	Text string `json:"text"`
+}
+
+var (
+	lrcTagRe  = regexp.MustCompile(`^\[([^\[\]]*)\]`)
+	lrcTimeRe = regexp.MustCompile(`^(\d{1,3}):(\d{1,2})(?:[.:](\d{1,3}))?$`)
+	lrcWordRe = regexp.MustCompile(`<(\d{1,3}:\d{1,2}(?:[.:]\d{1,3})?)>`)
+	lrcMetaRe = regexp.MustCompile(`^([a-zA-Z#]+):(.*)$`)
+)
+
+// GetTimedLyrics 读取曲目歌词（外部 .lrc 优先）并解析为结构化的时间轴
+func GetTimedLyrics(id int) (*TimedLyrics, error) {
+	raw, err := ReadLyricsRaw(id)
+	if err != nil {
+		return nil, err
+	}
+	l := parseLRC(raw)
+	l.ID = id
+	return l, nil
+}
+
+// parseLRC 解析 LRC 文本：支持一行多个时间戳、[offset:] 校正、
+// 同一时间戳的双语行合并为原文/翻译，以及 <mm:ss.xx> 逐字时间
+func parseLRC(raw string) *TimedLyrics {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	raw = strings.ReplaceAll(raw, "\r", "\n")
+
+	out := &TimedLyrics{Meta: map[string]string{}, Lines: []LyricLine{}}
+	type entry struct {
+		time  int
+		text  string
+		words []LyricWord
+	}
+	var entries []entry
+	var plain []string
+
+	for _, ln := range strings.Split(raw, "\n") {
+		ln = strings.TrimSpace(ln)
+		var times []int
+		for {
+			m := lrcTagRe.FindStringSubmatch(ln)
+			if m == nil {
+				break
+			}
+			if t, ok := parseLRCTime(m[1]); ok {
+				times = append(times, t)
+			} else if mm := lrcMetaRe.FindStringSubmatch(m[1]); mm != nil && len(times) == 0 {
+				key := strings.ToLower(mm[1])
+				val := strings.TrimSpace(mm[2])
+				if key == "offset" {
+					out.Offset, _ = strconv.Atoi(strings.TrimPrefix(val, "+"))
+				} else {
+					out.Meta[key] = val
+				}
+			} else {
+				break
+			}
+			ln = strings.TrimSpace(ln[len(m[0]):])
+		}
+		if len(times) == 0 {
+			if ln != "" && !lrcTagRe.MatchString(ln) {
+				plain = append(plain, ln)
+			}
+			continue
+		}
+		text, words := parseLRCWords(ln, times[0])
+		for i, t := range times {
+			e := entry{time: t, text: text}
+			if i == 0 {
+				e.words = words
+			} else if len(words) > 0 {
+				// 重复使用的行按时间差平移逐字时间
+				e.words = shiftWords(words, t-times[0])
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	if len(entries) == 0 {
+		for _, ln := range plain {
+			out.Lines = append(out.Lines, LyricLine{Text: ln})
+		}
+		return out
+	}
+	out.Synced = true
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time < entries[j].time })
+	for _, e := range entries {
+		n := len(out.Lines)
+		if n > 0 && out.Lines[n-1].Time == e.time {
+			prev := &out.Lines[n-1]
+			switch {
+			case e.text == "":
+			case prev.Text == "":
+				prev.Text, prev.Words = e.text, e.words
+			case prev.Translation == "":
+				prev.Translation = e.text
+			default:
+				prev.Translation += " / " + e.text
+			}
+			continue
+		}
+		out.Lines = append(out.Lines, LyricLine{Time: e.time, Text: e.text, Words: e.words})
+	}
+
+	// 应用 offset，并补全每句与每个字的结束时间
+	for i := range out.Lines {
+		line := &out.Lines[i]
+		line.Time = max(0, line.Time-out.Offset)
+		for j := range line.Words {
+			line.Words[j].Time = max(0, line.Words[j].Time-out.Offset)
+			if line.Words[j].End > 0 {
+				line.Words[j].End = max(0, line.Words[j].End-out.Offset)
+			}
+		}
+	}
+	for i := range out.Lines {
+		line := &out.Lines[i]
+		if i+1 < len(out.Lines) {
+			line.End = out.Lines[i+1].Time
+		}
+		if n := len(line.Words); n > 0 && line.Words[n-1].End == 0 {
+			line.Words[n-1].End = line.End
+		}
+	}
+	return out
+}
+
+// parseLRCTime 解析 mm:ss、mm:ss.xx 或 mm:ss.xxx 为毫秒
+func parseLRCTime(s string) (int, bool) {
+	m := lrcTimeRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	minutes, _ := strconv.Atoi(m[1])
+	sec, _ := strconv.Atoi(m[2])
+	ms := 0
+	if frac := m[3]; frac != "" {
+		ms, _ = strconv.Atoi(frac)
+		switch len(frac) {
+		case 1:
+			ms *= 100
+		case 2:
+			ms *= 10
+		}
+	}
+	return (minutes*60+sec)*1000 + ms, true
+}
+
+// parseLRCWords 拆出增强 LRC 的逐字时间，返回去掉时间标记的整句文本。
+// 第一个标记之前的文字以行时间为开始；行尾的标记只作为最后一个字的结束时间
+func parseLRCWords(s string, lineTime int) (string, []LyricWord) {
+	locs := lrcWordRe.FindAllStringSubmatchIndex(s, -1)
+	if len(locs) == 0 {
+		return s, nil
+	}
+	var words []LyricWord
+	var text strings.Builder
+	cur := lineTime
+	pos := 0
+	for _, loc := range locs {
+		if seg := s[pos:loc[0]]; seg != "" {
+			words = append(words, LyricWord{Time: cur, Text: seg})
+			text.WriteString(seg)
+		}
+		t, _ := parseLRCTime(s[loc[2]:loc[3]])
+		if n := len(words); n > 0 && words[n-1].End == 0 {
+			words[n-1].End = t
+		}
+		cur = t
+		pos = loc[1]
+	}
+	if seg := s[pos:]; seg != "" {
+		words = append(words, LyricWord{Time: cur, Text: seg})
+		text.WriteString(seg)
+	}
+	return strings.TrimSpace(text.String()), words
+}
+
+func shiftWords(words []LyricWord, delta int) []LyricWord {
+	out := make([]LyricWord, len(words))
+	for i, w := range words {
+		out[i] = LyricWord{Time: w.Time + delta, Text: w.Text}
+		if w.End > 0 {
+			out[i].End = w.End + delta
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLRC(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		synced bool
+		offset int
+		lines  []LyricLine
+	}{
+		{
+			name:   "plain text",
+			raw:    "first line\r\nsecond line\n",
+			synced: false,
+			lines:  []LyricLine{{Text: "first line"}, {Text: "second line"}},
+		},
+		{
+			name:   "timestamp precision and end times",
+			raw:    "[ti:Song]\n[00:01.5]a\n[00:02.25]b\n[01:02.345]c",
+			synced: true,
+			lines: []LyricLine{
+				{Time: 1500, End: 2250, Text: "a"},
+				{Time: 2250, End: 62345, Text: "b"},
+				{Time: 62345, Text: "c"},
+			},
+		},
+		{
+			name:   "positive offset shifts lines earlier and clamps at zero",
+			raw:    "[offset:+500]\n[00:00.20]intro\n[00:02.00]a\n[00:03.00]b",
+			synced: true,
+			offset: 500,
+			lines: []LyricLine{
+				{Time: 0, End: 1500, Text: "intro"},
+				{Time: 1500, End: 2500, Text: "a"},
+				{Time: 2500, Text: "b"},
+			},
+		},
+		{
+			name:   "negative offset shifts lines later",
+			raw:    "[offset:-250]\n[00:01.00]a",
+			synced: true,
+			offset: -250,
+			lines:  []LyricLine{{Time: 1250, Text: "a"}},
+		},
+		{
+			name:   "same timestamp merges translation",
+			raw:    "[00:01.00]Hello\n[00:01.00]你好\n[00:03.00]World\n[00:03.00]世界\n[00:03.00]Monde",
+			synced: true,
+			lines: []LyricLine{
+				{Time: 1000, End: 3000, Text: "Hello", Translation: "你好"},
+				{Time: 3000, Text: "World", Translation: "世界 / Monde"},
+			},
+		},
+		{
+			name:   "empty line at same timestamp is ignored",
+			raw:    "[00:01.00]\n[00:01.00]Hello",
+			synced: true,
+			lines:  []LyricLine{{Time: 1000, Text: "Hello"}},
+		},
+		{
+			name:   "multiple timestamps repeat a line",
+			raw:    "[00:05.00][00:01.00]chorus\n[00:03.00]verse",
+			synced: true,
+			lines: []LyricLine{
+				{Time: 1000, End: 3000, Text: "chorus"},
+				{Time: 3000, End: 5000, Text: "verse"},
+				{Time: 5000, Text: "chorus"},
+			},
+		},
+		{
+			name:   "word timing",
+			raw:    "[00:01.00]<00:01.00>He<00:01.50>llo<00:02.00>\n[00:03.00]next",
+			synced: true,
+			lines: []LyricLine{
+				{Time: 1000, End: 3000, Text: "Hello", Words: []LyricWord{
+					{Time: 1000, End: 1500, Text: "He"},
+					{Time: 1500, End: 2000, Text: "llo"},
+				}},
+				{Time: 3000, Text: "next"},
+			},
+		},
+		{
+			name:   "word timing without trailing mark ends with the line",
+			raw:    "[00:01.00]A <00:01.40>B\n[00:02.00]C",
+			synced: true,
+			lines: []LyricLine{
+				{Time: 1000, End: 2000, Text: "A B", Words: []LyricWord{
+					{Time: 1000, End: 1400, Text: "A "},
+					{Time: 1400, End: 2000, Text: "B"},
+				}},
+				{Time: 2000, Text: "C"},
+			},
+		},
+		{
+			name:   "word timing follows offset and repeated timestamps",
+			raw:    "[offset:100]\n[00:01.00][00:11.00]<00:01.00>x<00:01.50>",
+			synced: true,
+			offset: 100,
+			lines: []LyricLine{
+				{Time: 900, End: 10900, Text: "x", Words: []LyricWord{{Time: 900, End: 1400, Text: "x"}}},
+				{Time: 10900, Text: "x", Words: []LyricWord{{Time: 10900, End: 11400, Text: "x"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLRC(tt.raw)
+			if got.Synced != tt.synced {
+				t.Errorf("Synced = %v, want %v", got.Synced, tt.synced)
+			}
+			if got.Offset != tt.offset {
+				t.Errorf("Offset = %d, want %d", got.Offset, tt.offset)
+			}
+			if !reflect.DeepEqual(got.Lines, tt.lines) {
+				t.Errorf("Lines =\n%+v\nwant\n%+v", got.Lines, tt.lines)
+			}
+		})
+	}
+}
+
+func TestParseLRCMeta(t *testing.T) {
+	got := parseLRC("[ti:Title]\n[AR: Artist ]\n[al:Album]\n[00:01.00]a")
+	want := map[string]string{"ti": "Title", "ar": "Artist", "al": "Album"}
+	if !reflect.DeepEqual(got.Meta, want) {
+		t.Errorf("Meta = %v, want %v", got.Meta, want)
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"MusicPlayerWeb/db"
@@ -21,17 +23,76 @@ import (
 )
 
 type Track struct {
-	ID        int    `json:"id"`
-	Path      string `json:"-"`
-	Title     string `json:"title"`
-	Artist    string `json:"artist"`
-	Album     string `json:"album"`
-	HasCover  bool   `json:"hasCover"`
-	HasLyrics bool   `json:"hasLyrics"`
+	ID     int    `json:"id"`
+	Path   string `json:"-"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"` // 完整署名，用于展示
+	Album  string `json:"album"`
+	// Artists 是按分隔符拆分后的独立歌手列表
+	Artists []string `json:"artists"`
+	// 扩展标签信息，缺失时为零值
+	AlbumArtist string `json:"albumArtist"`
+	TrackNumber int    `json:"trackNumber"`
+	TrackTotal  int    `json:"trackTotal"`
+	DiscNumber  int    `json:"discNumber"`
+	DiscTotal   int    `json:"discTotal"`
+	Year        int    `json:"year"`
+	Genre       string `json:"genre"`
+	Composer    string `json:"composer"`
+	Root        string `json:"root"`
+	Format      string `json:"format"`
+	HasCover    bool   `json:"hasCover"`
+	HasLyrics   bool   `json:"hasLyrics"`
+	AddedAt     int64  `json:"addedAt"` // 加入曲库的时间（Unix 秒），文件内容变化后保持不变
+	// 音频技术参数，扫描时从流头部解析
+	Duration    float64 `json:"duration"`   // 秒
+	SampleRate  int     `json:"sampleRate"` // Hz
+	BitDepth    int     `json:"bitDepth"`   // 有损格式为 0
+	Bitrate     int     `json:"bitrate"`    // kbps
+	Channels    int     `json:"channels"`
+	Codec       string  `json:"codec"`
+	LyricsText  string  `json:"-"` // 歌词纯文本，供搜索使用
+	LyricsFile  string  `json:"-"` // 外部 .lrc 歌词文件
+	CoverFile   string  `json:"-"` // 外部封面图片
+	CoverHash   string  `json:"-"` // 当前生效封面的内容哈希，用作缩略图缓存键
+	ContentHash string  `json:"-"` // 整个音频文件的内容摘要，用作播放响应的 ETag
+	Sidecars    string  `json:"-"` // 外部文件签名，变化时重新读取曲目
+	RelPath     string  `json:"-"`
+	ModTime     int64   `json:"-"`
+	Size        int64   `json:"-"`
+}
+
+// audioFormat 描述一种可索引的音频格式：对外展示的格式名与播放时使用的 MIME 类型
+type audioFormat struct {
+	Name string
+	MIME string
+}
+
+// audioFormats 按扩展名登记支持扫描的音频格式（tag 库可读取标签的格式，以及仅按文件名索引的 WAV）
+var audioFormats = map[string]audioFormat{
+	".flac": {Name: "flac", MIME: "audio/flac"},
+	".mp3":  {Name: "mp3", MIME: "audio/mpeg"},
+	".m4a":  {Name: "m4a", MIME: "audio/mp4"},
+	".m4b":  {Name: "m4a", MIME: "audio/mp4"},
+	".mp4":  {Name: "m4a", MIME: "audio/mp4"},
+	".aac":  {Name: "aac", MIME: "audio/aac"},
+	".alac": {Name: "alac", MIME: "audio/mp4"},
+	".ogg":  {Name: "ogg", MIME: "audio/ogg"},
+	".oga":  {Name: "ogg", MIME: "audio/ogg"},
+	".opus": {Name: "opus", MIME: "audio/ogg; codecs=opus"},
+	".dsf":  {Name: "dsf", MIME: "audio/x-dsf"},
+	".wav":  {Name: "wav", MIME: "audio/wav"},
+}
+
+// lookupAudioFormat 根据文件扩展名返回音频格式，不支持的格式返回 false
+func lookupAudioFormat(name string) (audioFormat, bool) {
+	f, ok := audioFormats[strings.ToLower(filepath.Ext(name))]
+	return f, ok
 }
 
 // Album 表示按专辑聚合后的信息
 type Album struct {
+	ID           int    `json:"id"`
 	Name         string `json:"name"`
 	Artist       string `json:"artist"`
 	Count        int    `json:"count"`
@@ -40,90 +101,57 @@ type Album struct {
 }
 
 var (
-	tracks   []Track
-	once     sync.Once
-	mu       sync.RWMutex
-	musicDir = `C:\Users\28890\Desktop\music`
+	tracks []Track
+	mu     sync.RWMutex
+	// cacheReady 首次扫描成功后置位；失败或被取消时保持未完成，之后的调用会重新扫描
+	cacheReady atomic.Bool
+	initMu     sync.Mutex
+	// legacyIDs 旧版顺序ID -> 稳定ID，用于兼容旧链接和迁移收藏/评论
+	legacyIDs map[int]int
 )
 
-// InitMusicCache scans the directory and caches flac tracks.
+// InitMusicCache 确保曲库可用：首次扫描完成前先使用磁盘上的索引，两者都没有时同步执行首次扫描
 func InitMusicCache() error {
-	var err error
-	once.Do(func() {
-		err = scanDir()
-	})
-	return err
+	if cacheReady.Load() || loadCachedIndex() {
+		return nil
+	}
+	return initialScan()
 }
 
-func scanDir() error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	tracks = nil
-	id := 0
-	walkErr := filepath.Walk(musicDir, func(p string, info os.FileInfo, e error) error {
-		if e != nil {
-			return nil // skip error entry
-		}
-		if info.IsDir() {
-			return nil
-		}
-		ext := strings.ToLower(filepath.Ext(info.Name()))
-		if ext != ".flac" {
-			return nil
-		}
-		f, err := os.Open(p)
-		if err != nil {
-			return nil
-		}
-		defer f.Close()
-
-		m, _ := tag.ReadFrom(f)
+// LoadMusicCache 在启动时调用：先载入磁盘索引供各接口使用，再执行首次扫描
+func LoadMusicCache() error {
+	loadCachedIndex()
+	return initialScan()
+}
 
-		title := info.Name()
-		artist := ""
-		album := ""
-		hasCover := false
-		hasLyrics := false
+// initialScan 执行首次扫描，只有成功后才标记完成
+func initialScan() error {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if cacheReady.Load() {
+		return nil
+	}
+	if err := scanDir(context.Background()); err != nil {
+		return err
+	}
+	cacheReady.Store(true)
+	return nil
+}
 
-		if m != nil {
-			if t := m.Title(); t != "" {
-				title = t
-			}
-			artist = m.Artist()
-			album = m.Album()
-			// Cover
-			if pic := m.Picture(); pic != nil && len(pic.Data) > 0 {
-				hasCover = true
-			}
-			// Lyrics
-			if l := m.Lyrics(); l != "" {
-				hasLyrics = true
-			} else if rawMeta, ok := m.(tag.Metadata); ok {
-				for k, v := range rawMeta.Raw() {
-					if k == "LYRICS" || k == "UNSYNCEDLYRICS" {
-						if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-							hasLyrics = true
-							break
-						}
-					}
-				}
+// loadCachedIndex 内存中还没有曲库时载入磁盘索引，返回曲库是否可用
+func loadCachedIndex() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if tracks == nil {
+		if list := loadLibraryIndex(); len(list) > 0 {
+			tracks = list
+			library = buildSearchIndex(list)
+			if lastScanReport == nil {
+				lastScanReport = loadScanReport()
 			}
 		}
-
-		tracks = append(tracks, Track{
-			ID:        id,
-			Path:      p,
-			Title:     title,
-			Artist:    artist,
-			Album:     album,
-			HasCover:  hasCover,
-			HasLyrics: hasLyrics,
-		})
-		id++
-		return nil
-	})
-	return walkErr
+	}
+	return tracks != nil
 }
 
 func ListTracks() ([]Track, error) {
@@ -137,53 +165,63 @@ func ListTracks() ([]Track, error) {
 	return out, nil
 }
 
-// GetMusicDir 获取当前音乐目录
+// GetMusicDir 获取当前音乐目录（默认曲库根目录）
 func GetMusicDir() string {
-	return musicDir
+	mu.RLock()
+	defer mu.RUnlock()
+	if idx := findRoot(defaultRootName); idx >= 0 {
+		return roots[idx].Path
+	}
+	return ""
 }
 
-// UpdateMusicDir 更新音乐目录
+// UpdateMusicDir 更新音乐目录（默认曲库根目录）
 func UpdateMusicDir(newDir string) error {
-	// 验证目录是否存在
-	if _, err := os.Stat(newDir); os.IsNotExist(err) {
-		return fmt.Errorf("directory does not exist: %s", newDir)
-	}
-
-	// 更新全局变量
-	musicDir = newDir
+	return setLibraryRootPath(defaultRootName, newDir)
+}
 
-	// 重新扫描音乐文件
-	return RescanMusic()
+// ListTracksInRoot 获取某个曲库根目录下的曲目，root 为空时返回全部
+func ListTracksInRoot(root string) ([]Track, error) {
+	list, err := ListTracks()
+	if err != nil || root == "" {
+		return list, err
+	}
+	out := make([]Track, 0, len(list))
+	for _, t := range list {
+		if t.Root == root {
+			out = append(out, t)
+		}
+	}
+	return out, nil
 }
 
-// RescanMusic 重新扫描音乐文件
+// RescanMusic 重新扫描音乐文件（仅重新读取新增或变更文件的标签）
 func RescanMusic() error {
-	// 清空现有数据
-	mu.Lock()
-	tracks = nil
-	mu.Unlock()
-
-	// 重新扫描
-	_, err := ListTracks()
-	return err
+	// 首次扫描尚未成功时由它完成，避免连续扫描两次
+	if !cacheReady.Load() {
+		return initialScan()
+	}
+	return scanDir(context.Background())
 }
 
-// ListAlbums 聚合专辑（专辑名+艺人 作为键），首曲与封面取第一首
+// ListAlbums 聚合专辑（专辑名+专辑艺人 作为键），首曲与封面取第一首。
+// 结果按专辑名排序，ID由归一化后的键哈希得到，重启和重扫后保持不变
 func ListAlbums() ([]Album, error) {
 	if err := InitMusicCache(); err != nil {
 		return nil, err
 	}
 	mu.RLock()
 	defer mu.RUnlock()
-	type key struct{ album, artist string }
-	m := map[key]*Album{}
+	m := map[string]*Album{}
 	for _, t := range tracks {
-		k := key{album: strings.TrimSpace(t.Album), artist: strings.TrimSpace(t.Artist)}
-		if k.album == "" {
+		name := strings.TrimSpace(t.Album)
+		if name == "" {
 			continue
 		}
+		artist := albumArtistOf(t)
+		k := albumKey(name, artist)
 		if _, ok := m[k]; !ok {
-			m[k] = &Album{Name: k.album, Artist: k.artist, Count: 0, CoverTrackID: t.ID, FirstTrackID: t.ID}
+			m[k] = &Album{Name: name, Artist: artist, Count: 0, CoverTrackID: t.ID, FirstTrackID: t.ID}
 		}
 		al := m[k]
 		al.Count++
@@ -192,9 +230,17 @@ func ListAlbums() ([]Album, error) {
 			al.CoverTrackID = t.ID
 		}
 	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	used := map[int]bool{}
 	out := make([]Album, 0, len(m))
-	for _, v := range m {
-		out = append(out, *v)
+	for _, k := range keys {
+		al := m[k]
+		al.ID = stableID(k, used)
+		out = append(out, *al)
 	}
 	return out, nil
 }
@@ -208,36 +254,34 @@ func ListArtists() ([]map[string]interface{}, error) {
 	defer mu.RUnlock()
 
 	artistsMap := make(map[string]*map[string]interface{})
+	// 每位歌手参与的专辑，用于统计专辑数
+	artistAlbums := make(map[string]map[string]bool)
 
 	for _, t := range tracks {
-		artist := strings.TrimSpace(t.Artist)
-		if artist == "" {
-			continue
-		}
-
-		if _, exists := artistsMap[artist]; !exists {
-			// 创建新的歌手记录
-			artistsMap[artist] = &map[string]interface{}{
-				"name":         artist,
-				"songCount":    0,
-				"coverTrackID": t.ID,
-				"firstTrack":   t,
+		for _, artist := range t.Artists {
+			addArtistTrack(artistsMap, artist, t)
+			if name := strings.TrimSpace(t.Album); name != "" {
+				key := normalizeName(artist)
+				if artistAlbums[key] == nil {
+					artistAlbums[key] = map[string]bool{}
+				}
+				artistAlbums[key][albumKey(name, albumArtistOf(t))] = true
 			}
 		}
+	}
 
-		artistData := artistsMap[artist]
-		(*artistData)["songCount"] = (*artistData)["songCount"].(int) + 1
-
-		// 如果当前歌曲有封面，更新歌手封面
-		if t.HasCover {
-			(*artistData)["coverTrackID"] = t.ID
-		}
+	// 按归一化名称排序后转换为数组，保证ID冲突时的处理顺序固定
+	keys := make([]string, 0, len(artistsMap))
+	for k := range artistsMap {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	used := map[int]bool{}
 
-	// 转换为数组并添加分类信息
+	// 添加分类信息
 	var artists []map[string]interface{}
-	for _, artistData := range artistsMap {
-		artist := *artistData
+	for _, k := range keys {
+		artist := *artistsMap[k]
 		// 根据歌手名称判断分类
 		category := "other"
 		artistName := artist["name"].(string)
@@ -254,13 +298,36 @@ func ListArtists() ([]map[string]interface{}, error) {
 		}
 
 		artist["category"] = category
-		artist["id"] = len(artists) + 1
+		artist["albumCount"] = len(artistAlbums[k])
+		artist["id"] = stableID(artistKey(artistName), used)
 		artists = append(artists, artist)
 	}
 
 	return artists, nil
 }
 
+// addArtistTrack 把曲目计入某位歌手，歌手名归一化后合并，展示名取首次出现的写法
+func addArtistTrack(artistsMap map[string]*map[string]interface{}, artist string, t Track) {
+	key := normalizeName(artist)
+	if _, exists := artistsMap[key]; !exists {
+		// 创建新的歌手记录
+		artistsMap[key] = &map[string]interface{}{
+			"name":         artist,
+			"songCount":    0,
+			"coverTrackID": t.ID,
+			"firstTrack":   t,
+		}
+	}
+
+	artistData := artistsMap[key]
+	(*artistData)["songCount"] = (*artistData)["songCount"].(int) + 1
+
+	// 如果当前歌曲有封面，更新歌手封面
+	if t.HasCover {
+		(*artistData)["coverTrackID"] = t.ID
+	}
+}
+
 // 辅助函数：判断字符串是否包含中文
 func containsChinese(s string) bool {
 	for _, r := range s {
@@ -293,24 +360,45 @@ func containsKorean(s string) bool {
 	return false
 }
 
-// ListAlbumTracks 获取某专辑下所有曲目（按标题排序）
+// ListAlbumTracks 获取某专辑下所有曲目（按碟号、音轨号排序，缺失时按标题）
 func ListAlbumTracks(album string, artist string) ([]Track, error) {
 	if err := InitMusicCache(); err != nil {
 		return nil, err
 	}
 	mu.RLock()
 	defer mu.RUnlock()
+	album, artist = normalizeName(album), normalizeName(artist)
 	var out []Track
 	for _, t := range tracks {
-		if strings.TrimSpace(t.Album) == strings.TrimSpace(album) && (artist == "" || strings.TrimSpace(t.Artist) == strings.TrimSpace(artist)) {
+		if normalizeName(t.Album) != album {
+			continue
+		}
+		// 艺人既可以是专辑艺人，也兼容按曲目署名查询的旧链接
+		if artist == "" || normalizeName(albumArtistOf(t)) == artist || normalizeName(t.Artist) == artist {
 			out = append(out, t)
 		}
 	}
-	// 简单按标题排序
-	sort.SliceStable(out, func(i, j int) bool { return strings.ToLower(out[i].Title) < strings.ToLower(out[j].Title) })
+	sortAlbumOrder(out)
 	return out, nil
 }
 
+// sortAlbumOrder 按专辑内的真实顺序排序：碟号、音轨号，缺少音轨号的排在后面并按标题排序
+func sortAlbumOrder(list []Track) {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		if (a.TrackNumber == 0) != (b.TrackNumber == 0) {
+			return a.TrackNumber != 0
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	})
+}
+
 // GetArtistByID 根据歌手ID获取歌手信息
 func GetArtistByID(id int) (map[string]interface{}, error) {
 	artists, err := ListArtists()
@@ -327,7 +415,7 @@ func GetArtistByID(id int) (map[string]interface{}, error) {
 	return nil, errors.New("artist not found")
 }
 
-// ListArtistTracks 获取某歌手下所有曲目（按专辑和标题排序）
+// ListArtistTracks 获取某歌手下所有曲目（按专辑和专辑内顺序排序）
 func ListArtistTracks(artistName string) ([]Track, error) {
 	if err := InitMusicCache(); err != nil {
 		return nil, err
@@ -337,17 +425,15 @@ func ListArtistTracks(artistName string) ([]Track, error) {
 
 	var out []Track
 	for _, t := range tracks {
-		if strings.TrimSpace(t.Artist) == strings.TrimSpace(artistName) {
+		if hasArtist(t, artistName) {
 			out = append(out, t)
 		}
 	}
 
-	// 按专辑和标题排序
+	// 按专辑排序，专辑内按碟号与音轨号
+	sortAlbumOrder(out)
 	sort.SliceStable(out, func(i, j int) bool {
-		if out[i].Album != out[j].Album {
-			return strings.ToLower(out[i].Album) < strings.ToLower(out[j].Album)
-		}
-		return strings.ToLower(out[i].Title) < strings.ToLower(out[j].Title)
+		return strings.ToLower(out[i].Album) < strings.ToLower(out[j].Album)
 	})
 
 	return out, nil
@@ -364,6 +450,14 @@ func getTrackByID(id int) (Track, error) {
 			return t, nil
 		}
 	}
+	// 兼容旧版顺序ID（如早期分享的 /song?id= 链接）
+	if newID, ok := legacyIDs[id]; ok {
+		for _, t := range tracks {
+			if t.ID == newID {
+				return t, nil
+			}
+		}
+	}
 	return Track{}, errors.New("not found")
 }
 
@@ -372,7 +466,7 @@ func GetTrack(id int) (Track, error) {
 	return getTrackByID(id)
 }
 
-func ReadAudio(id int) (io.ReadCloser, string, error) {
+func ReadAudio(id int) (*os.File, string, error) {
 	t, err := getTrackByID(id)
 	if err != nil {
 		return nil, "", err
@@ -381,10 +475,14 @@ func ReadAudio(id int) (io.ReadCloser, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
-	// FLAC mime
-	return f, "audio/flac", nil
+	ctype := "application/octet-stream"
+	if format, ok := lookupAudioFormat(t.Path); ok {
+		ctype = format.MIME
+	}
+	return f, ctype, nil
 }
 
+// ReadCover 返回曲目封面，优先使用内嵌封面，没有时使用外部封面图片
 func ReadCover(id int) ([]byte, string, error) {
 	t, err := getTrackByID(id)
 	if err != nil {
@@ -396,10 +494,10 @@ func ReadCover(id int) ([]byte, string, error) {
 	}
 	defer f.Close()
 	m, err := tag.ReadFrom(f)
-	if err != nil {
-		return nil, "", err
-	}
-	if m == nil || m.Picture() == nil || len(m.Picture().Data) == 0 {
+	if err != nil || m == nil || m.Picture() == nil || len(m.Picture().Data) == 0 {
+		if t.CoverFile != "" {
+			return readCoverFile(t.CoverFile)
+		}
 		return nil, "", errors.New("no cover")
 	}
 	p := m.Picture()
@@ -413,42 +511,27 @@ func ReadCover(id int) ([]byte, string, error) {
 	return buf.Bytes(), ct, nil
 }
 
+// ReadLyrics 返回去掉时间戳的纯文本歌词
 func ReadLyrics(id int) (string, error) {
-	t, err := getTrackByID(id)
-	if err != nil {
-		return "", err
-	}
-	f, err := os.Open(t.Path)
+	l, err := ReadLyricsRaw(id)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
-	m, err := tag.ReadFrom(f)
-	if err != nil || m == nil {
-		return "", errors.New("no lyrics")
-	}
-	if l := m.Lyrics(); l != "" {
-		return cleanLyrics(l), nil
-	}
-	// try raw fields
-	if raw, ok := m.(tag.Metadata); ok {
-		for k, v := range raw.Raw() {
-			if k == "LYRICS" || k == "UNSYNCEDLYRICS" {
-				if s, ok := v.(string); ok && s != "" {
-					return cleanLyrics(s), nil
-				}
-			}
-		}
-	}
-	return "", errors.New("no lyrics")
+	return cleanLyrics(l), nil
 }
 
-// ReadLyricsRaw 返回带时间戳的原始歌词（用于前端同步显示）
+// ReadLyricsRaw 返回带时间戳的原始歌词（用于前端同步显示），
+// 优先使用同名的外部 .lrc 文件，没有时读取标签内嵌歌词
 func ReadLyricsRaw(id int) (string, error) {
 	t, err := getTrackByID(id)
 	if err != nil {
 		return "", err
 	}
+	if t.LyricsFile != "" {
+		if l, err := readLyricsFile(t.LyricsFile); err == nil && strings.TrimSpace(l) != "" {
+			return l, nil
+		}
+	}
 	f, err := os.Open(t.Path)
 	if err != nil {
 		return "", err
@@ -647,7 +730,7 @@ func GetCurrentUserID(r *http.Request) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	
+
 	// 现在直接返回UUID字符串，不再转换为整数
 	return cookie.Value, nil
 }
@@ -710,28 +793,36 @@ func parseID(id interface{}) int64 {
 	}
 }
 
-// GetAlbumByID 根据专辑ID获取专辑信息
-func GetAlbumByID(id int) (map[string]interface{}, error) {
+// findAlbumByID 根据稳定专辑ID查找专辑
+func findAlbumByID(id int) (Album, error) {
 	albums, err := ListAlbums()
 	if err != nil {
-		return nil, err
+		return Album{}, err
+	}
+	for _, album := range albums {
+		if album.ID == id {
+			return album, nil
+		}
 	}
+	return Album{}, errors.New("album not found")
+}
 
-	// 检查ID是否在有效范围内
-	if id < 1 || id > len(albums) {
-		return nil, errors.New("album not found")
+// GetAlbumByID 根据专辑ID获取专辑信息
+func GetAlbumByID(id int) (map[string]interface{}, error) {
+	album, err := findAlbumByID(id)
+	if err != nil {
+		return nil, err
 	}
 
-	album := albums[id-1]
 	albumData := make(map[string]interface{})
-	albumData["id"] = id
+	albumData["id"] = album.ID
 	albumData["name"] = album.Name
 	albumData["artist"] = album.Artist
 	albumData["songCount"] = album.Count
 
 	// 生成封面URL
 	if album.CoverTrackID >= 0 {
-		albumData["cover"] = fmt.Sprintf("/api/cover?id=%d", album.CoverTrackID)
+		albumData["cover"] = fmt.Sprintf("/api/cover?id=%d&size=512", album.CoverTrackID)
 	} else {
 		albumData["cover"] = "https://picsum.photos/id/1015/300/300"
 	}
@@ -741,18 +832,99 @@ func GetAlbumByID(id int) (map[string]interface{}, error) {
 
 // ListAlbumTracksByID 根据专辑ID获取专辑歌曲列表
 func ListAlbumTracksByID(id int) ([]Track, error) {
+	album, err := findAlbumByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return ListAlbumTracks(album.Name, album.Artist)
+}
+
+// GetAlbum 导出：根据ID获取专辑
+func GetAlbum(id int) (Album, error) {
+	return findAlbumByID(id)
+}
+
+// ListArtistAlbums 获取包含某歌手曲目的所有专辑（包括只以参与艺人身份出现的专辑）
+func ListArtistAlbums(artistName string) ([]Album, error) {
 	albums, err := ListAlbums()
 	if err != nil {
 		return nil, err
 	}
+	mu.RLock()
+	keys := map[string]bool{}
+	for _, t := range tracks {
+		if name := strings.TrimSpace(t.Album); name != "" && hasArtist(t, artistName) {
+			keys[albumKey(name, albumArtistOf(t))] = true
+		}
+	}
+	mu.RUnlock()
 
-	// 检查ID是否在有效范围内
-	if id < 1 || id > len(albums) {
-		return nil, errors.New("album not found")
+	var out []Album
+	for _, al := range albums {
+		if keys[albumKey(al.Name, al.Artist)] {
+			out = append(out, al)
+		}
 	}
+	return out, nil
+}
 
-	album := albums[id-1]
-	return ListAlbumTracks(album.Name, album.Artist)
+// TrackRef 是曲目所属专辑与第一位歌手的ID，没有专辑或歌手时为 0
+type TrackRef struct {
+	AlbumID  int
+	ArtistID int
+}
+
+// ListTrackRefs 返回所有曲目到专辑ID、歌手ID的映射，供需要同时给出关联ID的接口一次性查询
+func ListTrackRefs() (map[int]TrackRef, error) {
+	albums, err := ListAlbums()
+	if err != nil {
+		return nil, err
+	}
+	artists, err := ListArtists()
+	if err != nil {
+		return nil, err
+	}
+	albumIDs := make(map[string]int, len(albums))
+	for _, al := range albums {
+		albumIDs[albumKey(al.Name, al.Artist)] = al.ID
+	}
+	artistIDs := make(map[string]int, len(artists))
+	for _, a := range artists {
+		name, _ := a["name"].(string)
+		id, _ := a["id"].(int)
+		artistIDs[normalizeName(name)] = id
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make(map[int]TrackRef, len(tracks))
+	for _, t := range tracks {
+		var ref TrackRef
+		if name := strings.TrimSpace(t.Album); name != "" {
+			ref.AlbumID = albumIDs[albumKey(name, albumArtistOf(t))]
+		}
+		if len(t.Artists) > 0 {
+			ref.ArtistID = artistIDs[normalizeName(t.Artists[0])]
+		}
+		out[t.ID] = ref
+	}
+	return out, nil
+}
+
+// FindTrack 按歌手和标题查找曲目（忽略大小写与多余空白），歌手为空时只按标题匹配
+func FindTrack(artist, title string) (Track, error) {
+	if err := InitMusicCache(); err != nil {
+		return Track{}, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	title = normalizeName(title)
+	for _, t := range tracks {
+		if normalizeName(t.Title) == title && (artist == "" || hasArtist(t, artist)) {
+			return t, nil
+		}
+	}
+	return Track{}, errors.New("not found")
 }
 
 // UserFavorite 用户收藏结构体
@@ -0,0 +1,515 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 歌单条目的来源类型，与分享链接的类型取值一致
+const (
+	PlaylistTrack  = "track"  // 曲库曲目，ID 为曲目ID
+	PlaylistUpload = "upload" // 歌单所有者上传的音乐文件，ID 为 MusicFile.ID
+)
+
+const (
+	// MaxPlaylistItems 单个歌单最多包含的条目数
+	MaxPlaylistItems = 5000
+	// MaxPlaylistCoverSize 自定义封面图片的最大字节数
+	MaxPlaylistCoverSize = 5 << 20
+)
+
+var (
+	ErrPlaylistNotFound = errors.New("playlist not found")
+	ErrPlaylistConflict = errors.New("playlist was modified concurrently, please retry")
+)
+
+// PlaylistItem 是歌单中的一首歌；同一首歌可以出现多次，按位置区分
+type PlaylistItem struct {
+	Type    string    `json:"type"`
+	ID      string    `json:"id"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Playlist 是保存在数据库中的用户歌单。条目整体存为一个 JSON 数组，
+// 修改时以 version 作为更新条件，并发修改只有一个成功，其余重新读取后重试
+type Playlist struct {
+	ID          string         `json:"id"`
+	UserID      string         `json:"user_id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Public      bool           `json:"public"`
+	Items       []PlaylistItem `json:"items"`
+	HasCover    bool           `json:"has_cover"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+
+	version int
+}
+
+// PlaylistEntry 是解析后的歌单条目，附带展示与播放所需的信息。
+// 曲库中已删除的曲目或已删除的上传文件 Available 为 false
+type PlaylistEntry struct {
+	Position  int     `json:"position"`
+	Type      string  `json:"type"`
+	ID        string  `json:"id"`
+	Title     string  `json:"title"`
+	Artist    string  `json:"artist"`
+	Album     string  `json:"album"`
+	Duration  float64 `json:"duration"`
+	Cover     string  `json:"cover,omitempty"`
+	Src       string  `json:"src,omitempty"`
+	Available bool    `json:"available"`
+}
+
+func playlistFromRow(row map[string]interface{}) Playlist {
+	p := Playlist{
+		ID:          getStringFromMap(row, "id", ""),
+		UserID:      getStringFromMap(row, "user_id", ""),
+		Name:        getStringFromMap(row, "name", ""),
+		Description: getStringFromMap(row, "description", ""),
+		version:     getIntFromMapUpload(row, "version", 0),
+	}
+	p.Public, _ = row["is_public"].(bool)
+	if raw, err := json.Marshal(row["items"]); err == nil {
+		_ = json.Unmarshal(raw, &p.Items)
+	}
+	if p.Items == nil {
+		p.Items = []PlaylistItem{}
+	}
+	p.CreatedAt, _ = time.Parse(time.RFC3339, getStringFromMap(row, "created_at", ""))
+	p.UpdatedAt, _ = time.Parse(time.RFC3339, getStringFromMap(row, "updated_at", ""))
+	if _, err := os.Stat(playlistCoverPath(p.ID)); err == nil {
+		p.HasCover = true
+	}
+	return p
+}
+
+// validPlaylistName 去掉首尾空白并检查长度
+func validPlaylistName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("playlist name required")
+	}
+	if utf8.RuneCountInString(name) > 100 {
+		return "", errors.New("playlist name too long")
+	}
+	return name, nil
+}
+
+// CreatePlaylist 为用户创建一个空歌单
+func CreatePlaylist(userUUID, name, description string, public bool) (*Playlist, error) {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return nil, errors.New("user not authenticated")
+	}
+	name, err := validPlaylistName(name)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	rows, err := supabaseREST("POST", "playlists", map[string]interface{}{
+		"user_id":     userUUID,
+		"name":        name,
+		"description": strings.TrimSpace(description),
+		"is_public":   public,
+		"items":       []PlaylistItem{},
+		"version":     0,
+		"created_at":  now,
+		"updated_at":  now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("failed to create playlist")
+	}
+	p := playlistFromRow(rows[0])
+	return &p, nil
+}
+
+// ListPlaylists 返回用户自己的全部歌单，按更新时间倒序
+func ListPlaylists(userUUID string) ([]Playlist, error) {
+	return queryPlaylists(userUUID, false)
+}
+
+// ListPublicPlaylists 返回某个用户公开的歌单
+func ListPublicPlaylists(ownerUUID string) ([]Playlist, error) {
+	return queryPlaylists(ownerUUID, true)
+}
+
+func queryPlaylists(userUUID string, publicOnly bool) ([]Playlist, error) {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return nil, errors.New("invalid user id")
+	}
+	path := "playlists?user_id=eq." + userUUID + "&order=updated_at.desc"
+	if publicOnly {
+		path += "&is_public=eq.true"
+	}
+	rows, err := supabaseREST("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Playlist, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, playlistFromRow(row))
+	}
+	return out, nil
+}
+
+// GetPlaylist 读取歌单：所有者总能读取，其他人（包括未登录用户，viewerUUID 为空）只能读取公开歌单
+func GetPlaylist(id, viewerUUID string) (*Playlist, error) {
+	p, err := fetchPlaylist(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.UserID != viewerUUID && !p.Public {
+		return nil, ErrPlaylistNotFound
+	}
+	return p, nil
+}
+
+func fetchPlaylist(id string) (*Playlist, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, ErrPlaylistNotFound
+	}
+	rows, err := supabaseREST("GET", "playlists?id=eq."+id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrPlaylistNotFound
+	}
+	p := playlistFromRow(rows[0])
+	return &p, nil
+}
+
+// ownPlaylist 读取用户自己的歌单，别人的歌单一律视为不存在
+func ownPlaylist(id, userUUID string) (*Playlist, error) {
+	p, err := fetchPlaylist(id)
+	if err != nil {
+		return nil, err
+	}
+	if userUUID == "" || p.UserID != userUUID {
+		return nil, ErrPlaylistNotFound
+	}
+	return p, nil
+}
+
+// PlaylistUpdate 是歌单信息的修改项，nil 表示不修改
+type PlaylistUpdate struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Public      *bool   `json:"public"`
+}
+
+// UpdatePlaylist 修改歌单名称、简介或公开状态
+func UpdatePlaylist(id, userUUID string, u PlaylistUpdate) (*Playlist, error) {
+	if _, err := ownPlaylist(id, userUUID); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{"updated_at": time.Now().UTC().Format(time.RFC3339)}
+	if u.Name != nil {
+		name, err := validPlaylistName(*u.Name)
+		if err != nil {
+			return nil, err
+		}
+		fields["name"] = name
+	}
+	if u.Description != nil {
+		fields["description"] = strings.TrimSpace(*u.Description)
+	}
+	if u.Public != nil {
+		fields["is_public"] = *u.Public
+	}
+	rows, err := supabaseREST("PATCH", "playlists?id=eq."+id+"&user_id=eq."+userUUID, fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrPlaylistNotFound
+	}
+	p := playlistFromRow(rows[0])
+	return &p, nil
+}
+
+// DeletePlaylist 删除用户自己的歌单及其自定义封面
+func DeletePlaylist(id, userUUID string) error {
+	if !uploadIDPattern.MatchString(id) || !uploadIDPattern.MatchString(userUUID) {
+		return ErrPlaylistNotFound
+	}
+	rows, err := supabaseREST("DELETE", "playlists?id=eq."+id+"&user_id=eq."+userUUID, nil)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrPlaylistNotFound
+	}
+	os.Remove(playlistCoverPath(id))
+	return nil
+}
+
+// modifyPlaylistItems 以 version 为条件写回修改后的条目，冲突时重新读取并最多重试 3 次
+func modifyPlaylistItems(id, userUUID string, fn func(items []PlaylistItem) ([]PlaylistItem, error)) (*Playlist, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		p, err := ownPlaylist(id, userUUID)
+		if err != nil {
+			return nil, err
+		}
+		items, err := fn(append([]PlaylistItem(nil), p.Items...))
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > MaxPlaylistItems {
+			return nil, fmt.Errorf("a playlist can hold at most %d tracks", MaxPlaylistItems)
+		}
+		if items == nil {
+			items = []PlaylistItem{}
+		}
+		rows, err := supabaseREST("PATCH", fmt.Sprintf("playlists?id=eq.%s&version=eq.%d", id, p.version), map[string]interface{}{
+			"items":      items,
+			"version":    p.version + 1,
+			"updated_at": time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) > 0 {
+			updated := playlistFromRow(rows[0])
+			return &updated, nil
+		}
+	}
+	return nil, ErrPlaylistConflict
+}
+
+// AddPlaylistItems 在 position 处插入条目（position < 0 或超出范围时追加到末尾）。
+// 曲库曲目必须存在，上传文件必须属于歌单所有者
+func AddPlaylistItems(id, userUUID string, items []PlaylistItem, position int) (*Playlist, error) {
+	if len(items) == 0 {
+		return nil, errors.New("no tracks to add")
+	}
+	var uploads map[string]bool
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := range items {
+		it := &items[i]
+		switch it.Type {
+		case PlaylistTrack:
+			n, err := strconv.Atoi(it.ID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid track id %q", it.ID)
+			}
+			if _, err := getTrackByID(n); err != nil {
+				return nil, fmt.Errorf("track %s not found", it.ID)
+			}
+		case PlaylistUpload:
+			if uploads == nil {
+				files, err := GetUserMusicFiles(userUUID)
+				if err != nil {
+					return nil, err
+				}
+				uploads = map[string]bool{}
+				for _, f := range files {
+					uploads[f.ID] = true
+				}
+			}
+			if !uploads[it.ID] {
+				return nil, fmt.Errorf("uploaded file %s not found", it.ID)
+			}
+		default:
+			return nil, errors.New("type must be track or upload")
+		}
+		it.AddedAt = now
+	}
+
+	return modifyPlaylistItems(id, userUUID, func(cur []PlaylistItem) ([]PlaylistItem, error) {
+		if position < 0 || position > len(cur) {
+			position = len(cur)
+		}
+		out := make([]PlaylistItem, 0, len(cur)+len(items))
+		out = append(out, cur[:position]...)
+		out = append(out, items...)
+		return append(out, cur[position:]...), nil
+	})
+}
+
+// RemovePlaylistItems 删除指定位置（从 0 开始）的条目
+func RemovePlaylistItems(id, userUUID string, positions []int) (*Playlist, error) {
+	if len(positions) == 0 {
+		return nil, errors.New("no positions to remove")
+	}
+	return modifyPlaylistItems(id, userUUID, func(cur []PlaylistItem) ([]PlaylistItem, error) {
+		drop := map[int]bool{}
+		for _, pos := range positions {
+			if pos < 0 || pos >= len(cur) {
+				return nil, fmt.Errorf("position %d out of range", pos)
+			}
+			drop[pos] = true
+		}
+		out := make([]PlaylistItem, 0, len(cur))
+		for i, it := range cur {
+			if !drop[i] {
+				out = append(out, it)
+			}
+		}
+		return out, nil
+	})
+}
+
+// MovePlaylistItem 把 from 位置的条目移动到 to 位置
+func MovePlaylistItem(id, userUUID string, from, to int) (*Playlist, error) {
+	return modifyPlaylistItems(id, userUUID, func(cur []PlaylistItem) ([]PlaylistItem, error) {
+		if from < 0 || from >= len(cur) || to < 0 || to >= len(cur) {
+			return nil, errors.New("position out of range")
+		}
+		it := cur[from]
+		cur = append(cur[:from], cur[from+1:]...)
+		cur = append(cur[:to], append([]PlaylistItem{it}, cur[to:]...)...)
+		return cur, nil
+	})
+}
+
+// ReorderPlaylist 按 order 重新排列条目，order[i] 是新位置 i 上条目的原位置，必须是全部位置的一个排列
+func ReorderPlaylist(id, userUUID string, order []int) (*Playlist, error) {
+	return modifyPlaylistItems(id, userUUID, func(cur []PlaylistItem) ([]PlaylistItem, error) {
+		if len(order) != len(cur) {
+			return nil, errors.New("order must list every position exactly once")
+		}
+		seen := make([]bool, len(cur))
+		out := make([]PlaylistItem, 0, len(cur))
+		for _, pos := range order {
+			if pos < 0 || pos >= len(cur) || seen[pos] {
+				return nil, errors.New("order must list every position exactly once")
+			}
+			seen[pos] = true
+			out = append(out, cur[pos])
+		}
+		return out, nil
+	})
+}
+
+// ResolvePlaylistEntries 解析歌单条目。上传文件属于歌单所有者，
+// 只有所有者本人能播放，其他人只能看到标题等信息
+func ResolvePlaylistEntries(p *Playlist, viewerUUID string) ([]PlaylistEntry, error) {
+	var uploads map[string]MusicFile
+	out := make([]PlaylistEntry, 0, len(p.Items))
+	for i, it := range p.Items {
+		e := PlaylistEntry{Position: i, Type: it.Type, ID: it.ID}
+		switch it.Type {
+		case PlaylistTrack:
+			n, _ := strconv.Atoi(it.ID)
+			if t, err := getTrackByID(n); err == nil {
+				e = trackPlaylistEntry(i, t)
+			}
+		case PlaylistUpload:
+			if uploads == nil {
+				files, err := GetUserMusicFiles(p.UserID)
+				if err != nil {
+					return nil, err
+				}
+				uploads = map[string]MusicFile{}
+				for _, f := range files {
+					uploads[f.ID] = f
+				}
+			}
+			if f, ok := uploads[it.ID]; ok {
+				e.Title, e.Artist, e.Album = f.Title, f.Artist, f.Album
+				if e.Title == "" {
+					e.Title = f.FileName
+				}
+				if viewerUUID == p.UserID {
+					e.Src = "/api/cloud/stream?id=" + f.ID
+					e.Available = true
+				}
+			}
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// trackPlaylistEntry 生成曲库曲目的歌单条目
+func trackPlaylistEntry(pos int, t Track) PlaylistEntry {
+	e := PlaylistEntry{
+		Position:  pos,
+		Type:      PlaylistTrack,
+		ID:        strconv.Itoa(t.ID),
+		Title:     t.Title,
+		Artist:    t.Artist,
+		Album:     t.Album,
+		Duration:  t.Duration,
+		Src:       fmt.Sprintf("/api/audio?id=%d", t.ID),
+		Available: true,
+	}
+	if t.HasCover {
+		e.Cover = fmt.Sprintf("/api/cover?id=%d&size=256", t.ID)
+	}
+	return e
+}
+
+func playlistCoverPath(id string) string {
+	return filepath.Join(dataDir, "playlist_covers", id)
+}
+
+// SetPlaylistCover 保存歌单的自定义封面（JPEG、PNG、WebP 或 GIF）
+func SetPlaylistCover(id, userUUID string, data []byte) error {
+	if _, err := ownPlaylist(id, userUUID); err != nil {
+		return err
+	}
+	if len(data) > MaxPlaylistCoverSize {
+		return fmt.Errorf("cover image must not exceed %d MB", MaxPlaylistCoverSize>>20)
+	}
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/webp", "image/gif":
+	default:
+		return errors.New("cover must be a JPEG, PNG, WebP or GIF image")
+	}
+	path := playlistCoverPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// DeletePlaylistCover 删除自定义封面，之后使用第一首有封面的曲库曲目作为封面
+func DeletePlaylistCover(id, userUUID string) error {
+	if _, err := ownPlaylist(id, userUUID); err != nil {
+		return err
+	}
+	if err := os.Remove(playlistCoverPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PlaylistCover 返回歌单封面：有自定义封面时返回图片内容，
+// 否则返回第一首有封面的曲库曲目ID，供调用方使用曲目封面
+func PlaylistCover(p *Playlist) (*CoverImage, int, error) {
+	if data, err := os.ReadFile(playlistCoverPath(p.ID)); err == nil {
+		st, _ := os.Stat(playlistCoverPath(p.ID))
+		img := &CoverImage{Data: data, MIME: http.DetectContentType(data), ETag: `"` + contentHash(data) + `"`}
+		if st != nil {
+			img.ModTime = st.ModTime()
+		}
+		return img, 0, nil
+	}
+	for _, it := range p.Items {
+		if it.Type != PlaylistTrack {
+			continue
+		}
+		n, _ := strconv.Atoi(it.ID)
+		if t, err := getTrackByID(n); err == nil && t.HasCover {
+			return nil, t.ID, nil
+		}
+	}
+	return nil, 0, errors.New("playlist has no cover")
+}
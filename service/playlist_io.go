@@ -0,0 +1,629 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 歌单文件格式
+const (
+	PlaylistFormatM3U8 = "m3u8"
+	PlaylistFormatPLS  = "pls"
+	PlaylistFormatXSPF = "xspf"
+)
+
+// MaxPlaylistFileSize 是导入歌单文件的大小上限
+const MaxPlaylistFileSize = 4 << 20
+
+// PlaylistFileEntry 是从歌单文件中读出的一条记录，缺失的字段为零值
+type PlaylistFileEntry struct {
+	Index    int     `json:"index"` // 在文件中的序号，从 1 开始
+	Location string  `json:"location"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration"` // 秒，未知时为 0
+}
+
+// PlaylistImportResult 是导入结果：按路径匹配与按标签模糊匹配的数量，以及未能匹配的条目
+type PlaylistImportResult struct {
+	Playlist  *Playlist           `json:"playlist"`
+	Total     int                 `json:"total"`
+	ByPath    int                 `json:"matched_by_path"`
+	ByTags    int                 `json:"matched_by_tags"`
+	Unmatched []PlaylistFileEntry `json:"unmatched"`
+}
+
+// DetectPlaylistFormat 根据文件扩展名判断歌单格式，扩展名未知时根据内容判断
+func DetectPlaylistFormat(filename string, data []byte) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".m3u", ".m3u8":
+		return PlaylistFormatM3U8
+	case ".pls":
+		return PlaylistFormatPLS
+	case ".xspf":
+		return PlaylistFormatXSPF
+	}
+	head := strings.ToLower(strings.TrimSpace(string(bytes.TrimPrefix(data[:min(len(data), 512)], []byte("\xef\xbb\xbf")))))
+	switch {
+	case strings.HasPrefix(head, "<?xml"), strings.HasPrefix(head, "<playlist"):
+		return PlaylistFormatXSPF
+	case strings.HasPrefix(head, "[playlist]"):
+		return PlaylistFormatPLS
+	}
+	return PlaylistFormatM3U8
+}
+
+// ParsePlaylistFile 解析 M3U/M3U8、PLS 或 XSPF 歌单
+func ParsePlaylistFile(format string, data []byte) ([]PlaylistFileEntry, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return nil, errors.New("playlist file must be UTF-8 encoded")
+	}
+	var entries []PlaylistFileEntry
+	var err error
+	switch format {
+	case PlaylistFormatM3U8:
+		entries = parseM3U(data)
+	case PlaylistFormatPLS:
+		entries = parsePLS(data)
+	case PlaylistFormatXSPF:
+		entries, err = parseXSPF(data)
+	default:
+		return nil, fmt.Errorf("unsupported playlist format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Index = i + 1
+	}
+	return entries, nil
+}
+
+// parseM3U 解析扩展 M3U：#EXTINF:时长,歌手 - 标题，下一行为文件位置
+func parseM3U(data []byte) []PlaylistFileEntry {
+	var out []PlaylistFileEntry
+	var pending PlaylistFileEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			dur, display, _ := strings.Cut(info, ",")
+			// 时长后面可能带有 key="value" 形式的属性
+			if f := strings.Fields(dur); len(f) > 0 {
+				if d, err := strconv.ParseFloat(f[0], 64); err == nil && d > 0 {
+					pending.Duration = d
+				}
+			}
+			pending.Artist, pending.Title = splitDisplayTitle(display)
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			out = append(out, pending)
+			pending = PlaylistFileEntry{}
+		}
+	}
+	return out
+}
+
+// parsePLS 解析 PLS：FileN、TitleN、LengthN 按序号组成一条记录
+func parsePLS(data []byte) []PlaylistFileEntry {
+	byNum := map[int]*PlaylistFileEntry{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+				break
+			}
+		}
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(key[len(field):])
+		if err != nil {
+			continue
+		}
+		e := byNum[n]
+		if e == nil {
+			e = &PlaylistFileEntry{}
+			byNum[n] = e
+		}
+		value = strings.TrimSpace(value)
+		switch field {
+		case "file":
+			e.Location = value
+		case "title":
+			e.Artist, e.Title = splitDisplayTitle(value)
+		case "length":
+			if d, err := strconv.ParseFloat(value, 64); err == nil && d > 0 {
+				e.Duration = d
+			}
+		}
+	}
+	nums := make([]int, 0, len(byNum))
+	for n, e := range byNum {
+		if e.Location != "" {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+	out := make([]PlaylistFileEntry, 0, len(nums))
+	for _, n := range nums {
+		out = append(out, *byNum[n])
+	}
+	return out
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location []string `xml:"location,omitempty"`
+	Title    string   `xml:"title,omitempty"`
+	Creator  string   `xml:"creator,omitempty"`
+	Album    string   `xml:"album,omitempty"`
+	Duration int64    `xml:"duration,omitempty"` // 毫秒
+}
+
+func parseXSPF(data []byte) ([]PlaylistFileEntry, error) {
+	var pl xspfPlaylist
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// 兼容省略命名空间的文件
+	dec.DefaultSpace = "http://xspf.org/ns/0/"
+	if err := dec.Decode(&pl); err != nil {
+		return nil, fmt.Errorf("invalid XSPF playlist: %v", err)
+	}
+	out := make([]PlaylistFileEntry, 0, len(pl.Tracks))
+	for _, t := range pl.Tracks {
+		e := PlaylistFileEntry{
+			Title:    strings.TrimSpace(t.Title),
+			Artist:   strings.TrimSpace(t.Creator),
+			Album:    strings.TrimSpace(t.Album),
+			Duration: float64(t.Duration) / 1000,
+		}
+		if len(t.Location) > 0 {
+			e.Location = strings.TrimSpace(t.Location[0])
+		}
+		if e.Location == "" && e.Title == "" {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// splitDisplayTitle 把 "歌手 - 标题" 拆开，没有分隔符时整体作为标题
+func splitDisplayTitle(s string) (artist, title string) {
+	s = strings.TrimSpace(s)
+	if a, t, ok := strings.Cut(s, " - "); ok {
+		return strings.TrimSpace(a), strings.TrimSpace(t)
+	}
+	return "", s
+}
+
+// playlistMatcher 把歌单文件中的记录匹配到曲库曲目：
+// 先按相对路径（忽略大小写，从左到右逐级去掉目录直到命中），再按标题、歌手与时长模糊匹配
+type playlistMatcher struct {
+	tracks   []Track
+	byID     map[int]Track
+	byPath   map[string]Track
+	byBase   map[string][]Track
+	titleKey []string
+	byTitle  map[string][]int // 归一化标题 -> 曲目下标
+	byGram   map[string][]int // 标题中的相邻两字 -> 曲目下标（升序、不重复），用于预选模糊匹配的候选
+}
+
+func newPlaylistMatcher() (*playlistMatcher, error) {
+	list, err := ListTracks()
+	if err != nil {
+		return nil, err
+	}
+	return buildPlaylistMatcher(list), nil
+}
+
+// buildPlaylistMatcher 为给定曲目建立按ID、路径、文件名的索引
+func buildPlaylistMatcher(list []Track) *playlistMatcher {
+	m := &playlistMatcher{
+		tracks:   list,
+		byID:     make(map[int]Track, len(list)),
+		byPath:   make(map[string]Track, len(list)),
+		byBase:   make(map[string][]Track, len(list)),
+		titleKey: make([]string, len(list)),
+		byTitle:  make(map[string][]int, len(list)),
+		byGram:   map[string][]int{},
+	}
+	for i, t := range list {
+		m.byID[t.ID] = t
+		rel := strings.ToLower(t.RelPath)
+		if _, ok := m.byPath[rel]; !ok {
+			m.byPath[rel] = t
+		}
+		base := path.Base(rel)
+		m.byBase[base] = append(m.byBase[base], t)
+		key := normalizeText(t.Title)
+		m.titleKey[i] = key
+		m.byTitle[key] = append(m.byTitle[key], i)
+		runes := []rune(key)
+		for j := 0; j+1 < len(runes); j++ {
+			g := string(runes[j : j+2])
+			if ids := m.byGram[g]; len(ids) == 0 || ids[len(ids)-1] != i {
+				m.byGram[g] = append(ids, i)
+			}
+		}
+	}
+	return m
+}
+
+// titleCandidates 返回标题可能匹配的曲目下标（升序）：标题相同的，以及标题足够长时编辑距离可能不超过 1/5 的。
+// 编辑距离为 k 的两个字符串至少有 len-1-2k 个相邻两字相同，共有的两字不足的曲目不必再计算编辑距离
+func (m *playlistMatcher) titleCandidates(title []rune) []int {
+	if len(title) < 5 {
+		return m.byTitle[string(title)]
+	}
+	need := len(title) - 1 - 2*(len(title)/5)
+	counts := map[int]int{}
+	for j := 0; j+1 < len(title); j++ {
+		for _, i := range m.byGram[string(title[j:j+2])] {
+			counts[i]++
+		}
+	}
+	out := make([]int, 0, len(counts))
+	for i, n := range counts {
+		if n >= need {
+			out = append(out, i)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// matchPath 按文件位置匹配。本站导出的播放地址直接按曲目ID匹配
+func (m *playlistMatcher) matchPath(loc string) (Track, bool) {
+	if loc == "" {
+		return Track{}, false
+	}
+	u, err := url.Parse(loc)
+	switch {
+	case err == nil && strings.EqualFold(u.Scheme, "file"):
+		loc = u.Path
+	case err == nil && (strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https")):
+		if strings.HasSuffix(u.Path, "/api/audio") {
+			if id, err := strconv.Atoi(u.Query().Get("id")); err == nil {
+				t, ok := m.byID[id]
+				return t, ok
+			}
+		}
+		return Track{}, false
+	case strings.Contains(loc, "%"):
+		// XSPF 中的相对地址是经过转义的 URI
+		if p, err := url.PathUnescape(loc); err == nil {
+			loc = p
+		}
+	}
+	p := strings.ToLower(strings.ReplaceAll(loc, `\`, "/"))
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		if t, ok := m.byPath[strings.Join(parts[i:], "/")]; ok {
+			return t, true
+		}
+	}
+	// 只有文件名时，仅在曲库中唯一时才算匹配
+	if cands := m.byBase[p]; len(parts) == 1 && len(cands) == 1 {
+		return cands[0], true
+	}
+	return Track{}, false
+}
+
+// matchTags 按标题、歌手与时长模糊匹配，文件中没有标题时从文件名推断
+func (m *playlistMatcher) matchTags(e PlaylistFileEntry) (Track, bool) {
+	title, artist := e.Title, e.Artist
+	if title == "" && e.Location != "" {
+		stem := path.Base(strings.ReplaceAll(e.Location, `\`, "/"))
+		stem = strings.TrimSuffix(stem, path.Ext(stem))
+		stem = strings.TrimLeft(stem, "0123456789")
+		stem = strings.TrimLeft(stem, " .-_")
+		a, t := splitDisplayTitle(stem)
+		title = t
+		if artist == "" {
+			artist = a
+		}
+	}
+	title = normalizeText(title)
+	artist = normalizeText(artist)
+	if title == "" {
+		return Track{}, false
+	}
+	titleRunes := []rune(title)
+
+	best, bestScore := -1, 0
+	for _, i := range m.titleCandidates(titleRunes) {
+		t := m.tracks[i]
+		score := 0
+		switch {
+		case m.titleKey[i] == title:
+			score = 3
+		case len(titleRunes) >= 5 && max(utf8.RuneCountInString(m.titleKey[i])-len(titleRunes), len(titleRunes)-utf8.RuneCountInString(m.titleKey[i])) <= len(titleRunes)/5 &&
+			editDistance(titleRunes, []rune(m.titleKey[i])) <= len(titleRunes)/5:
+			score = 1
+		default:
+			continue
+		}
+		durationClose := false
+		if e.Duration > 0 && t.Duration > 0 {
+			diff := math.Abs(e.Duration - t.Duration)
+			if diff > 5 {
+				continue
+			}
+			durationClose = diff <= 2
+			if durationClose {
+				score++
+			}
+		}
+		if artist != "" {
+			if a := artistMatchScore(t, artist); a > 0 {
+				score += a
+			} else if score < 4 || !durationClose {
+				// 歌手不符时，只有标题完全相同且时长吻合才接受
+				continue
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 || bestScore < 3 {
+		return Track{}, false
+	}
+	return m.tracks[best], true
+}
+
+// artistMatchScore 比较归一化后的歌手名与曲目署名、拆分后的歌手或专辑歌手：
+// 相同或互相包含得 2 分，拼写相近得 1 分，不符为 0
+func artistMatchScore(t Track, artist string) int {
+	want := []rune(artist)
+	best := 0
+	for _, n := range append([]string{t.Artist, t.AlbumArtist}, t.Artists...) {
+		n = normalizeText(n)
+		if n == "" {
+			continue
+		}
+		if n == artist || strings.Contains(n, artist) || strings.Contains(artist, n) {
+			return 2
+		}
+		if len(want) >= 5 && editDistance(want, []rune(n)) <= len(want)/5 {
+			best = 1
+		}
+	}
+	return best
+}
+
+// ImportPlaylistFile 解析歌单文件并把能匹配到的曲目加入歌单：
+// targetID 为空时以 name 新建歌单，否则追加到用户已有的歌单末尾
+func ImportPlaylistFile(userUUID, targetID, name, format string, data []byte) (*PlaylistImportResult, error) {
+	entries, err := ParsePlaylistFile(format, data)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newPlaylistMatcher()
+	if err != nil {
+		return nil, err
+	}
+	res := &PlaylistImportResult{Total: len(entries), Unmatched: []PlaylistFileEntry{}}
+	var items []PlaylistItem
+	for _, e := range entries {
+		t, ok := m.matchPath(e.Location)
+		if ok {
+			res.ByPath++
+		} else if t, ok = m.matchTags(e); ok {
+			res.ByTags++
+		} else {
+			res.Unmatched = append(res.Unmatched, e)
+			continue
+		}
+		items = append(items, PlaylistItem{Type: PlaylistTrack, ID: strconv.Itoa(t.ID)})
+	}
+	if len(items) > MaxPlaylistItems {
+		return nil, fmt.Errorf("a playlist can hold at most %d tracks", MaxPlaylistItems)
+	}
+
+	var p *Playlist
+	if targetID == "" {
+		if p, err = CreatePlaylist(userUUID, name, "", false); err != nil {
+			return nil, err
+		}
+	} else if p, err = ownPlaylist(targetID, userUUID); err != nil {
+		return nil, err
+	}
+	if len(items) > 0 {
+		if p, err = AddPlaylistItems(p.ID, userUUID, items, -1); err != nil {
+			return nil, err
+		}
+	}
+	res.Playlist = p
+	return res, nil
+}
+
+// PlaylistExportEntry 是导出到歌单文件的一条记录
+type PlaylistExportEntry struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration float64
+	Location string
+}
+
+// trackExportEntry 生成曲库曲目的导出记录：baseURL 为空时使用相对曲库目录的路径，否则使用播放地址
+func trackExportEntry(t Track, baseURL string) PlaylistExportEntry {
+	loc := t.RelPath
+	if baseURL != "" {
+		loc = fmt.Sprintf("%s/api/audio?id=%d", baseURL, t.ID)
+	}
+	return PlaylistExportEntry{Title: t.Title, Artist: t.Artist, Album: t.Album, Duration: t.Duration, Location: loc}
+}
+
+// ExportPlaylistEntries 生成歌单的导出记录，跳过曲库中已不存在的曲目。
+// 上传文件没有曲库路径，只在使用播放地址且导出者是所有者时导出
+func ExportPlaylistEntries(p *Playlist, viewerUUID, baseURL string) ([]PlaylistExportEntry, error) {
+	resolved, err := ResolvePlaylistEntries(p, viewerUUID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PlaylistExportEntry, 0, len(resolved))
+	for _, e := range resolved {
+		switch e.Type {
+		case PlaylistTrack:
+			n, _ := strconv.Atoi(e.ID)
+			if t, err := getTrackByID(n); err == nil {
+				out = append(out, trackExportEntry(t, baseURL))
+			}
+		case PlaylistUpload:
+			if baseURL != "" && e.Available {
+				out = append(out, PlaylistExportEntry{Title: e.Title, Artist: e.Artist, Album: e.Album, Duration: e.Duration, Location: baseURL + e.Src})
+			}
+		}
+	}
+	return out, nil
+}
+
+// ExportAlbumEntries 按专辑内顺序生成导出记录，同时返回专辑名
+func ExportAlbumEntries(albumID int, baseURL string) (string, []PlaylistExportEntry, error) {
+	album, err := findAlbumByID(albumID)
+	if err != nil {
+		return "", nil, err
+	}
+	list, err := ListAlbumTracks(album.Name, album.Artist)
+	if err != nil {
+		return "", nil, err
+	}
+	out := make([]PlaylistExportEntry, 0, len(list))
+	for _, t := range list {
+		out = append(out, trackExportEntry(t, baseURL))
+	}
+	return album.Name, out, nil
+}
+
+// ExportFavoritesEntries 按收藏时间倒序生成用户收藏的导出记录
+func ExportFavoritesEntries(userUUID, baseURL string) ([]PlaylistExportEntry, error) {
+	favs, err := GetUserFavorites(userUUID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PlaylistExportEntry, 0, len(favs))
+	for _, f := range favs {
+		id, err := strconv.Atoi(f.SongID)
+		if err != nil {
+			continue
+		}
+		if t, err := getTrackByID(id); err == nil {
+			out = append(out, trackExportEntry(t, baseURL))
+		}
+	}
+	return out, nil
+}
+
+// PlaylistContentType 返回歌单格式对应的 MIME 类型与文件扩展名
+func PlaylistContentType(format string) (mimeType, ext string, ok bool) {
+	switch format {
+	case PlaylistFormatM3U8:
+		return "audio/x-mpegurl; charset=utf-8", ".m3u8", true
+	case PlaylistFormatPLS:
+		return "audio/x-scpls; charset=utf-8", ".pls", true
+	case PlaylistFormatXSPF:
+		return "application/xspf+xml", ".xspf", true
+	}
+	return "", "", false
+}
+
+// WritePlaylistFile 以指定格式写出歌单
+func WritePlaylistFile(w io.Writer, format, name string, entries []PlaylistExportEntry) error {
+	display := func(e PlaylistExportEntry) string {
+		if e.Artist != "" {
+			return e.Artist + " - " + e.Title
+		}
+		return e.Title
+	}
+	bw := bufio.NewWriter(w)
+	switch format {
+	case PlaylistFormatM3U8:
+		bw.WriteString("#EXTM3U\n")
+		if name != "" {
+			fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(name))
+		}
+		for _, e := range entries {
+			fmt.Fprintf(bw, "#EXTINF:%d,%s\n%s\n", int(e.Duration+0.5), oneLine(display(e)), e.Location)
+		}
+	case PlaylistFormatPLS:
+		bw.WriteString("[playlist]\n")
+		for i, e := range entries {
+			n := i + 1
+			fmt.Fprintf(bw, "File%d=%s\nTitle%d=%s\nLength%d=%d\n", n, e.Location, n, oneLine(display(e)), n, int(e.Duration+0.5))
+		}
+		fmt.Fprintf(bw, "NumberOfEntries=%d\nVersion=2\n", len(entries))
+	case PlaylistFormatXSPF:
+		pl := xspfPlaylist{Version: "1", Title: name, Tracks: make([]xspfTrack, 0, len(entries))}
+		for _, e := range entries {
+			pl.Tracks = append(pl.Tracks, xspfTrack{
+				Location: []string{xspfLocation(e.Location)},
+				Title:    e.Title,
+				Creator:  e.Artist,
+				Album:    e.Album,
+				Duration: int64(e.Duration * 1000),
+			})
+		}
+		bw.WriteString(xml.Header)
+		enc := xml.NewEncoder(bw)
+		enc.Indent("", "  ")
+		if err := enc.Encode(pl); err != nil {
+			return err
+		}
+		bw.WriteString("\n")
+	default:
+		return fmt.Errorf("unsupported playlist format %q", format)
+	}
+	return bw.Flush()
+}
+
+// oneLine 把换行替换为空格，避免破坏按行解析的格式
+func oneLine(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == '\r' }), " ")
+}
+
+// xspfLocation 把相对路径转义为 URI，播放地址保持不变
+func xspfLocation(loc string) string {
+	if strings.Contains(loc, "://") {
+		return loc
+	}
+	parts := strings.Split(loc, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseM3U(t *testing.T) {
+	data := "#EXTM3U\r\n" +
+		"#EXTINF:215,Jay Chou - 晴天\r\n" +
+		"JayChou/Album1/a.flac\r\n" +
+		"\n" +
+		"#EXTINF:-1 tvg-id=\"x\",Radio\n" +
+		"http://example.com/stream\n" +
+		"# comment\n" +
+		"plain.mp3\n"
+	want := []PlaylistFileEntry{
+		{Location: "JayChou/Album1/a.flac", Artist: "Jay Chou", Title: "晴天", Duration: 215},
+		{Location: "http://example.com/stream", Title: "Radio"},
+		{Location: "plain.mp3"},
+	}
+	if got := parseM3U([]byte(data)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseM3U =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	data := "[playlist]\n" +
+		"File2=b.mp3\n" +
+		"Title2=B Title\n" +
+		"File1 = a.mp3\n" +
+		"Title1=Artist - A\n" +
+		"Length1=120\n" +
+		"Length3=10\n" +
+		"NumberOfEntries=2\n" +
+		"Version=2\n"
+	want := []PlaylistFileEntry{
+		{Location: "a.mp3", Artist: "Artist", Title: "A", Duration: 120},
+		{Location: "b.mp3", Title: "B Title"},
+	}
+	if got := parsePLS([]byte(data)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePLS =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseXSPF(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []PlaylistFileEntry
+		wantErr bool
+	}{
+		{
+			name: "with namespace",
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track>
+      <location>file:///music/a.flac</location>
+      <location>http://mirror/a.flac</location>
+      <title> A </title><creator>X</creator><album>Al</album><duration>1500</duration>
+    </track>
+    <track><title></title></track>
+    <track><location>b%20c.mp3</location></track>
+  </trackList>
+</playlist>`,
+			want: []PlaylistFileEntry{
+				{Location: "file:///music/a.flac", Title: "A", Artist: "X", Album: "Al", Duration: 1.5},
+				{Location: "b%20c.mp3"},
+			},
+		},
+		{
+			name: "without namespace",
+			data: `<playlist version="1"><trackList><track><title>Only Title</title></track></trackList></playlist>`,
+			want: []PlaylistFileEntry{{Title: "Only Title"}},
+		},
+		{
+			name:    "invalid xml",
+			data:    `<playlist><trackList>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseXSPF([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseXSPF error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseXSPF =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaylistMatcherMatchPath(t *testing.T) {
+	m := buildPlaylistMatcher([]Track{
+		{ID: 1, RelPath: "JayChou/Album1/a.flac"},
+		{ID: 2, RelPath: "Other/new.flac"},
+		{ID: 3, RelPath: "A/dup.mp3"},
+		{ID: 4, RelPath: "B/dup.mp3"},
+		{ID: 5, RelPath: "Other/b c.mp3"},
+	})
+	tests := []struct {
+		loc    string
+		wantID int // 0 表示不应匹配
+	}{
+		{"", 0},
+		{"JayChou/Album1/a.flac", 1},
+		{"jaychou/ALBUM1/A.FLAC", 1},
+		{"/home/me/Music/JayChou/Album1/a.flac", 1},
+		{`D:\Music\Other\new.flac`, 2},
+		{"file:///home/me/Music/Other/new.flac", 2},
+		{"new.flac", 2},
+		{"dup.mp3", 0},
+		{"A/dup.mp3", 3},
+		{"Other/b%20c.mp3", 5},
+		{"http://host/api/audio?id=4", 4},
+		{"http://host/api/audio?id=99", 0},
+		{"https://example.com/Other/new.flac", 0},
+		{"missing/x.flac", 0},
+	}
+	for _, tt := range tests {
+		got, ok := m.matchPath(tt.loc)
+		if tt.wantID == 0 {
+			if ok {
+				t.Errorf("matchPath(%q) matched track %d, want no match", tt.loc, got.ID)
+			}
+			continue
+		}
+		if !ok || got.ID != tt.wantID {
+			t.Errorf("matchPath(%q) = %d, %v, want %d", tt.loc, got.ID, ok, tt.wantID)
+		}
+	}
+}
+
+func TestPlaylistMatcherMatchTags(t *testing.T) {
+	m := buildPlaylistMatcher([]Track{
+		{ID: 1, Title: "Blue Moonlight Sonata", Artist: "Someone", Duration: 200},
+		{ID: 2, Title: "Yellow", Artist: "Coldplay", Duration: 269},
+		{ID: 3, Title: "Completely Different", Artist: "Other", Duration: 180},
+		{ID: 4, Title: "Yellow", Artist: "Other", Duration: 150},
+	})
+	tests := []struct {
+		entry  PlaylistFileEntry
+		wantID int // 0 表示不应匹配
+	}{
+		{PlaylistFileEntry{Title: "Yellow", Artist: "Coldplay"}, 2},
+		{PlaylistFileEntry{Title: "yellow", Duration: 150}, 4},
+		{PlaylistFileEntry{Title: "Blue Moonlite Sonata", Artist: "Someone", Duration: 201}, 1},
+		{PlaylistFileEntry{Location: "music/03 - Coldplay - Yellow.mp3"}, 2},
+		{PlaylistFileEntry{Title: "Nothing Like It", Artist: "Someone"}, 0},
+		{PlaylistFileEntry{Title: "Yellow", Artist: "Coldplay", Duration: 100}, 0},
+	}
+	for _, tt := range tests {
+		got, ok := m.matchTags(tt.entry)
+		if tt.wantID == 0 {
+			if ok {
+				t.Errorf("matchTags(%+v) matched track %d, want no match", tt.entry, got.ID)
+			}
+			continue
+		}
+		if !ok || got.ID != tt.wantID {
+			t.Errorf("matchTags(%+v) = %d, %v, want %d", tt.entry, got.ID, ok, tt.wantID)
+		}
+	}
+	if c := m.titleCandidates([]rune(normalizeText("Blue Moonlite Sonata"))); len(c) != 1 || c[0] != 0 {
+		t.Errorf("titleCandidates = %v, want [0]", c)
+	}
+}
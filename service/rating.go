@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MaxTrackRating 是评分上限，评分为 1 到 5 的整数，0 表示未评分
+const MaxTrackRating = 5
+
+// GetUserRatings 返回用户对曲目的评分（曲目ID -> 评分）
+func GetUserRatings(userUUID string) (map[int]int, error) {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return nil, errors.New("invalid user id")
+	}
+	rows, err := supabaseREST("GET", "track_ratings?user_id=eq."+userUUID+"&select=song_id,rating", nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]int, len(rows))
+	for _, row := range rows {
+		id, err := strconv.Atoi(getStringFromMap(row, "song_id", ""))
+		if err != nil {
+			continue
+		}
+		out[id] = getIntFromMapUpload(row, "rating", 0)
+	}
+	return out, nil
+}
+
+// SetTrackRating 设置用户对曲库曲目的评分，rating 为 0 时清除评分
+func SetTrackRating(userUUID string, trackID, rating int) error {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return errors.New("user not authenticated")
+	}
+	if rating < 0 || rating > MaxTrackRating {
+		return fmt.Errorf("rating must be between 0 and %d", MaxTrackRating)
+	}
+	if _, err := getTrackByID(trackID); err != nil {
+		return err
+	}
+	filter := fmt.Sprintf("track_ratings?user_id=eq.%s&song_id=eq.%d", userUUID, trackID)
+	if rating == 0 {
+		_, err := supabaseREST("DELETE", filter, nil)
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	// 先更新已有评分，没有时再插入；并发插入撞上主键时重新更新一次
+	for attempt := 0; attempt < 2; attempt++ {
+		rows, err := supabaseREST("PATCH", filter, map[string]interface{}{"rating": rating, "updated_at": now})
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			return nil
+		}
+		_, err = supabaseREST("POST", "track_ratings", map[string]interface{}{
+			"user_id":    userUUID,
+			"song_id":    strconv.Itoa(trackID),
+			"rating":     rating,
+			"updated_at": now,
+		})
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.New("failed to save rating")
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"MusicPlayerWeb/db"
+)
+
+// supabaseREST 调用 Supabase REST API（/rest/v1/ + path），返回解析后的行。
+// body 为 nil 时不发送请求体；写操作带 Prefer: return=representation，返回受影响的行
+func supabaseREST(method, path string, body interface{}) ([]map[string]interface{}, error) {
+	if err := db.Init(); err != nil {
+		return nil, fmt.Errorf("数据库连接失败: %v", err)
+	}
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("序列化数据失败: %v", err)
+		}
+		reader = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/rest/v1/%s", os.Getenv("SUPABASE_URL"), path), reader)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("apikey", os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+	if method != http.MethodGet {
+		req.Header.Set("Prefer", "return=representation")
+	}
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("API 返回错误状态码: %d, 响应: %s", resp.StatusCode, string(data))
+	}
+	var rows []map[string]interface{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("解析响应失败: %v", err)
+		}
+	}
+	return rows, nil
+}
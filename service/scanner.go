@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+var (
+	// scanMu 保证同一时间只有一个扫描在构建索引
+	scanMu sync.Mutex
+
+	cancelMu   sync.Mutex
+	cancelScan context.CancelFunc
+)
+
+// scanWorkers 返回并发读取标签的协程数：按 CPU 数，限制在 2~16 之间
+func scanWorkers() int {
+	n := runtime.NumCPU()
+	if n < 2 {
+		n = 2
+	}
+	if n > 16 {
+		n = 16
+	}
+	return n
+}
+
+// CancelRescan 取消正在进行的扫描，没有扫描在进行时返回 false
+func CancelRescan() bool {
+	cancelMu.Lock()
+	defer cancelMu.Unlock()
+	if cancelScan == nil {
+		return false
+	}
+	cancelScan()
+	return true
+}
+
+// scanFile 是遍历阶段收集到的一个音频文件
+type scanFile struct {
+	path   string
+	rel    string
+	root   string
+	info   os.FileInfo
+	format audioFormat
+	id     int
+	prev   *Track // 上次索引中的记录，文件未变化时直接复用
+	seen   bool   // 上次索引中是否存在该路径
+	added  int64  // 上次索引中记录的加入时间
+	side   sidecarFiles
+}
+
+// scanDir 扫描音乐目录并生成新的曲库索引。
+// 新索引在锁外构建，标签由协程池并发读取，全部完成后才一次性替换 tracks，
+// 扫描期间各曲库接口继续使用旧索引。
+func scanDir(parent context.Context) error {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+
+	ctx, cancel := context.WithCancel(parent)
+	cancelMu.Lock()
+	cancelScan = cancel
+	cancelMu.Unlock()
+	defer func() {
+		cancelMu.Lock()
+		cancelScan = nil
+		cancelMu.Unlock()
+		cancel()
+	}()
+
+	beginScanProgress()
+	startedAt := time.Now()
+	var report *ScanReport
+	var scanErr error
+	defer func() { finishScanProgress(report, scanErr) }()
+
+	// 以上次扫描结果（内存中没有时读取磁盘索引）为基准做增量扫描
+	mu.RLock()
+	gen := rootsGen
+	scanRoots := enabledRoots()
+	prevList := tracks
+	mu.RUnlock()
+	if prevList == nil {
+		prevList = loadLibraryIndex()
+	}
+	// 首次建立索引时以文件修改时间作为加入时间，之后新出现的文件以发现时间为准
+	initial := len(prevList) == 0
+	prev := make(map[string]Track, len(prevList))
+	for _, t := range prevList {
+		prev[t.Path] = t
+	}
+
+	// 旧版ID映射只在还没有快照时按本次遍历顺序生成，之后一直使用快照
+	mu.RLock()
+	haveLegacy := legacyIDs != nil
+	mu.RUnlock()
+	if !haveLegacy {
+		if snap := loadLegacySnapshot(); snap != nil {
+			mu.Lock()
+			legacyIDs = snap.IDs
+			mu.Unlock()
+			haveLegacy = true
+		}
+	}
+	var newLegacyIDs map[int]int
+	if !haveLegacy {
+		newLegacyIDs = map[int]int{}
+	}
+
+	var files []scanFile
+	usedIDs := map[int]bool{}
+	for _, root := range scanRoots {
+		rootFiles, err := collectScanFiles(ctx, root, prev, usedIDs, newLegacyIDs)
+		if err != nil {
+			scanErr = err
+			return err
+		}
+		files = append(files, rootFiles...)
+	}
+	setScanTotal(len(files))
+
+	results, readErrs, err := readScanFiles(ctx, files)
+	if err != nil {
+		scanErr = err
+		return err
+	}
+
+	report = &ScanReport{Added: []string{}, Changed: []string{}, Removed: []string{}, Failed: []ScanFailure{}, StartedAt: startedAt}
+	scanned := make([]Track, 0, len(files))
+	for i, f := range files {
+		t := results[i]
+		if t == nil {
+			report.Failed = append(report.Failed, ScanFailure{Path: f.root + "/" + f.rel, Error: readErrs[i].Error()})
+			continue
+		}
+		if t.AddedAt == 0 {
+			t.AddedAt = f.added
+		}
+		if t.AddedAt == 0 {
+			if initial || f.seen {
+				t.AddedAt = f.info.ModTime().Unix()
+			} else {
+				t.AddedAt = startedAt.Unix()
+			}
+		}
+		scanned = append(scanned, *t)
+		if f.prev != nil {
+			continue
+		}
+		if f.seen {
+			report.Changed = append(report.Changed, t.Root+"/"+t.RelPath)
+		} else {
+			report.Added = append(report.Added, t.Root+"/"+t.RelPath)
+		}
+	}
+	for _, t := range prev {
+		report.Removed = append(report.Removed, t.Root+"/"+t.RelPath)
+	}
+	sort.Strings(report.Removed)
+	report.Total = len(scanned)
+
+	newLibrary := buildSearchIndex(scanned)
+
+	// 原子替换：扫描期间根目录配置被修改则丢弃本次结果
+	mu.Lock()
+	if rootsGen != gen {
+		mu.Unlock()
+		report = nil
+		scanErr = context.Canceled
+		return scanErr
+	}
+	tracks = scanned
+	library = newLibrary
+	if newLegacyIDs != nil {
+		legacyIDs = newLegacyIDs
+	}
+	report.Duration = time.Since(report.StartedAt).String()
+	lastScanReport = report
+	mu.Unlock()
+
+	if err := saveLibraryIndex(scanned); err != nil {
+		fmt.Printf("保存曲库索引失败: %v\n", err)
+	}
+	if err := saveScanReport(report); err != nil {
+		fmt.Printf("保存扫描结果失败: %v\n", err)
+	}
+	if newLegacyIDs != nil {
+		if err := saveLegacySnapshot(newLegacyIDs, startedAt); err != nil {
+			fmt.Printf("保存旧版曲目ID快照失败: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// collectScanFiles 遍历一个根目录收集音频文件，按遍历顺序分配稳定ID（还没有快照时，默认根目录还分配旧版顺序ID）。
+// 命中的上次索引记录会从 prev 中删除，全部根目录遍历结束后 prev 中剩下的即为已删除的文件。
+func collectScanFiles(ctx context.Context, root LibraryRoot, prev map[string]Track, usedIDs map[int]bool, legacy map[int]int) ([]scanFile, error) {
+	var files []scanFile
+	legacyID := 0
+	sidecars := sidecarCache{}
+	err := filepath.Walk(root.Path, func(p string, info os.FileInfo, e error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e != nil {
+			return nil // skip error entry
+		}
+		if info.IsDir() {
+			return nil
+		}
+		format, ok := lookupAudioFormat(info.Name())
+		if !ok {
+			return nil
+		}
+
+		rel, relErr := filepath.Rel(root.Path, p)
+		if relErr != nil {
+			rel = p
+		}
+		rel = filepath.ToSlash(rel)
+		f := scanFile{path: p, rel: rel, root: root.Name, info: info, format: format, side: sidecars.lookup(p)}
+		f.id = stableTrackID(rootTrackKey(root.Name, rel), usedIDs)
+		// 旧版只扫描默认目录下的 FLAC，并按遍历顺序从 0 开始编号；legacy 为 nil 时已有快照
+		if legacy != nil && root.Name == defaultRootName && format.Name == "flac" {
+			legacy[legacyID] = f.id
+			legacyID++
+		}
+
+		if old, seen := prev[p]; seen {
+			f.seen = true
+			f.added = old.AddedAt
+			delete(prev, p)
+			// 音频文件及其外部歌词、封面都未变化时才复用
+			if old.ModTime == info.ModTime().UnixNano() && old.Size == info.Size() && old.Sidecars == f.side.sig {
+				old.ID = f.id
+				old.Root = f.root
+				old.RelPath = f.rel
+				f.prev = &old
+			}
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// readScanFiles 用协程池读取需要更新的文件标签，结果与 files 一一对应，读取失败的为 nil，原因记在 errs 的同一位置
+func readScanFiles(ctx context.Context, files []scanFile) (results []*Track, errs []error, err error) {
+	results = make([]*Track, len(files))
+	errs = make([]error, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < scanWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				f := files[idx]
+				t, err := readTrackFile(f.path, f.info, f.format)
+				addScanProgress(1, 1)
+				if err != nil {
+					errs[idx] = err
+					continue
+				}
+				t.ID = f.id
+				t.Root = f.root
+				t.RelPath = f.rel
+				applySidecars(&t, f.side)
+				results[idx] = &t
+			}
+		}()
+	}
+
+feed:
+	for i, f := range files {
+		if f.prev != nil {
+			results[i] = f.prev
+			addScanProgress(1, 0)
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return results, errs, nil
+}
+
+// readTrackFile 打开音频文件读取标签，生成曲目信息（不含ID）
+func readTrackFile(p string, info os.FileInfo, format audioFormat) (Track, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return Track{}, err
+	}
+	defer f.Close()
+
+	m, _ := tag.ReadFrom(f)
+
+	t := Track{
+		Path:    p,
+		Title:   info.Name(),
+		Format:  format.Name,
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+	}
+
+	if m != nil {
+		if title := m.Title(); title != "" {
+			t.Title = title
+		}
+		t.Artist, t.Artists = loadArtistCredit(f, format, m.Artist())
+		t.Album = m.Album()
+		t.AlbumArtist = strings.TrimSpace(m.AlbumArtist())
+		t.TrackNumber, t.TrackTotal = m.Track()
+		t.DiscNumber, t.DiscTotal = m.Disc()
+		t.Year = m.Year()
+		t.Genre = strings.TrimSpace(m.Genre())
+		t.Composer = strings.TrimSpace(m.Composer())
+		// Cover
+		if pic := m.Picture(); pic != nil && len(pic.Data) > 0 {
+			t.HasCover = true
+			t.CoverHash = contentHash(pic.Data)
+		}
+		// Lyrics
+		if l := m.Lyrics(); l != "" {
+			t.HasLyrics = true
+			t.LyricsText = cleanLyrics(l)
+		} else if rawMeta, ok := m.(tag.Metadata); ok {
+			for k, v := range rawMeta.Raw() {
+				if k == "LYRICS" || k == "UNSYNCEDLYRICS" {
+					if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+						t.HasLyrics = true
+						t.LyricsText = cleanLyrics(s)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	ai := readAudioInfo(f, format, info.Size())
+	t.Duration = ai.Duration
+	t.SampleRate = ai.SampleRate
+	t.BitDepth = ai.BitDepth
+	t.Bitrate = ai.Bitrate
+	t.Channels = ai.Channels
+	t.Codec = ai.Codec
+
+	// 读完整个文件计算内容摘要，播放时直接用作 ETag；失败时播放请求再按需计算
+	if _, err := f.Seek(0, io.SeekStart); err == nil {
+		t.ContentHash, _ = readerContentHash(f)
+	}
+	return t, nil
+}
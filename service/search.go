@@ -0,0 +1,363 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// searchKey 是一个可检索文本的多种归一化形式
+type searchKey struct {
+	text     string // 小写、繁体转简体、合并空白
+	full     string // 全拼（无空格），非汉字保留字母数字
+	initials string // 拼音首字母，非汉字单词取首字母
+}
+
+// trackSearchEntry 是单首曲目的检索条目
+type trackSearchEntry struct {
+	id     int
+	title  searchKey
+	artist searchKey
+	album  searchKey
+	lyrics string // 归一化后的歌词纯文本
+}
+
+// searchIndex 在每次扫描完成后与 tracks 一起替换
+type searchIndex struct {
+	tracks  []trackSearchEntry
+	artists map[string]searchKey
+	albums  map[string]searchKey
+}
+
+var (
+	library     *searchIndex
+	pinyinArgs  = pinyin.NewArgs()
+	fieldWeight = map[string]float64{"title": 1.0, "artist": 0.9, "album": 0.8, "lyrics": 1.0}
+)
+
+// TrackHit 是曲目搜索结果
+type TrackHit struct {
+	Track
+	Score   float64 `json:"score"`
+	Matched string  `json:"matched"`           // 命中字段：title/artist/album/lyrics
+	Snippet string  `json:"snippet,omitempty"` // 歌词命中时的上下文
+}
+
+// AlbumHit 是专辑搜索结果
+type AlbumHit struct {
+	Album
+	Score float64 `json:"score"`
+}
+
+// ArtistHit 是歌手搜索结果
+type ArtistHit struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	SongCount    int     `json:"songCount"`
+	CoverTrackID int     `json:"coverTrackId"`
+	Score        float64 `json:"score"`
+}
+
+// SearchResult 是曲库搜索结果
+type SearchResult struct {
+	Tracks  []TrackHit  `json:"tracks"`
+	Albums  []AlbumHit  `json:"albums"`
+	Artists []ArtistHit `json:"artists"`
+}
+
+// normalizeText 统一大小写与繁简体，并把连续空白合并为一个空格
+func normalizeText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(toSimplified(s))), " ")
+}
+
+// newSearchKey 生成文本的各种检索形式
+func newSearchKey(s string) searchKey {
+	text := normalizeText(s)
+	var full, initials strings.Builder
+	wordStart := true
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			if py := pinyin.SinglePinyin(r, pinyinArgs); len(py) > 0 && py[0] != "" {
+				full.WriteString(py[0])
+				initials.WriteByte(py[0][0])
+			}
+			wordStart = true
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			full.WriteRune(r)
+			if wordStart {
+				initials.WriteRune(r)
+				wordStart = false
+			}
+			continue
+		}
+		wordStart = true
+	}
+	return searchKey{text: text, full: full.String(), initials: initials.String()}
+}
+
+// buildSearchIndex 为曲目、歌手与专辑预先计算检索形式
+func buildSearchIndex(list []Track) *searchIndex {
+	idx := &searchIndex{
+		tracks:  make([]trackSearchEntry, 0, len(list)),
+		artists: map[string]searchKey{},
+		albums:  map[string]searchKey{},
+	}
+	cache := map[string]searchKey{}
+	key := func(s string) searchKey {
+		if k, ok := cache[s]; ok {
+			return k
+		}
+		k := newSearchKey(s)
+		cache[s] = k
+		return k
+	}
+	for _, t := range list {
+		e := trackSearchEntry{
+			id:     t.ID,
+			title:  key(t.Title),
+			artist: key(t.Artist),
+			album:  key(t.Album),
+			lyrics: normalizeText(t.LyricsText),
+		}
+		idx.tracks = append(idx.tracks, e)
+		for _, a := range t.Artists {
+			idx.artists[a] = key(a)
+		}
+		if al := strings.TrimSpace(t.Album); al != "" {
+			idx.albums[al] = e.album
+		}
+	}
+	return idx
+}
+
+// searchQuery 是归一化后的查询
+type searchQuery struct {
+	text   string
+	letter string // 仅含字母数字的查询（去掉空格），用于拼音匹配
+}
+
+func newSearchQuery(q string) searchQuery {
+	sq := searchQuery{text: normalizeText(q)}
+	letters := strings.ReplaceAll(sq.text, " ", "")
+	ascii := letters != ""
+	for _, r := range letters {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		sq.letter = letters
+	}
+	return sq
+}
+
+// matchScore 计算查询与检索文本的相关度，0 表示不匹配。
+// 优先级：完全匹配 > 前缀 > 包含 > 全拼 > 拼音首字母 > 模糊匹配
+func matchScore(q searchQuery, k searchKey) float64 {
+	if q.text == "" || k.text == "" {
+		return 0
+	}
+	switch {
+	case k.text == q.text:
+		return 100
+	case strings.HasPrefix(k.text, q.text):
+		return 90
+	case strings.Contains(k.text, q.text):
+		return 70
+	}
+	if q.letter != "" {
+		switch {
+		case k.full == q.letter:
+			return 85
+		case k.initials == q.letter:
+			return 80
+		case strings.HasPrefix(k.full, q.letter):
+			return 75
+		case strings.HasPrefix(k.initials, q.letter) && len(q.letter) >= 2:
+			return 65
+		case strings.Contains(k.full, q.letter) && len(q.letter) >= 3:
+			return 55
+		}
+	}
+	// 模糊匹配：与等长前缀或任一单词的编辑距离足够小
+	qr := []rune(q.text)
+	if len(qr) < 3 {
+		return 0
+	}
+	maxDist := 1
+	if len(qr) > 6 {
+		maxDist = 2
+	}
+	best := maxDist + 1
+	// 候选为从每个单词开头截起的后缀，多词查询也能对齐到标题中间
+	words := strings.Fields(k.text)
+	candidates := make([]string, 0, len(words)+1)
+	for i := range words {
+		candidates = append(candidates, strings.Join(words[i:], " "))
+	}
+	if q.letter != "" && k.full != "" {
+		candidates = append(candidates, k.full)
+	}
+	for _, c := range candidates {
+		cr := []rune(c)
+		if len(cr) > len(qr) {
+			cr = cr[:len(qr)]
+		}
+		if d := editDistance(qr, cr); d < best {
+			best = d
+		}
+	}
+	if best <= maxDist {
+		return 40 - float64(best)*10
+	}
+	return 0
+}
+
+// editDistance 计算两个字符序列的编辑距离，相邻字符互换计为一次编辑
+func editDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// lyricSnippet 截取歌词中命中位置所在的一行
+func lyricSnippet(lyrics, q string) string {
+	for _, line := range strings.Split(lyrics, "\n") {
+		if strings.Contains(normalizeText(line), q) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// Search 在曲目、专辑和歌手中搜索，每类最多返回 limit 条，按相关度降序排列
+func Search(q string, limit int) (*SearchResult, error) {
+	if err := InitMusicCache(); err != nil {
+		return nil, err
+	}
+	query := newSearchQuery(q)
+	result := &SearchResult{Tracks: []TrackHit{}, Albums: []AlbumHit{}, Artists: []ArtistHit{}}
+	if query.text == "" {
+		return result, nil
+	}
+
+	mu.RLock()
+	idx := library
+	byID := make(map[int]Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+	mu.RUnlock()
+	if idx == nil {
+		return result, nil
+	}
+
+	for _, e := range idx.tracks {
+		best, field := 0.0, ""
+		for _, f := range []struct {
+			name string
+			key  searchKey
+		}{{"title", e.title}, {"artist", e.artist}, {"album", e.album}} {
+			if s := matchScore(query, f.key) * fieldWeight[f.name]; s > best {
+				best, field = s, f.name
+			}
+		}
+		snippet := ""
+		if best < 30 && e.lyrics != "" && len([]rune(query.text)) >= 2 && strings.Contains(e.lyrics, query.text) {
+			best, field = 30*fieldWeight["lyrics"], "lyrics"
+		}
+		if best == 0 {
+			continue
+		}
+		t := byID[e.id]
+		if field == "lyrics" {
+			snippet = lyricSnippet(t.LyricsText, query.text)
+		}
+		result.Tracks = append(result.Tracks, TrackHit{Track: t, Score: best, Matched: field, Snippet: snippet})
+	}
+	sort.SliceStable(result.Tracks, func(i, j int) bool {
+		if result.Tracks[i].Score != result.Tracks[j].Score {
+			return result.Tracks[i].Score > result.Tracks[j].Score
+		}
+		return strings.ToLower(result.Tracks[i].Title) < strings.ToLower(result.Tracks[j].Title)
+	})
+	if len(result.Tracks) > limit {
+		result.Tracks = result.Tracks[:limit]
+	}
+
+	albums, err := ListAlbums()
+	if err != nil {
+		return nil, err
+	}
+	for _, al := range albums {
+		k, ok := idx.albums[al.Name]
+		if !ok {
+			k = newSearchKey(al.Name)
+		}
+		if s := matchScore(query, k); s > 0 {
+			result.Albums = append(result.Albums, AlbumHit{Album: al, Score: s})
+		}
+	}
+	sort.SliceStable(result.Albums, func(i, j int) bool {
+		if result.Albums[i].Score != result.Albums[j].Score {
+			return result.Albums[i].Score > result.Albums[j].Score
+		}
+		return result.Albums[i].Name < result.Albums[j].Name
+	})
+	if len(result.Albums) > limit {
+		result.Albums = result.Albums[:limit]
+	}
+
+	artists, err := ListArtists()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range artists {
+		name, _ := a["name"].(string)
+		k, ok := idx.artists[name]
+		if !ok {
+			k = newSearchKey(name)
+		}
+		if s := matchScore(query, k); s > 0 {
+			hit := ArtistHit{Name: name, Score: s}
+			hit.ID, _ = a["id"].(int)
+			hit.SongCount, _ = a["songCount"].(int)
+			hit.CoverTrackID, _ = a["coverTrackID"].(int)
+			result.Artists = append(result.Artists, hit)
+		}
+	}
+	sort.SliceStable(result.Artists, func(i, j int) bool {
+		if result.Artists[i].Score != result.Artists[j].Score {
+			return result.Artists[i].Score > result.Artists[j].Score
+		}
+		return result.Artists[i].Name < result.Artists[j].Name
+	})
+	if len(result.Artists) > limit {
+		result.Artists = result.Artists[:limit]
+	}
+
+	return result, nil
+}
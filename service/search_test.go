@@ -0,0 +1,56 @@
+package service
+
+import "testing"
+
+func TestMatchScore(t *testing.T) {
+	tests := []struct {
+		query, text string
+		want        float64
+	}{
+		{"", "晴天", 0},
+		{"晴天", "", 0},
+		{"晴天", "晴天", 100},
+		{"HELLO  World", "hello world", 100},
+		{"晴", "晴天", 90},
+		{"天", "晴天", 70},
+		{"qingtian", "晴天", 85},
+		{"qt", "晴天", 80},
+		{"qing", "晴天", 75},
+		{"ql", "七里香", 65},
+		{"tian", "晴天", 55},
+		{"helo", "hello world", 30},
+		{"hlelo", "hello", 30},
+		{"wrold", "hello world", 30},
+		{"beautifull dayz", "beautiful day", 20},
+		{"hx", "hello", 0},
+		{"zzzz", "hello", 0},
+	}
+	for _, tt := range tests {
+		got := matchScore(newSearchQuery(tt.query), newSearchKey(tt.text))
+		if got != tt.want {
+			t.Errorf("matchScore(%q, %q) = %v, want %v", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"kitten", "sitting", 3},
+		{"ab", "ba", 1},
+		{"abc", "acb", 1},
+		{"ca", "abc", 3},
+		{"晴天", "晴夫", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
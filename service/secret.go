@@ -0,0 +1,46 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	secretKeysMu sync.Mutex
+	secretKeys   = map[string][]byte{}
+)
+
+// loadSecretKey 返回服务端密钥：优先由环境变量 env 派生，否则使用数据目录下自动生成的 file。
+// 多个实例共用数据库时应设置相同的环境变量，保证彼此生成的密文与签名可以互认
+func loadSecretKey(env, file string) ([]byte, error) {
+	secretKeysMu.Lock()
+	defer secretKeysMu.Unlock()
+	if key, ok := secretKeys[file]; ok {
+		return key, nil
+	}
+	if k := os.Getenv(env); k != "" {
+		sum := sha256.Sum256([]byte(k))
+		secretKeys[file] = sum[:]
+		return sum[:], nil
+	}
+	p := filepath.Join(dataDir, file)
+	if b, err := os.ReadFile(p); err == nil && len(b) == 32 {
+		secretKeys[file] = b
+		return b, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(p, key, 0600); err != nil {
+		return nil, err
+	}
+	secretKeys[file] = key
+	return key, nil
+}
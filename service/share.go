@@ -0,0 +1,442 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 分享链接的目标类型
+const (
+	ShareTrack  = "track"  // 曲库中的曲目，TargetID 为曲目ID
+	ShareUpload = "upload" // 用户上传的音乐文件，TargetID 为 MusicFile.ID
+	ShareAlbum  = "album"  // 曲库中的专辑，TargetID 为专辑ID
+)
+
+const (
+	// DefaultShareTTL 未指定有效期时分享链接的有效期
+	DefaultShareTTL = 7 * 24 * time.Hour
+	// MaxShareTTL 分享链接最长有效期
+	MaxShareTTL = 90 * 24 * time.Hour
+	// sharePlayTicketTTL 播放凭证的有效期，足够听完一首曲目（含暂停与拖动）
+	sharePlayTicketTTL = 4 * time.Hour
+)
+
+var (
+	ErrShareNotFound  = errors.New("share link not found")
+	ErrShareExpired   = errors.New("share link expired")
+	ErrSharePlayLimit = errors.New("share link play limit reached")
+	ErrSharePassword  = errors.New("share link password required")
+	// ErrShareUnlockThrottled 表示该链接短时间内密码错误次数过多
+	ErrShareUnlockThrottled = errors.New("too many wrong passwords, try again later")
+)
+
+// shareUnlockLimiter 限制每个分享链接的密码错误次数：15 分钟内最多 5 次
+var shareUnlockLimiter = newFailureLimiter(5, 15*time.Minute)
+
+// maxSharePasswordLen 是 bcrypt 能处理的最大密码长度（字节）
+const maxSharePasswordLen = 72
+
+// ShareLink 是一个分享链接。令牌由链接ID与过期时间签名得到，
+// 篡改或过期的令牌无需查询数据库即可拒绝；撤销即删除数据库中的记录
+type ShareLink struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"-"`
+	Type        string    `json:"type"`
+	TargetID    string    `json:"target_id"`
+	Title       string    `json:"title"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	MaxPlays    int       `json:"max_plays"` // 0 表示不限次数
+	PlayCount   int       `json:"play_count"`
+	HasPassword bool      `json:"has_password"`
+	CreatedAt   time.Time `json:"created_at"`
+	Token       string    `json:"token"`
+
+	passwordHash string
+}
+
+// shareKey 返回分享令牌的签名密钥
+func shareKey() ([]byte, error) {
+	return loadSecretKey("SHARE_SECRET_KEY", "share.key")
+}
+
+func shareMAC(key []byte, msg string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// signShareToken 生成 "<id>.<过期时间戳>.<签名>" 形式的令牌
+func signShareToken(id string, expiresAt time.Time) (string, error) {
+	key, err := shareKey()
+	if err != nil {
+		return "", err
+	}
+	payload := id + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + shareMAC(key, payload), nil
+}
+
+// hashSharePassword 返回访问密码的 bcrypt 哈希。share_links 表可被匿名密钥读取，
+// 哈希必须足够慢，不能被离线穷举
+func hashSharePassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// isLegacySharePasswordHash 判断是否为旧版 "salt$sha256" 格式的哈希
+func isLegacySharePasswordHash(h string) bool {
+	return !strings.HasPrefix(h, "$2")
+}
+
+// CreateShareLink 为当前用户创建分享链接。ttl <= 0 时使用默认有效期，maxPlays 为 0 表示不限次数，
+// password 为空表示不设密码。上传文件只能由其所有者分享
+func CreateShareLink(userUUID, kind, targetID string, ttl time.Duration, maxPlays int, password string) (*ShareLink, error) {
+	if userUUID == "" {
+		return nil, errors.New("user not authenticated")
+	}
+	if ttl <= 0 {
+		ttl = DefaultShareTTL
+	}
+	if ttl > MaxShareTTL {
+		ttl = MaxShareTTL
+	}
+	if maxPlays < 0 {
+		return nil, errors.New("max_plays must not be negative")
+	}
+	if len(password) > maxSharePasswordLen {
+		return nil, fmt.Errorf("password must be at most %d bytes", maxSharePasswordLen)
+	}
+
+	var title string
+	switch kind {
+	case ShareTrack:
+		id, err := strconv.Atoi(targetID)
+		if err != nil {
+			return nil, errors.New("invalid track id")
+		}
+		t, err := getTrackByID(id)
+		if err != nil {
+			return nil, err
+		}
+		targetID, title = strconv.Itoa(t.ID), t.Title
+		if t.Artist != "" {
+			title += " - " + t.Artist
+		}
+	case ShareAlbum:
+		id, err := strconv.Atoi(targetID)
+		if err != nil {
+			return nil, errors.New("invalid album id")
+		}
+		al, err := findAlbumByID(id)
+		if err != nil {
+			return nil, err
+		}
+		title = al.Name
+		if al.Artist != "" {
+			title += " - " + al.Artist
+		}
+	case ShareUpload:
+		mf, err := GetMusicFileByID(targetID, userUUID)
+		if err != nil {
+			return nil, err
+		}
+		title = mf.Title
+		if title == "" {
+			title = mf.FileName
+		}
+	default:
+		return nil, errors.New("type must be track, upload or album")
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	link := &ShareLink{
+		ID:        hex.EncodeToString(idBytes),
+		UserID:    userUUID,
+		Type:      kind,
+		TargetID:  targetID,
+		Title:     title,
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+		MaxPlays:  maxPlays,
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	if password != "" {
+		h, err := hashSharePassword(password)
+		if err != nil {
+			return nil, err
+		}
+		link.passwordHash, link.HasPassword = h, true
+	}
+
+	row := map[string]interface{}{
+		"id":            link.ID,
+		"user_id":       link.UserID,
+		"kind":          link.Type,
+		"target_id":     link.TargetID,
+		"title":         link.Title,
+		"expires_at":    link.ExpiresAt.UTC().Format(time.RFC3339),
+		"max_plays":     link.MaxPlays,
+		"play_count":    0,
+		"password_hash": link.passwordHash,
+		"created_at":    link.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if _, err := supabaseREST("POST", "share_links", row); err != nil {
+		return nil, err
+	}
+	var err error
+	if link.Token, err = signShareToken(link.ID, link.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// shareLinkFromRow 把数据库记录转换为 ShareLink，并重新计算令牌
+func shareLinkFromRow(row map[string]interface{}) ShareLink {
+	link := ShareLink{
+		ID:           getStringFromMap(row, "id", ""),
+		UserID:       getStringFromMap(row, "user_id", ""),
+		Type:         getStringFromMap(row, "kind", ""),
+		TargetID:     getStringFromMap(row, "target_id", ""),
+		Title:        getStringFromMap(row, "title", ""),
+		MaxPlays:     getIntFromMapUpload(row, "max_plays", 0),
+		PlayCount:    getIntFromMapUpload(row, "play_count", 0),
+		passwordHash: getStringFromMap(row, "password_hash", ""),
+	}
+	link.HasPassword = link.passwordHash != ""
+	link.ExpiresAt, _ = time.Parse(time.RFC3339, getStringFromMap(row, "expires_at", ""))
+	link.CreatedAt, _ = time.Parse(time.RFC3339, getStringFromMap(row, "created_at", ""))
+	link.Token, _ = signShareToken(link.ID, link.ExpiresAt)
+	return link
+}
+
+// ListShareLinks 返回用户创建的全部分享链接（含已过期的），按创建时间倒序
+func ListShareLinks(userUUID string) ([]ShareLink, error) {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return nil, errors.New("user not authenticated")
+	}
+	rows, err := supabaseREST("GET", "share_links?user_id=eq."+userUUID+"&order=created_at.desc", nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ShareLink, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, shareLinkFromRow(row))
+	}
+	return out, nil
+}
+
+// RevokeShareLink 删除用户自己的分享链接，之后该令牌立即失效
+func RevokeShareLink(userUUID, id string) error {
+	if !uploadIDPattern.MatchString(userUUID) || !uploadIDPattern.MatchString(id) {
+		return ErrShareNotFound
+	}
+	rows, err := supabaseREST("DELETE", "share_links?id=eq."+id+"&user_id=eq."+userUUID, nil)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// ResolveShareToken 校验令牌签名与有效期，并读取对应的分享记录（已撤销的记录不存在）
+func ResolveShareToken(token string) (*ShareLink, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !uploadIDPattern.MatchString(parts[0]) {
+		return nil, ErrShareNotFound
+	}
+	key, err := shareKey()
+	if err != nil {
+		return nil, err
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(shareMAC(key, payload)), []byte(parts[2])) {
+		return nil, ErrShareNotFound
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrShareNotFound
+	}
+	if time.Now().Unix() >= exp {
+		return nil, ErrShareExpired
+	}
+
+	rows, err := supabaseREST("GET", "share_links?id=eq."+parts[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrShareNotFound
+	}
+	link := shareLinkFromRow(rows[0])
+	return &link, nil
+}
+
+// CheckPassword 校验访问密码，未设密码时总是通过。兼容旧版 SHA-256 哈希
+func (l *ShareLink) CheckPassword(password string) bool {
+	if l.passwordHash == "" {
+		return true
+	}
+	if !isLegacySharePasswordHash(l.passwordHash) {
+		return bcrypt.CompareHashAndPassword([]byte(l.passwordHash), []byte(password)) == nil
+	}
+	salt, hash, ok := strings.Cut(l.passwordHash, "$")
+	if !ok {
+		return false
+	}
+	sum := sha256.Sum256([]byte(salt + password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(hash)) == 1
+}
+
+// Unlock 校验访问密码，通过时返回写入 Cookie 的值。同一链接的错误次数受 shareUnlockLimiter 限制，
+// 超出时返回 ErrShareUnlockThrottled；旧版哈希在校验通过后升级为 bcrypt
+func (l *ShareLink) Unlock(password string) (string, error) {
+	if !shareUnlockLimiter.Allow(l.ID) {
+		return "", ErrShareUnlockThrottled
+	}
+	if !l.CheckPassword(password) {
+		shareUnlockLimiter.Fail(l.ID)
+		return "", ErrSharePassword
+	}
+	shareUnlockLimiter.Reset(l.ID)
+	if l.passwordHash != "" && isLegacySharePasswordHash(l.passwordHash) {
+		if h, err := hashSharePassword(password); err == nil {
+			if _, err := supabaseREST("PATCH", "share_links?id=eq."+l.ID, map[string]interface{}{"password_hash": h}); err != nil {
+				log.Printf("升级分享链接密码哈希失败: %v", err)
+			} else {
+				l.passwordHash = h
+			}
+		}
+	}
+	return l.UnlockToken()
+}
+
+// UnlockToken 返回密码校验通过后写入 Cookie 的值，只对本链接有效
+func (l *ShareLink) UnlockToken() (string, error) {
+	key, err := shareKey()
+	if err != nil {
+		return "", err
+	}
+	return shareMAC(key, "unlock:"+l.ID+":"+l.passwordHash), nil
+}
+
+// Unlocked 判断访问者是否已通过密码校验：未设密码，或 Cookie 中的值与 UnlockToken 一致
+func (l *ShareLink) Unlocked(cookie string) bool {
+	if l.passwordHash == "" {
+		return true
+	}
+	want, err := l.UnlockToken()
+	return err == nil && hmac.Equal([]byte(want), []byte(cookie))
+}
+
+// PlayTicket 为分享中的一项（曲库曲目ID或上传文件ID）签发播放凭证，格式为 "<过期时间戳>.<签名>"。
+// 每签发一张凭证计一次播放，凭证有效期内的 Range、拖动与续传请求不再计数
+func (l *ShareLink) PlayTicket(item string) (string, error) {
+	key, err := shareKey()
+	if err != nil {
+		return "", err
+	}
+	exp := time.Now().Add(sharePlayTicketTTL)
+	if exp.After(l.ExpiresAt) {
+		exp = l.ExpiresAt
+	}
+	ts := strconv.FormatInt(exp.Unix(), 10)
+	return ts + "." + shareMAC(key, "play:"+l.ID+":"+item+":"+ts), nil
+}
+
+// CheckPlayTicket 校验播放凭证的签名、所属分享项与有效期
+func (l *ShareLink) CheckPlayTicket(item, ticket string) bool {
+	ts, sig, ok := strings.Cut(ticket, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return false
+	}
+	key, err := shareKey()
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(shareMAC(key, "play:"+l.ID+":"+item+":"+ts)), []byte(sig))
+}
+
+// CoversItem 判断分享项（曲库曲目ID或上传文件ID）是否在分享范围内
+func (l *ShareLink) CoversItem(item string) bool {
+	if l.Type == ShareUpload {
+		return l.TargetID == item
+	}
+	id, err := strconv.Atoi(item)
+	return err == nil && l.CoversTrack(id)
+}
+
+// CoversTrack 判断曲库曲目是否在分享范围内（单曲或专辑中的曲目）
+func (l *ShareLink) CoversTrack(id int) bool {
+	t, err := getTrackByID(id)
+	if err != nil {
+		return false
+	}
+	switch l.Type {
+	case ShareTrack:
+		return l.TargetID == strconv.Itoa(t.ID)
+	case ShareAlbum:
+		albumID, err := strconv.Atoi(l.TargetID)
+		if err != nil {
+			return false
+		}
+		list, err := ListAlbumTracksByID(albumID)
+		if err != nil {
+			return false
+		}
+		for _, at := range list {
+			if at.ID == t.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CountSharePlay 记录一次播放。以当前计数作为更新条件，并发请求只有一个能成功，失败的重新读取后重试
+func CountSharePlay(l *ShareLink) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		if l.MaxPlays > 0 && l.PlayCount >= l.MaxPlays {
+			return ErrSharePlayLimit
+		}
+		rows, err := supabaseREST("PATCH", fmt.Sprintf("share_links?id=eq.%s&play_count=eq.%d", l.ID, l.PlayCount),
+			map[string]interface{}{"play_count": l.PlayCount + 1})
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			l.PlayCount++
+			return nil
+		}
+		rows, err = supabaseREST("GET", "share_links?id=eq."+l.ID, nil)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return ErrShareNotFound
+		}
+		l.PlayCount = getIntFromMapUpload(rows[0], "play_count", 0)
+	}
+	return errors.New("share link is busy, please retry")
+}
@@ -0,0 +1,30 @@
+package service
+
+import "testing"
+
+func TestShareLinkCheckPassword(t *testing.T) {
+	h, err := hashSharePassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{"no password", "", "anything", true},
+		{"bcrypt match", h, "secret", true},
+		{"bcrypt mismatch", h, "Secret", false},
+		// salt "00"，sha256("00secret")
+		{"legacy match", "00$a0e09c1f5aa2633db2bcd07d44a8ff7b566249e7d71b4eed87fc93f8cbdf5cd5", "secret", true},
+		{"legacy mismatch", "00$a0e09c1f5aa2633db2bcd07d44a8ff7b566249e7d71b4eed87fc93f8cbdf5cd5", "secret2", false},
+		{"legacy malformed", "nodollar", "secret", false},
+	}
+	for _, tt := range tests {
+		l := &ShareLink{passwordHash: tt.hash}
+		if got := l.CheckPassword(tt.password); got != tt.want {
+			t.Errorf("%s: CheckPassword = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
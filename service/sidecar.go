@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 外部歌词与封面文件（sidecar）的查找规则与优先级：
+//
+//	歌词：与音频同名的 .lrc 文件 > 音频标签内嵌歌词。外部 .lrc 通常带时间戳，且是用户单独维护的，视为覆盖内嵌歌词。
+//	封面：音频标签内嵌封面 > 与音频同名的图片 > 目录封面（cover、folder、front、album、albumart，依次查找）。
+//
+// 目录封面对整个目录生效，只在曲目自身没有封面时使用。
+
+// coverFileNames 目录封面文件名（不含扩展名），按优先级排列
+var coverFileNames = []string{"cover", "folder", "front", "album", "albumart"}
+
+// coverImageTypes 可作为封面的图片扩展名及其 MIME 类型，按优先级排列
+var coverImageTypes = []struct{ ext, mime string }{
+	{".jpg", "image/jpeg"},
+	{".jpeg", "image/jpeg"},
+	{".png", "image/png"},
+	{".webp", "image/webp"},
+}
+
+// sidecarFiles 是某个音频文件对应的外部歌词与封面
+type sidecarFiles struct {
+	lyrics string
+	cover  string
+	sig    string // 文件名、修改时间与大小，任一变化时重新读取曲目
+}
+
+// sidecarCache 缓存扫描期间各目录的文件列表（小写文件名 -> 实际文件名），每个目录只列一次
+type sidecarCache map[string]map[string]string
+
+func (c sidecarCache) list(dir string) map[string]string {
+	if names, ok := c[dir]; ok {
+		return names
+	}
+	names := map[string]string{}
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() && isSidecarFile(e.Name()) {
+				names[strings.ToLower(e.Name())] = e.Name()
+			}
+		}
+	}
+	c[dir] = names
+	return names
+}
+
+// lookup 查找音频文件对应的外部歌词与封面，文件名不区分大小写
+func (c sidecarCache) lookup(audioPath string) sidecarFiles {
+	dir := filepath.Dir(audioPath)
+	names := c.list(dir)
+	base := strings.ToLower(strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath)))
+
+	var sc sidecarFiles
+	if name, ok := names[base+".lrc"]; ok {
+		sc.lyrics = filepath.Join(dir, name)
+	}
+	for _, stem := range append([]string{base}, coverFileNames...) {
+		if sc.cover != "" {
+			break
+		}
+		for _, it := range coverImageTypes {
+			if name, ok := names[stem+it.ext]; ok {
+				sc.cover = filepath.Join(dir, name)
+				break
+			}
+		}
+	}
+
+	var sig []string
+	for _, p := range []string{sc.lyrics, sc.cover} {
+		if p == "" {
+			continue
+		}
+		if info, err := os.Stat(p); err == nil {
+			sig = append(sig, fmt.Sprintf("%s:%d:%d", filepath.Base(p), info.ModTime().UnixNano(), info.Size()))
+		}
+	}
+	sc.sig = strings.Join(sig, "|")
+	return sc
+}
+
+// isSidecarFile 判断文件名是否可能是外部歌词或封面
+func isSidecarFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".lrc" {
+		return true
+	}
+	for _, it := range coverImageTypes {
+		if ext == it.ext {
+			return true
+		}
+	}
+	return false
+}
+
+// applySidecars 把外部歌词与封面记录到曲目上，HasLyrics / HasCover 同时反映内嵌和外部来源
+func applySidecars(t *Track, sc sidecarFiles) {
+	t.LyricsFile = sc.lyrics
+	t.CoverFile = sc.cover
+	t.Sidecars = sc.sig
+	// 内嵌封面优先，外部封面只在没有内嵌封面时计算哈希
+	if sc.cover != "" && t.CoverHash == "" {
+		if data, err := os.ReadFile(sc.cover); err == nil {
+			t.HasCover = true
+			t.CoverHash = contentHash(data)
+		}
+	}
+	if sc.lyrics != "" {
+		if l, err := readLyricsFile(sc.lyrics); err == nil && strings.TrimSpace(l) != "" {
+			t.HasLyrics = true
+			t.LyricsText = cleanLyrics(l)
+		}
+	}
+}
+
+// readLyricsFile 读取 .lrc 文件并转为 UTF-8：识别 UTF-8/UTF-16 BOM，非法 UTF-8 按 GBK 解码
+func readLyricsFile(p string) (string, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(data[3:]), nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false), nil
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true), nil
+	case utf8.Valid(data):
+		return string(data), nil
+	}
+	out, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data), nil
+	}
+	return string(out), nil
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		} else {
+			u = append(u, uint16(b[i+1])<<8|uint16(b[i]))
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+// readCoverFile 读取外部封面图片，按扩展名返回 MIME 类型
+func readCoverFile(p string) ([]byte, string, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, "", err
+	}
+	ext := strings.ToLower(filepath.Ext(p))
+	for _, it := range coverImageTypes {
+		if ext == it.ext {
+			return data, it.mime, nil
+		}
+	}
+	return data, "application/octet-stream", nil
+}
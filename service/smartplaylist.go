@@ -0,0 +1,775 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 智能歌单保存规则而不是曲目，每次读取时对当前曲库重新求值。
+// 规则是一棵条件树：叶子是单个条件 {field, op, value}，内部节点是条件组 {match: all|any, rules: [...]}。
+// 播放次数、最近播放、评分与收藏都取歌单所有者的数据。
+
+// 智能歌单规则的上限
+const (
+	maxSmartRuleDepth      = 5
+	maxSmartRuleConditions = 50
+)
+
+// SmartRule 是规则树的一个节点：Field 为空时是条件组，否则是单个条件
+type SmartRule struct {
+	Match string      `json:"match,omitempty"`
+	Rules []SmartRule `json:"rules,omitempty"`
+	Field string      `json:"field,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// SmartPlaylist 是保存的智能歌单定义
+type SmartPlaylist struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Public      bool      `json:"public"`
+	Rules       SmartRule `json:"rules"`
+	Sort        string    `json:"sort"`  // 逗号分隔的字段，前缀 - 表示降序；random 表示随机
+	Limit       int       `json:"limit"` // 0 表示不限，最多 MaxPlaylistItems
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type smartFieldKind int
+
+const (
+	smartString smartFieldKind = iota
+	smartNumber
+	smartDate
+	smartBool
+)
+
+// smartNeeds 标记求值需要读取的用户数据
+type smartNeeds int
+
+const (
+	needPlays smartNeeds = 1 << iota
+	needRatings
+	needFavorites
+)
+
+type smartField struct {
+	kind  smartFieldKind
+	needs smartNeeds
+}
+
+// smartFields 是可用于条件和排序的字段
+var smartFields = map[string]smartField{
+	"title":       {kind: smartString},
+	"artist":      {kind: smartString},
+	"album":       {kind: smartString},
+	"albumArtist": {kind: smartString},
+	"genre":       {kind: smartString},
+	"composer":    {kind: smartString},
+	"format":      {kind: smartString},
+	"codec":       {kind: smartString},
+	"root":        {kind: smartString},
+	"path":        {kind: smartString},
+	"year":        {kind: smartNumber},
+	"duration":    {kind: smartNumber},
+	"bitrate":     {kind: smartNumber},
+	"sampleRate":  {kind: smartNumber},
+	"bitDepth":    {kind: smartNumber},
+	"channels":    {kind: smartNumber},
+	"trackNumber": {kind: smartNumber},
+	"discNumber":  {kind: smartNumber},
+	"playCount":   {kind: smartNumber, needs: needPlays},
+	"rating":      {kind: smartNumber, needs: needRatings},
+	"addedAt":     {kind: smartDate},
+	"lastPlayed":  {kind: smartDate, needs: needPlays},
+	"favorite":    {kind: smartBool, needs: needFavorites},
+	"hasLyrics":   {kind: smartBool},
+	"hasCover":    {kind: smartBool},
+}
+
+// smartData 是求值时使用的用户数据，按需加载
+type smartData struct {
+	now       time.Time
+	plays     map[int]PlayStat
+	ratings   map[int]int
+	favorites map[int]bool
+}
+
+func (d *smartData) strings(t *Track, field string) []string {
+	switch field {
+	case "title":
+		return []string{t.Title}
+	case "artist":
+		return append([]string{t.Artist}, t.Artists...)
+	case "album":
+		return []string{t.Album}
+	case "albumArtist":
+		return []string{albumArtistOf(*t)}
+	case "genre":
+		return []string{t.Genre}
+	case "composer":
+		return []string{t.Composer}
+	case "format":
+		return []string{t.Format}
+	case "codec":
+		return []string{t.Codec}
+	case "root":
+		return []string{t.Root}
+	case "path":
+		return []string{t.RelPath}
+	}
+	return nil
+}
+
+func (d *smartData) number(t *Track, field string) float64 {
+	switch field {
+	case "year":
+		return float64(t.Year)
+	case "duration":
+		return t.Duration
+	case "bitrate":
+		return float64(t.Bitrate)
+	case "sampleRate":
+		return float64(t.SampleRate)
+	case "bitDepth":
+		return float64(t.BitDepth)
+	case "channels":
+		return float64(t.Channels)
+	case "trackNumber":
+		return float64(t.TrackNumber)
+	case "discNumber":
+		return float64(t.DiscNumber)
+	case "playCount":
+		return float64(d.plays[t.ID].Count)
+	case "rating":
+		return float64(d.ratings[t.ID])
+	}
+	return 0
+}
+
+// date 返回时间字段，没有值（例如从未播放）时为零值
+func (d *smartData) date(t *Track, field string) time.Time {
+	switch field {
+	case "addedAt":
+		if t.AddedAt > 0 {
+			return time.Unix(t.AddedAt, 0)
+		}
+	case "lastPlayed":
+		return d.plays[t.ID].LastPlay
+	}
+	return time.Time{}
+}
+
+func (d *smartData) boolean(t *Track, field string) bool {
+	switch field {
+	case "favorite":
+		return d.favorites[t.ID]
+	case "hasLyrics":
+		return t.HasLyrics
+	case "hasCover":
+		return t.HasCover
+	}
+	return false
+}
+
+type smartPredicate func(d *smartData, t *Track) bool
+
+// compileSmartRule 校验规则树并编译为判断函数，同时返回求值需要的用户数据
+func compileSmartRule(r SmartRule) (smartPredicate, smartNeeds, error) {
+	conditions := 0
+	return compileSmartNode(r, 1, &conditions)
+}
+
+func compileSmartNode(r SmartRule, depth int, conditions *int) (smartPredicate, smartNeeds, error) {
+	if r.Field != "" {
+		*conditions++
+		if *conditions > maxSmartRuleConditions {
+			return nil, 0, fmt.Errorf("a smart playlist can have at most %d conditions", maxSmartRuleConditions)
+		}
+		return compileSmartCondition(r)
+	}
+	if depth > maxSmartRuleDepth {
+		return nil, 0, fmt.Errorf("rule groups can be nested at most %d levels", maxSmartRuleDepth)
+	}
+	matchAny := false
+	switch r.Match {
+	case "", "all":
+	case "any":
+		matchAny = true
+	default:
+		return nil, 0, errors.New(`match must be "all" or "any"`)
+	}
+	var needs smartNeeds
+	preds := make([]smartPredicate, 0, len(r.Rules))
+	for _, child := range r.Rules {
+		p, n, err := compileSmartNode(child, depth+1, conditions)
+		if err != nil {
+			return nil, 0, err
+		}
+		preds = append(preds, p)
+		needs |= n
+	}
+	// 空条件组匹配全部曲目
+	if len(preds) == 0 {
+		return func(*smartData, *Track) bool { return true }, needs, nil
+	}
+	if matchAny {
+		return func(d *smartData, t *Track) bool {
+			for _, p := range preds {
+				if p(d, t) {
+					return true
+				}
+			}
+			return false
+		}, needs, nil
+	}
+	return func(d *smartData, t *Track) bool {
+		for _, p := range preds {
+			if !p(d, t) {
+				return false
+			}
+		}
+		return true
+	}, needs, nil
+}
+
+func compileSmartCondition(r SmartRule) (smartPredicate, smartNeeds, error) {
+	f, ok := smartFields[r.Field]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown field %q", r.Field)
+	}
+	field := r.Field
+	bad := func(want string) (smartPredicate, smartNeeds, error) {
+		return nil, 0, fmt.Errorf("%s %s: value must be %s", r.Field, r.Op, want)
+	}
+
+	switch f.kind {
+	case smartString:
+		var values []string
+		switch v := r.Value.(type) {
+		case string:
+			values = []string{normalizeText(v)}
+		case []interface{}:
+			if r.Op != "in" && r.Op != "notIn" {
+				return bad("a string")
+			}
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return bad("a list of strings")
+				}
+				values = append(values, normalizeText(s))
+			}
+		default:
+			return bad("a string")
+		}
+		var match func(s, v string) bool
+		negate := false
+		switch r.Op {
+		case "is", "in":
+			match = func(s, v string) bool { return s == v }
+		case "isNot", "notIn":
+			match, negate = func(s, v string) bool { return s == v }, true
+		case "contains":
+			match = strings.Contains
+		case "notContains":
+			match, negate = strings.Contains, true
+		case "startsWith":
+			match = strings.HasPrefix
+		case "endsWith":
+			match = strings.HasSuffix
+		default:
+			return nil, 0, fmt.Errorf("operator %q is not valid for %s", r.Op, r.Field)
+		}
+		return func(d *smartData, t *Track) bool {
+			for _, s := range d.strings(t, field) {
+				s = normalizeText(s)
+				for _, v := range values {
+					if match(s, v) {
+						return !negate
+					}
+				}
+			}
+			return negate
+		}, f.needs, nil
+
+	case smartNumber:
+		if r.Op == "between" {
+			lo, hi, ok := numberPair(r.Value)
+			if !ok {
+				return bad("[min, max]")
+			}
+			return func(d *smartData, t *Track) bool {
+				n := d.number(t, field)
+				return n >= lo && n <= hi
+			}, f.needs, nil
+		}
+		v, ok := r.Value.(float64)
+		if !ok {
+			return bad("a number")
+		}
+		var cmpFn func(n float64) bool
+		switch r.Op {
+		case "eq":
+			cmpFn = func(n float64) bool { return n == v }
+		case "ne":
+			cmpFn = func(n float64) bool { return n != v }
+		case "gt":
+			cmpFn = func(n float64) bool { return n > v }
+		case "gte":
+			cmpFn = func(n float64) bool { return n >= v }
+		case "lt":
+			cmpFn = func(n float64) bool { return n < v }
+		case "lte":
+			cmpFn = func(n float64) bool { return n <= v }
+		default:
+			return nil, 0, fmt.Errorf("operator %q is not valid for %s", r.Op, r.Field)
+		}
+		return func(d *smartData, t *Track) bool { return cmpFn(d.number(t, field)) }, f.needs, nil
+
+	case smartDate:
+		switch r.Op {
+		case "inLast", "notInLast":
+			days, ok := r.Value.(float64)
+			if !ok || days <= 0 {
+				return bad("a positive number of days")
+			}
+			window := time.Duration(days * float64(24*time.Hour))
+			within := r.Op == "inLast"
+			// 没有时间的曲目（例如从未播放）不在任何时间窗口内
+			return func(d *smartData, t *Track) bool {
+				at := d.date(t, field)
+				return (!at.IsZero() && d.now.Sub(at) <= window) == within
+			}, f.needs, nil
+		case "before", "after":
+			s, _ := r.Value.(string)
+			at, err := parseSmartDate(s)
+			if err != nil {
+				return bad("a date (YYYY-MM-DD or RFC 3339)")
+			}
+			before := r.Op == "before"
+			return func(d *smartData, t *Track) bool {
+				v := d.date(t, field)
+				if v.IsZero() {
+					return false
+				}
+				if before {
+					return v.Before(at)
+				}
+				return v.After(at)
+			}, f.needs, nil
+		}
+		return nil, 0, fmt.Errorf("operator %q is not valid for %s", r.Op, r.Field)
+
+	case smartBool:
+		if r.Op != "is" {
+			return nil, 0, fmt.Errorf("operator %q is not valid for %s", r.Op, r.Field)
+		}
+		v, ok := r.Value.(bool)
+		if !ok {
+			return bad("true or false")
+		}
+		return func(d *smartData, t *Track) bool { return d.boolean(t, field) == v }, f.needs, nil
+	}
+	return nil, 0, fmt.Errorf("unknown field %q", r.Field)
+}
+
+func numberPair(v interface{}) (float64, float64, bool) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) != 2 {
+		return 0, 0, false
+	}
+	lo, ok1 := list[0].(float64)
+	hi, ok2 := list[1].(float64)
+	return lo, hi, ok1 && ok2 && lo <= hi
+}
+
+func parseSmartDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// smartSortKey 是一个排序字段
+type smartSortKey struct {
+	field string
+	desc  bool
+}
+
+// parseSmartSort 解析排序说明，返回排序字段、是否随机以及需要的用户数据
+func parseSmartSort(s string) ([]smartSortKey, bool, smartNeeds, error) {
+	s = strings.TrimSpace(s)
+	if s == "random" {
+		return nil, true, 0, nil
+	}
+	var keys []smartSortKey
+	var needs smartNeeds
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k := smartSortKey{field: strings.TrimPrefix(part, "-"), desc: strings.HasPrefix(part, "-")}
+		f, ok := smartFields[k.field]
+		if !ok || f.kind == smartBool {
+			return nil, false, 0, fmt.Errorf("cannot sort by %q", k.field)
+		}
+		needs |= f.needs
+		keys = append(keys, k)
+	}
+	return keys, false, needs, nil
+}
+
+// smartQuery 是编译后的智能歌单定义
+type smartQuery struct {
+	match  smartPredicate
+	sort   []smartSortKey
+	random bool
+	limit  int
+	needs  smartNeeds
+}
+
+func compileSmartQuery(rules SmartRule, sort string, limit int) (*smartQuery, error) {
+	pred, needs, err := compileSmartRule(rules)
+	if err != nil {
+		return nil, err
+	}
+	keys, random, sortNeeds, err := parseSmartSort(sort)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 || limit > MaxPlaylistItems {
+		return nil, fmt.Errorf("limit must be between 0 and %d", MaxPlaylistItems)
+	}
+	if limit == 0 {
+		limit = MaxPlaylistItems
+	}
+	return &smartQuery{match: pred, sort: keys, random: random, limit: limit, needs: needs | sortNeeds}, nil
+}
+
+// SmartEvaluator 对同一用户的多个智能歌单求值，用户数据只读取一次
+type SmartEvaluator struct {
+	userUUID string
+	data     smartData
+	loaded   smartNeeds
+}
+
+// NewSmartEvaluator 创建以 userUUID 的播放历史、评分与收藏为数据的求值器
+func NewSmartEvaluator(userUUID string) *SmartEvaluator {
+	return &SmartEvaluator{userUUID: userUUID, data: smartData{now: time.Now()}}
+}
+
+func (e *SmartEvaluator) load(needs smartNeeds) error {
+	missing := needs &^ e.loaded
+	if missing&needPlays != 0 {
+		plays, err := GetPlayStats(e.userUUID)
+		if err != nil {
+			return err
+		}
+		e.data.plays = plays
+	}
+	if missing&needRatings != 0 {
+		ratings, err := GetUserRatings(e.userUUID)
+		if err != nil {
+			return err
+		}
+		e.data.ratings = ratings
+	}
+	if missing&needFavorites != 0 {
+		favs, err := GetUserFavorites(e.userUUID)
+		if err != nil {
+			return err
+		}
+		e.data.favorites = make(map[int]bool, len(favs))
+		for _, f := range favs {
+			if id, err := strconv.Atoi(f.SongID); err == nil {
+				e.data.favorites[id] = true
+			}
+		}
+	}
+	e.loaded |= missing
+	return nil
+}
+
+// Evaluate 按规则筛选当前曲库，排序后截取前 limit 首
+func (e *SmartEvaluator) Evaluate(rules SmartRule, sort string, limit int) ([]Track, error) {
+	q, err := compileSmartQuery(rules, sort, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.load(q.needs); err != nil {
+		return nil, err
+	}
+	list, err := ListTracks()
+	if err != nil {
+		return nil, err
+	}
+	out := list[:0]
+	for i := range list {
+		if q.match(&e.data, &list[i]) {
+			out = append(out, list[i])
+		}
+	}
+
+	switch {
+	case q.random:
+		rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	case len(q.sort) > 0:
+		d := &e.data
+		slices.SortStableFunc(out, func(a, b Track) int {
+			for _, k := range q.sort {
+				c := compareSmartField(d, &a, &b, k.field)
+				if k.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c
+				}
+			}
+			return 0
+		})
+	}
+	if len(out) > q.limit {
+		out = out[:q.limit]
+	}
+	return out, nil
+}
+
+func compareSmartField(d *smartData, a, b *Track, field string) int {
+	switch smartFields[field].kind {
+	case smartString:
+		return strings.Compare(normalizeText(d.strings(a, field)[0]), normalizeText(d.strings(b, field)[0]))
+	case smartNumber:
+		return cmp.Compare(d.number(a, field), d.number(b, field))
+	case smartDate:
+		return d.date(a, field).Compare(d.date(b, field))
+	}
+	return 0
+}
+
+// ---- 存储：smart_playlists 表 ----
+
+func smartPlaylistFromRow(row map[string]interface{}) SmartPlaylist {
+	p := SmartPlaylist{
+		ID:          getStringFromMap(row, "id", ""),
+		UserID:      getStringFromMap(row, "user_id", ""),
+		Name:        getStringFromMap(row, "name", ""),
+		Description: getStringFromMap(row, "description", ""),
+		Sort:        getStringFromMap(row, "sort_by", ""),
+		Limit:       getIntFromMapUpload(row, "limit_count", 0),
+	}
+	p.Public, _ = row["is_public"].(bool)
+	if raw, ok := row["rules"].(map[string]interface{}); ok {
+		p.Rules = smartRuleFromMap(raw)
+	}
+	p.CreatedAt, _ = time.Parse(time.RFC3339, getStringFromMap(row, "created_at", ""))
+	p.UpdatedAt, _ = time.Parse(time.RFC3339, getStringFromMap(row, "updated_at", ""))
+	return p
+}
+
+// smartRuleFromMap 把数据库中的 jsonb 还原为规则树
+func smartRuleFromMap(m map[string]interface{}) SmartRule {
+	r := SmartRule{
+		Match: getStringFromMap(m, "match", ""),
+		Field: getStringFromMap(m, "field", ""),
+		Op:    getStringFromMap(m, "op", ""),
+		Value: m["value"],
+	}
+	if list, ok := m["rules"].([]interface{}); ok {
+		for _, item := range list {
+			if child, ok := item.(map[string]interface{}); ok {
+				r.Rules = append(r.Rules, smartRuleFromMap(child))
+			}
+		}
+	}
+	return r
+}
+
+// SmartPlaylistInput 是创建或修改智能歌单的字段，修改时 nil 表示不变
+type SmartPlaylistInput struct {
+	Name        *string    `json:"name"`
+	Description *string    `json:"description"`
+	Public      *bool      `json:"public"`
+	Rules       *SmartRule `json:"rules"`
+	Sort        *string    `json:"sort"`
+	Limit       *int       `json:"limit"`
+}
+
+// smartPlaylistFields 校验输入并生成要写入的列；cur 为修改前的定义（创建时为空定义）
+func smartPlaylistFields(in SmartPlaylistInput, cur SmartPlaylist) (map[string]interface{}, error) {
+	fields := map[string]interface{}{"updated_at": time.Now().UTC().Format(time.RFC3339)}
+	if in.Name != nil {
+		name, err := validPlaylistName(*in.Name)
+		if err != nil {
+			return nil, err
+		}
+		fields["name"] = name
+	}
+	if in.Description != nil {
+		desc := strings.TrimSpace(*in.Description)
+		if utf8.RuneCountInString(desc) > 1000 {
+			return nil, errors.New("description too long")
+		}
+		fields["description"] = desc
+	}
+	if in.Public != nil {
+		fields["is_public"] = *in.Public
+	}
+	rules, sort, limit := cur.Rules, cur.Sort, cur.Limit
+	if in.Rules != nil {
+		rules = *in.Rules
+		fields["rules"] = rules
+	}
+	if in.Sort != nil {
+		sort = strings.TrimSpace(*in.Sort)
+		fields["sort_by"] = sort
+	}
+	if in.Limit != nil {
+		limit = *in.Limit
+		fields["limit_count"] = limit
+	}
+	if _, err := compileSmartQuery(rules, sort, limit); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// CreateSmartPlaylist 保存一个智能歌单定义
+func CreateSmartPlaylist(userUUID string, in SmartPlaylistInput) (*SmartPlaylist, error) {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return nil, errors.New("user not authenticated")
+	}
+	if in.Name == nil {
+		return nil, errors.New("playlist name required")
+	}
+	fields, err := smartPlaylistFields(in, SmartPlaylist{})
+	if err != nil {
+		return nil, err
+	}
+	fields["user_id"] = userUUID
+	fields["created_at"] = fields["updated_at"]
+	if _, ok := fields["rules"]; !ok {
+		fields["rules"] = SmartRule{}
+	}
+	rows, err := supabaseREST("POST", "smart_playlists", fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("failed to create smart playlist")
+	}
+	p := smartPlaylistFromRow(rows[0])
+	return &p, nil
+}
+
+// ListSmartPlaylists 返回用户自己的智能歌单，publicOnly 时只返回公开的
+func ListSmartPlaylists(userUUID string, publicOnly bool) ([]SmartPlaylist, error) {
+	if !uploadIDPattern.MatchString(userUUID) {
+		return nil, errors.New("invalid user id")
+	}
+	path := "smart_playlists?user_id=eq." + userUUID + "&order=updated_at.desc"
+	if publicOnly {
+		path += "&is_public=eq.true"
+	}
+	rows, err := supabaseREST("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SmartPlaylist, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, smartPlaylistFromRow(row))
+	}
+	return out, nil
+}
+
+// GetSmartPlaylist 读取智能歌单：所有者总能读取，其他人只能读取公开的
+func GetSmartPlaylist(id, viewerUUID string) (*SmartPlaylist, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, ErrPlaylistNotFound
+	}
+	rows, err := supabaseREST("GET", "smart_playlists?id=eq."+id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrPlaylistNotFound
+	}
+	p := smartPlaylistFromRow(rows[0])
+	if p.UserID != viewerUUID && !p.Public {
+		return nil, ErrPlaylistNotFound
+	}
+	return &p, nil
+}
+
+// UpdateSmartPlaylist 修改用户自己的智能歌单
+func UpdateSmartPlaylist(id, userUUID string, in SmartPlaylistInput) (*SmartPlaylist, error) {
+	cur, err := GetSmartPlaylist(id, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	if cur.UserID != userUUID {
+		return nil, ErrPlaylistNotFound
+	}
+	fields, err := smartPlaylistFields(in, *cur)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := supabaseREST("PATCH", "smart_playlists?id=eq."+id+"&user_id=eq."+userUUID, fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrPlaylistNotFound
+	}
+	p := smartPlaylistFromRow(rows[0])
+	return &p, nil
+}
+
+// DeleteSmartPlaylist 删除用户自己的智能歌单
+func DeleteSmartPlaylist(id, userUUID string) error {
+	if !uploadIDPattern.MatchString(id) || !uploadIDPattern.MatchString(userUUID) {
+		return ErrPlaylistNotFound
+	}
+	rows, err := supabaseREST("DELETE", "smart_playlists?id=eq."+id+"&user_id=eq."+userUUID, nil)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrPlaylistNotFound
+	}
+	return nil
+}
+
+// EvaluateSmartPlaylist 用所有者的数据对智能歌单求值
+func EvaluateSmartPlaylist(p *SmartPlaylist) ([]Track, error) {
+	return NewSmartEvaluator(p.UserID).Evaluate(p.Rules, p.Sort, p.Limit)
+}
+
+// SmartPlaylistEntries 把求值结果转换为与普通歌单相同的条目格式
+func SmartPlaylistEntries(list []Track) []PlaylistEntry {
+	out := make([]PlaylistEntry, 0, len(list))
+	for i, t := range list {
+		out = append(out, trackPlaylistEntry(i, t))
+	}
+	return out
+}
+
+// ExportSmartPlaylistEntries 生成智能歌单当前结果的导出记录
+func ExportSmartPlaylistEntries(list []Track, baseURL string) []PlaylistExportEntry {
+	out := make([]PlaylistExportEntry, 0, len(list))
+	for _, t := range list {
+		out = append(out, trackExportEntry(t, baseURL))
+	}
+	return out
+}
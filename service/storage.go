@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StorageObject 是存储桶中一个对象的只读视图，实现 io.ReadSeeker：
+// Seek 只移动位置，Read 时才按当前位置发起 Range 请求，
+// 因此可以直接交给 http.ServeContent 处理单段、多段 Range 与条件请求
+type StorageObject struct {
+	ctx     context.Context
+	path    string
+	size    int64
+	off     int64
+	body    io.ReadCloser
+	bodyOff int64 // body 下一次读取对应的对象偏移
+}
+
+// storageObjectURL 返回对象的下载地址（需要携带密钥访问，不对客户端公开）
+func storageObjectURL(storagePath string) string {
+	segs := strings.Split(storagePath, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return fmt.Sprintf("%s/storage/v1/object/music/%s", os.Getenv("SUPABASE_URL"), strings.Join(segs, "/"))
+}
+
+// OpenStorageObject 打开存储对象；size 未知（<= 0）时先请求第一个字节，从 Content-Range 得到大小
+func OpenStorageObject(ctx context.Context, storagePath string, size int64) (*StorageObject, error) {
+	o := &StorageObject{ctx: ctx, path: storagePath, size: size}
+	if size > 0 {
+		return o, nil
+	}
+	resp, err := o.get("bytes=0-0")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		cr := resp.Header.Get("Content-Range")
+		total, err := strconv.ParseInt(cr[strings.LastIndex(cr, "/")+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 Content-Range: %q", cr)
+		}
+		o.size = total
+	case http.StatusOK:
+		o.size = resp.ContentLength
+	default:
+		// 空对象对 bytes=0-0 返回 416
+		o.size = 0
+	}
+	return o, nil
+}
+
+// get 发起一次带 Range 的下载请求，非 2xx/416 状态视为错误
+func (o *StorageObject) get(rangeHeader string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(o.ctx, http.MethodGet, storageObjectURL(o.path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建下载请求失败: %v", err)
+	}
+	req.Header.Set("apikey", os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SUPABASE_ANON_KEY"))
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载请求失败: %v", err)
+	}
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return nil, fmt.Errorf("下载失败，状态码: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// Size 返回对象大小
+func (o *StorageObject) Size() int64 {
+	return o.size
+}
+
+func (o *StorageObject) Read(p []byte) (int, error) {
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil || o.bodyOff != o.off {
+		o.closeBody()
+		resp, err := o.get("bytes=" + strconv.FormatInt(o.off, 10) + "-")
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case resp.StatusCode == http.StatusPartialContent:
+		case resp.StatusCode == http.StatusOK && o.off == 0:
+		case resp.StatusCode == http.StatusOK:
+			// 存储不支持 Range 时丢弃前面的数据
+			if _, err := io.CopyN(io.Discard, resp.Body, o.off); err != nil {
+				resp.Body.Close()
+				return 0, err
+			}
+		default:
+			resp.Body.Close()
+			return 0, io.ErrUnexpectedEOF
+		}
+		o.body, o.bodyOff = resp.Body, o.off
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	o.bodyOff = o.off
+	if err == io.EOF && o.off < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *StorageObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	o.off = offset
+	return offset, nil
+}
+
+// Close 关闭正在进行的下载
+func (o *StorageObject) Close() error {
+	o.closeBody()
+	return nil
+}
+
+func (o *StorageObject) closeBody() {
+	if o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+}
+
+// OpenUserMusicFile 校验上传文件属于当前用户后打开其存储对象，每次请求都重新校验
+func OpenUserMusicFile(ctx context.Context, musicFileID, userUUID string) (*MusicFile, *StorageObject, error) {
+	if userUUID == "" {
+		return nil, nil, errors.New("user not authenticated")
+	}
+	mf, err := GetMusicFileByID(musicFileID, userUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := OpenStorageObject(ctx, mf.StoragePath, mf.FileSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mf, obj, nil
+}
+
+// MusicFileMIME 根据 FileType（扩展名，如 ".flac"；旧数据可能是 MIME 类型）返回播放用的 Content-Type
+func MusicFileMIME(mf *MusicFile) string {
+	if strings.Contains(mf.FileType, "/") {
+		return mf.FileType
+	}
+	ext := mf.FileType
+	if ext == "" {
+		ext = filepath.Ext(mf.FileName)
+	} else if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if format, ok := lookupAudioFormat(ext); ok {
+		return format.MIME
+	}
+	if t := mime.TypeByExtension(strings.ToLower(ext)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"MusicPlayerWeb/db"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// ErrSubsonicAuth 表示 Subsonic 用户名或密码（令牌）不正确
+var ErrSubsonicAuth = errors.New("wrong username or password")
+
+// subsonicAccount 是按用户名缓存的 Subsonic 账号信息，避免每个请求都查询 Supabase
+type subsonicAccount struct {
+	userID  string
+	secret  string // 解密后的 Subsonic 密码，未设置时为空
+	fetched time.Time
+}
+
+// subsonicAccountTTL Subsonic 账号缓存的有效期
+const subsonicAccountTTL = time.Minute
+
+var (
+	subsonicMu       sync.Mutex
+	subsonicAccounts = map[string]subsonicAccount{}
+)
+
+// AuthenticateSubsonic 校验 Subsonic 请求的凭据并返回对应的用户UUID。
+// 用户名为登录邮箱；token 认证（t = md5(密码+s)）与明文认证（p，可带 enc: 十六进制前缀）
+// 都只接受单独生成的 Subsonic 密码，不接受登录密码，避免通过该接口穷举账号密码
+func AuthenticateSubsonic(username, token, salt, password string) (string, error) {
+	if username == "" {
+		return "", ErrSubsonicAuth
+	}
+	acct, err := loadSubsonicAccount(username)
+	if err != nil {
+		return "", ErrSubsonicAuth
+	}
+
+	if token != "" {
+		if acct.secret == "" || salt == "" {
+			return "", ErrSubsonicAuth
+		}
+		sum := md5.Sum([]byte(acct.secret + salt))
+		expected := hex.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(token))) != 1 {
+			return "", ErrSubsonicAuth
+		}
+		return acct.userID, nil
+	}
+
+	if strings.HasPrefix(password, "enc:") {
+		b, err := hex.DecodeString(strings.TrimPrefix(password, "enc:"))
+		if err != nil {
+			return "", ErrSubsonicAuth
+		}
+		password = string(b)
+	}
+	if password == "" || acct.secret == "" {
+		return "", ErrSubsonicAuth
+	}
+	if subtle.ConstantTimeCompare([]byte(acct.secret), []byte(password)) != 1 {
+		return "", ErrSubsonicAuth
+	}
+	return acct.userID, nil
+}
+
+// loadSubsonicAccount 按邮箱读取用户资料中的用户ID与加密保存的 Subsonic 密码
+func loadSubsonicAccount(username string) (subsonicAccount, error) {
+	subsonicMu.Lock()
+	acct, ok := subsonicAccounts[username]
+	subsonicMu.Unlock()
+	if ok && time.Since(acct.fetched) < subsonicAccountTTL {
+		return acct, nil
+	}
+
+	profile, err := db.GetUserProfileByEmail(url.QueryEscape(username))
+	if err != nil {
+		return subsonicAccount{}, err
+	}
+	acct = subsonicAccount{userID: getStringFromMap(profile, "user_id", ""), fetched: time.Now()}
+	if acct.userID == "" {
+		return subsonicAccount{}, errors.New("user not found")
+	}
+	if enc := getStringFromMap(profile, "subsonic_password", ""); enc != "" {
+		if acct.secret, err = decryptSubsonicSecret(enc); err != nil {
+			return subsonicAccount{}, err
+		}
+	}
+
+	subsonicMu.Lock()
+	subsonicAccounts[username] = acct
+	subsonicMu.Unlock()
+	return acct, nil
+}
+
+// GetSubsonicCredentials 返回用户在 Subsonic 客户端中使用的用户名，以及是否已经生成过密码。
+// 密码只在 ResetSubsonicPassword 生成时返回一次
+func GetSubsonicCredentials(userUUID string) (string, bool, error) {
+	profile, err := fetchSubsonicProfile(userUUID)
+	if err != nil {
+		return "", false, err
+	}
+	return getStringFromMap(profile, "email", ""), getStringFromMap(profile, "subsonic_password", "") != "", nil
+}
+
+// ResetSubsonicPassword 为用户生成新的 Subsonic 密码并加密保存，旧密码立即失效
+func ResetSubsonicPassword(userUUID string) (string, string, error) {
+	profile, err := fetchSubsonicProfile(userUUID)
+	if err != nil {
+		return "", "", err
+	}
+	username := getStringFromMap(profile, "email", "")
+
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(raw)
+	enc, err := encryptSubsonicSecret(password)
+	if err != nil {
+		return "", "", err
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"subsonic_password": enc,
+		"updated_at":        time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("序列化数据失败: %v", err)
+	}
+	reqURL := fmt.Sprintf("%s/rest/v1/user_profiles?user_id=eq.%s", os.Getenv("SUPABASE_URL"), url.QueryEscape(userUUID))
+	req, err := http.NewRequest("PATCH", reqURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", "", fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("apikey", os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return "", "", fmt.Errorf("API 返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	subsonicMu.Lock()
+	delete(subsonicAccounts, username)
+	subsonicMu.Unlock()
+	return username, password, nil
+}
+
+// fetchSubsonicProfile 按用户UUID读取邮箱与加密的 Subsonic 密码
+func fetchSubsonicProfile(userUUID string) (map[string]interface{}, error) {
+	if err := db.Init(); err != nil {
+		return nil, fmt.Errorf("数据库连接失败: %v", err)
+	}
+	reqURL := fmt.Sprintf("%s/rest/v1/user_profiles?user_id=eq.%s&select=email,subsonic_password",
+		os.Getenv("SUPABASE_URL"), url.QueryEscape(userUUID))
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("apikey", os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SUPABASE_ANON_KEY"))
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API 返回错误状态码: %d", resp.StatusCode)
+	}
+	var result []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("用户不存在")
+	}
+	return result[0], nil
+}
+
+// subsonicCipher 返回加密 Subsonic 密码的 AES-GCM，密钥来自 SUBSONIC_SECRET_KEY 或数据目录下的 subsonic.key。
+// token 认证要求服务端能还原明文密码，因此只能加密保存而不能哈希
+func subsonicCipher() (cipher.AEAD, error) {
+	key, err := loadSecretKey("SUBSONIC_SECRET_KEY", "subsonic.key")
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSubsonicSecret 用 AES-GCM 加密，输出 base64(nonce+密文)
+func encryptSubsonicSecret(plain string) (string, error) {
+	gcm, err := subsonicCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+func decryptSubsonicSecret(enc string) (string, error) {
+	gcm, err := subsonicCipher()
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(b) < gcm.NonceSize() {
+		return "", errors.New("invalid subsonic password")
+	}
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("invalid subsonic password")
+	}
+	return string(plain), nil
+}
+
+// IndexLetter 返回歌手名在字母索引中的分组：英文取首字母，中文取拼音首字母，其余归入 "#"
+func IndexLetter(name string) string {
+	for _, r := range strings.TrimSpace(name) {
+		if unicode.Is(unicode.Han, r) {
+			if py := pinyin.SinglePinyin(r, pinyinArgs); len(py) > 0 && py[0] != "" {
+				return strings.ToUpper(py[0][:1])
+			}
+			return "#"
+		}
+		r = unicode.ToUpper(r)
+		if r >= 'A' && r <= 'Z' {
+			return string(r)
+		}
+		return "#"
+	}
+	return "#"
+}
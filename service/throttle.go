@@ -0,0 +1,62 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// failureLimiter 按键统计一个时间窗口内的失败次数，达到上限后在窗口结束前拒绝继续尝试，
+// 用于限制密码类接口被暴力猜测
+type failureLimiter struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	fails  map[string]failureWindow
+}
+
+type failureWindow struct {
+	count int
+	start time.Time
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{max: max, window: window, fails: map[string]failureWindow{}}
+}
+
+// Allow 判断该键当前是否还能尝试
+func (l *failureLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.fails[key]
+	if !ok || time.Since(f.start) >= l.window {
+		return true
+	}
+	return f.count < l.max
+}
+
+// Fail 记录一次失败。窗口从第一次失败开始计算
+func (l *failureLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.fails) >= 1024 {
+		for k, f := range l.fails {
+			if now.Sub(f.start) >= l.window {
+				delete(l.fails, k)
+			}
+		}
+	}
+	f, ok := l.fails[key]
+	if !ok || now.Sub(f.start) >= l.window {
+		f = failureWindow{start: now}
+	}
+	f.count++
+	l.fails[key] = f
+}
+
+// Reset 在校验成功后清除该键的失败记录
+func (l *failureLimiter) Reset(key string) {
+	l.mu.Lock()
+	delete(l.fails, key)
+	l.mu.Unlock()
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailureLimiter(t *testing.T) {
+	l := newFailureLimiter(3, time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("attempt %d rejected before reaching the limit", i+1)
+		}
+		l.Fail("a")
+	}
+	if l.Allow("a") {
+		t.Error("Allow after 3 failures = true, want false")
+	}
+	if !l.Allow("b") {
+		t.Error("other keys must not be affected")
+	}
+	l.Reset("a")
+	if !l.Allow("a") {
+		t.Error("Allow after Reset = false, want true")
+	}
+
+	expired := newFailureLimiter(1, time.Millisecond)
+	expired.Fail("a")
+	time.Sleep(2 * time.Millisecond)
+	if !expired.Allow("a") {
+		t.Error("Allow after the window passed = false, want true")
+	}
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stableTrackID 根据曲目相对音乐目录的路径生成稳定ID，同一文件在重扫后ID不变。
+func stableTrackID(rel string, used map[int]bool) int {
+	return stableID(filepath.ToSlash(rel), used)
+}
+
+// maxStableID 是稳定ID的上限：53 位，保证在 JavaScript 的 Number 中精确表示
+const maxStableID = 1<<53 - 1
+
+// stableID 对键做 64 位 FNV 哈希并截取低 53 位得到正整数ID。
+// 3 万首曲目发生冲突的概率约为 5e-8；万一冲突时追加序号重新计算，保证 used 范围内ID唯一
+func stableID(key string, used map[int]bool) int {
+	for n := 0; ; n++ {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		if n > 0 {
+			h.Write([]byte("#" + strconv.Itoa(n)))
+		}
+		id := int(h.Sum64() & maxStableID)
+		if !used[id] {
+			used[id] = true
+			return id
+		}
+	}
+}
+
+// normalizeName 归一化歌手/专辑名，用于聚合和生成ID：合并空白并忽略大小写
+func normalizeName(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// artistKey 与 albumKey 是生成歌手、专辑稳定ID时使用的键
+func artistKey(name string) string {
+	return "artist:" + normalizeName(name)
+}
+
+func albumKey(album, albumArtist string) string {
+	return "album:" + normalizeName(album) + "\x00" + normalizeName(albumArtist)
+}
+
+// albumArtistOf 返回曲目所属专辑的艺人：优先使用专辑艺人标签，缺失时使用曲目署名
+func albumArtistOf(t Track) string {
+	if t.AlbumArtist != "" {
+		return t.AlbumArtist
+	}
+	return strings.TrimSpace(t.Artist)
+}
+
+// legacySnapshot 是旧版顺序ID到稳定ID的映射快照。升级后第一次扫描时按遍历顺序生成并写入数据目录，
+// 之后不再随文件增删重新计算，保证旧ID始终对应升级前的那首曲目
+type legacySnapshot struct {
+	TakenAt time.Time   `json:"taken_at"`
+	IDs     map[int]int `json:"ids"`
+}
+
+func legacySnapshotPath() string {
+	return filepath.Join(dataDir, "legacy_track_ids.json")
+}
+
+// loadLegacySnapshot 读取快照，不存在时返回 nil
+func loadLegacySnapshot() *legacySnapshot {
+	data, err := os.ReadFile(legacySnapshotPath())
+	if err != nil {
+		return nil
+	}
+	var snap legacySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil || snap.IDs == nil {
+		return nil
+	}
+	return &snap
+}
+
+// saveLegacySnapshot 写入快照，已存在时不覆盖
+func saveLegacySnapshot(ids map[int]int, takenAt time.Time) error {
+	data, err := json.Marshal(legacySnapshot{TakenAt: takenAt, IDs: ids})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(legacySnapshotPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return f.Close()
+}
+
+// LegacyTrackIDMap 返回旧版顺序ID到稳定ID的映射
+func LegacyTrackIDMap() (map[int]int, error) {
+	if err := InitMusicCache(); err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make(map[int]int, len(legacyIDs))
+	for k, v := range legacyIDs {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// TrackIDMigrationResult 记录迁移旧曲目ID时每张表更新的行数
+type TrackIDMigrationResult struct {
+	Favorites  int       `json:"favorites"`
+	Comments   int       `json:"comments"`
+	MigratedAt time.Time `json:"migrated_at"`
+}
+
+// ErrTrackIDsMigrated 表示旧曲目ID已经迁移过，不能再次执行
+var ErrTrackIDsMigrated = errors.New("legacy track ids have already been migrated")
+
+// migrateMu 防止迁移被并发执行
+var migrateMu sync.Mutex
+
+// 迁移阶段：stage 把旧ID改写为新ID（与旧ID冲突的先改写为临时ID），finalize 把临时ID换成新ID
+const (
+	migrationStage    = "stage"
+	migrationFinalize = "finalize"
+	migrationDone     = "done"
+)
+
+// trackIDMigrationState 是迁移进度，每完成一步写入数据目录，中途失败后重新执行会从断点继续
+type trackIDMigrationState struct {
+	Phase string `json:"phase"`
+	TrackIDMigrationResult
+}
+
+func trackIDMigrationPath() string {
+	return filepath.Join(dataDir, "track_id_migration.json")
+}
+
+func loadTrackIDMigrationState() (trackIDMigrationState, error) {
+	var state trackIDMigrationState
+	data, err := os.ReadFile(trackIDMigrationPath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("读取迁移进度失败: %v", err)
+	}
+	return state, nil
+}
+
+func saveTrackIDMigrationState(state trackIDMigrationState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(trackIDMigrationPath(), data)
+}
+
+// MigrateLegacyTrackIDs 把 user_favorites 与 song_comments 中的旧顺序ID改写为稳定ID，只能成功执行一次。
+// 只改写快照生成之前创建的记录，之后的记录已经使用稳定ID。
+// 新ID恰好也是某个旧ID时先改写为负数的临时ID，全部改完后再换成新ID，避免同一行被连续改写两次。
+//
+// 每一步完成后都记录进度，失败后可以重新执行：stage 阶段结束前表中值为旧ID的记录一定还未迁移
+// （新ID只有在不与任何旧ID冲突时才直接写入），重做已完成的步骤不会再匹配到记录；
+// finalize 阶段只按临时ID匹配，同样可以重做。更新成功但响应丢失的行不会计入结果中的行数
+func MigrateLegacyTrackIDs() (*TrackIDMigrationResult, error) {
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	state, err := loadTrackIDMigrationState()
+	if err != nil {
+		return nil, err
+	}
+	// 旧版本只在完成后写入结果，没有 phase 字段
+	if state.Phase == migrationDone || state.Phase == "" && !state.MigratedAt.IsZero() {
+		return nil, ErrTrackIDsMigrated
+	}
+	if err := InitMusicCache(); err != nil {
+		return nil, err
+	}
+	snap := loadLegacySnapshot()
+	if snap == nil {
+		return nil, errors.New("legacy track id snapshot not found, wait for the first library scan to finish")
+	}
+
+	oldIDs := make([]int, 0, len(snap.IDs))
+	for oldID := range snap.IDs {
+		oldIDs = append(oldIDs, oldID)
+	}
+	sort.Ints(oldIDs)
+	before := "&created_at=lt." + url.QueryEscape(snap.TakenAt.UTC().Format(time.RFC3339))
+	result := &state.TrackIDMigrationResult
+
+	patch := func(filter string, newID int) error {
+		n, err := patchSongID("user_favorites", filter, newID)
+		if err != nil {
+			return err
+		}
+		result.Favorites += n
+		if err := saveTrackIDMigrationState(state); err != nil {
+			return err
+		}
+		n, err = patchSongID("song_comments", filter, newID)
+		if err != nil {
+			return err
+		}
+		result.Comments += n
+		return saveTrackIDMigrationState(state)
+	}
+
+	var staged []int
+	stage := state.Phase != migrationFinalize
+	for _, oldID := range oldIDs {
+		newID := snap.IDs[oldID]
+		if oldID == newID {
+			continue
+		}
+		target := newID
+		if _, clash := snap.IDs[newID]; clash {
+			target = -newID - 1
+			staged = append(staged, newID)
+		}
+		if !stage {
+			continue
+		}
+		state.Phase = migrationStage
+		if err := patch(fmt.Sprintf("song_id=eq.%d%s", oldID, before), target); err != nil {
+			return result, err
+		}
+	}
+
+	state.Phase = migrationFinalize
+	if err := saveTrackIDMigrationState(state); err != nil {
+		return result, err
+	}
+	for _, newID := range staged {
+		// 临时ID只由本次迁移写入，不再按创建时间过滤；两张表的计数已在 stage 阶段记入
+		if _, err := patchSongID("user_favorites", fmt.Sprintf("song_id=eq.%d", -newID-1), newID); err != nil {
+			return result, err
+		}
+		if _, err := patchSongID("song_comments", fmt.Sprintf("song_id=eq.%d", -newID-1), newID); err != nil {
+			return result, err
+		}
+	}
+
+	state.Phase = migrationDone
+	result.MigratedAt = time.Now().UTC()
+	if err := saveTrackIDMigrationState(state); err != nil {
+		return result, fmt.Errorf("迁移已完成，但写入完成标记失败: %v", err)
+	}
+	return result, nil
+}
+
+// patchSongID 将指定表中符合 filter 的记录的 song_id 更新为新ID，返回更新行数
+func patchSongID(table, filter string, newID int) (int, error) {
+	httpClient := &http.Client{}
+	reqURL := fmt.Sprintf("%s/rest/v1/%s?%s", os.Getenv("SUPABASE_URL"), table, filter)
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"song_id": strconv.Itoa(newID),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	req, err := http.NewRequest("PATCH", reqURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %v", err)
+	}
+
+	req.Header.Set("apikey", os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SUPABASE_ANON_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// 表不存在，视为没有需要迁移的记录
+		return 0, nil
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("更新 %s 失败，状态码: %d, 响应: %s", table, resp.StatusCode, string(body))
+	}
+
+	var rows []map[string]interface{}
+	_ = json.NewDecoder(resp.Body).Decode(&rows)
+	return len(rows), nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStableID(t *testing.T) {
+	used := map[int]bool{}
+	a := stableID("root/a.flac", used)
+	if a <= 0 || a > maxStableID {
+		t.Fatalf("stableID = %d, want within (0, 2^53)", a)
+	}
+	if b := stableID("root/a.flac", map[int]bool{}); b != a {
+		t.Errorf("stableID is not deterministic: %d != %d", b, a)
+	}
+	// 已被占用时追加序号重新计算
+	if c := stableID("root/a.flac", used); c == a {
+		t.Errorf("stableID returned an id already in use")
+	}
+}
+
+// fakeSongTable 模拟 PostgREST 中带 song_id 与 created_at 的表，只支持本迁移用到的 PATCH 过滤
+type fakeSongTable struct {
+	mu   sync.Mutex
+	rows map[string][]fakeSongRow
+	// failAt 为第几个请求失败（从 1 开始，0 表示不失败）；applyBeforeFail 为 true 时先执行更新再返回错误
+	failAt          int
+	applyBeforeFail bool
+	requests        int
+}
+
+type fakeSongRow struct {
+	SongID    string
+	CreatedAt time.Time
+}
+
+func (f *fakeSongTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	fail := f.requests == f.failAt
+	if fail && !f.applyBeforeFail {
+		http.Error(w, "injected failure", http.StatusInternalServerError)
+		return
+	}
+	table := strings.TrimPrefix(r.URL.Path, "/rest/v1/")
+	var body struct {
+		SongID string `json:"song_id"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	q := r.URL.Query()
+	songID := strings.TrimPrefix(q.Get("song_id"), "eq.")
+	var before time.Time
+	if lt := q.Get("created_at"); lt != "" {
+		before, _ = time.Parse(time.RFC3339, strings.TrimPrefix(lt, "lt."))
+	}
+	var updated []map[string]string
+	for i, row := range f.rows[table] {
+		if row.SongID != songID || !before.IsZero() && !row.CreatedAt.Before(before) {
+			continue
+		}
+		f.rows[table][i].SongID = body.SongID
+		updated = append(updated, map[string]string{"song_id": body.SongID})
+	}
+	if fail {
+		http.Error(w, "injected failure", http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(updated)
+}
+
+func TestMigrateLegacyTrackIDsResumes(t *testing.T) {
+	taken := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	old, recent := taken.Add(-time.Hour), taken.Add(time.Hour)
+	// 旧ID 0 的新ID恰好是旧ID 1，需要经过临时ID；旧ID 2 不变
+	ids := map[int]int{0: 1, 1: 9, 2: 2}
+	newRows := func() []fakeSongRow {
+		return []fakeSongRow{{"0", old}, {"1", old}, {"2", old}, {"1", recent}}
+	}
+	want := []string{"1", "9", "2", "1"}
+
+	prevCache := cacheReady.Load()
+	cacheReady.Store(true)
+	defer cacheReady.Store(prevCache)
+
+	for _, applyBeforeFail := range []bool{false, true} {
+		for failAt := 0; failAt <= 8; failAt++ {
+			prevDir := dataDir
+			dataDir = t.TempDir()
+			if err := saveLegacySnapshot(ids, taken); err != nil {
+				t.Fatal(err)
+			}
+			fake := &fakeSongTable{
+				rows:            map[string][]fakeSongRow{"user_favorites": newRows(), "song_comments": newRows()},
+				failAt:          failAt,
+				applyBeforeFail: applyBeforeFail,
+			}
+			srv := httptest.NewServer(fake)
+			t.Setenv("SUPABASE_URL", srv.URL)
+
+			var result *TrackIDMigrationResult
+			var err error
+			for attempt := 0; attempt < 3; attempt++ {
+				if result, err = MigrateLegacyTrackIDs(); err == nil {
+					break
+				}
+			}
+			srv.Close()
+			dataDir = prevDir
+			if err != nil {
+				t.Fatalf("failAt=%d applyBeforeFail=%v: migration did not finish: %v", failAt, applyBeforeFail, err)
+			}
+			for table, rows := range fake.rows {
+				for i, row := range rows {
+					if row.SongID != want[i] {
+						t.Errorf("failAt=%d applyBeforeFail=%v: %s row %d song_id = %s, want %s",
+							failAt, applyBeforeFail, table, i, row.SongID, want[i])
+					}
+				}
+			}
+			// 先更新再报错时丢失了响应，行数无法统计
+			if !applyBeforeFail && (result.Favorites != 2 || result.Comments != 2) {
+				t.Errorf("failAt=%d applyBeforeFail=%v: result = %+v, want 2 favorites and 2 comments",
+					failAt, applyBeforeFail, *result)
+			}
+		}
+	}
+
+	dataDir = t.TempDir()
+	defer func(d string) { dataDir = d }(dataDir)
+	if err := saveTrackIDMigrationState(trackIDMigrationState{Phase: migrationDone}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateLegacyTrackIDs(); err != ErrTrackIDsMigrated {
+		t.Errorf("second migration error = %v, want ErrTrackIDsMigrated", err)
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TranscodeProfile 描述一种转码输出：编码器参数、容器格式与 MIME 类型
+type TranscodeProfile struct {
+	Name    string `json:"name"`
+	Codec   string `json:"codec"`
+	Bitrate int    `json:"bitrate"` // kbps
+	MIME    string `json:"mime"`
+	ext     string
+	format  string // ffmpeg -f 参数
+	encoder string // ffmpeg -c:a 参数
+	extra   []string
+}
+
+// transcodeProfiles 是可用的转码档位，key 为 /api/audio?profile= 的取值
+var transcodeProfiles = map[string]TranscodeProfile{
+	"opus-96": {Name: "opus-96", Codec: "opus", Bitrate: 96, MIME: "audio/ogg; codecs=opus", ext: ".opus", format: "ogg", encoder: "libopus"},
+	"aac-160": {Name: "aac-160", Codec: "aac", Bitrate: 160, MIME: "audio/mp4", ext: ".m4a", format: "ipod", encoder: "aac", extra: []string{"-movflags", "+faststart"}},
+	"mp3-320": {Name: "mp3-320", Codec: "mp3", Bitrate: 320, MIME: "audio/mpeg", ext: ".mp3", format: "mp3", encoder: "libmp3lame"},
+}
+
+var (
+	// ErrTranscodeUnavailable 表示没有配置 ffmpeg，无法转码
+	ErrTranscodeUnavailable = errors.New("transcoding is not available: ffmpeg not configured")
+	// ErrUnknownProfile 表示请求了不存在的转码档位
+	ErrUnknownProfile = errors.New("unknown transcode profile")
+)
+
+var (
+	// ffmpegPath 由 FFMPEG_PATH 指定，未设置时在 PATH 中查找
+	ffmpegPath = findFFmpeg()
+
+	// transcodeSlots 限制同时运行的 ffmpeg 进程数，可通过 TRANSCODE_MAX_JOBS 设置
+	transcodeSlots = make(chan struct{}, envInt("TRANSCODE_MAX_JOBS", 2))
+
+	// transcodeJobs 合并对同一输出文件的并发请求，只启动一个 ffmpeg
+	transcodeMu   sync.Mutex
+	transcodeJobs = map[string]*transcodeJob{}
+)
+
+// transcodeTimeout 单个转码任务的最长运行时间
+const transcodeTimeout = 10 * time.Minute
+
+type transcodeJob struct {
+	done chan struct{}
+	err  error
+}
+
+func findFFmpeg() string {
+	if p := os.Getenv("FFMPEG_PATH"); p != "" {
+		return p
+	}
+	if p, err := exec.LookPath("ffmpeg"); err == nil {
+		return p
+	}
+	return ""
+}
+
+// envInt 读取正整数环境变量，未设置或非法时返回默认值
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// TranscodeAvailable 返回服务端是否可以转码
+func TranscodeAvailable() bool {
+	return ffmpegPath != ""
+}
+
+// ListTranscodeProfiles 返回按名称排序的转码档位
+func ListTranscodeProfiles() []TranscodeProfile {
+	out := make([]TranscodeProfile, 0, len(transcodeProfiles))
+	for _, p := range transcodeProfiles {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func transcodeCacheDir() string {
+	return filepath.Join(dataDir, "transcode")
+}
+
+// cacheTouchInterval 刷新缓存文件修改时间的最小间隔。修改时间用于按最近使用清理缓存，
+// 不必每次都更新；修改时间不变，按（路径、修改时间、大小）缓存的 ETag 也能一直命中
+const cacheTouchInterval = time.Hour
+
+// touchCacheFile 在修改时间早于 cacheTouchInterval 时刷新，清理缓存时最近使用的文件最后删除
+func touchCacheFile(p string) {
+	info, err := os.Stat(p)
+	if err != nil || time.Since(info.ModTime()) < cacheTouchInterval {
+		return
+	}
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+}
+
+// ReadTranscodedAudio 返回曲目按指定档位转码后的音频文件。
+// 转码结果缓存在数据目录下，源文件修改后自动失效；返回的是完整文件，可直接按 Range 定位。
+// 源文件本身已是同编码且码率不高于目标时直接返回原文件
+func ReadTranscodedAudio(ctx context.Context, id int, profile string) (*os.File, string, error) {
+	p, ok := transcodeProfiles[profile]
+	if !ok {
+		return nil, "", ErrUnknownProfile
+	}
+	t, err := getTrackByID(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if sameCodec(t, p) && t.Bitrate > 0 && t.Bitrate <= p.Bitrate {
+		f, err := os.Open(t.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		format, _ := lookupAudioFormat(t.Path)
+		return f, format.MIME, nil
+	}
+	out := filepath.Join(transcodeCacheDir(), fmt.Sprintf("%d_%d_%d_%s%s", t.ID, t.ModTime, t.Size, p.Name, p.ext))
+	if f, err := os.Open(out); err == nil {
+		touchCacheFile(out)
+		return f, p.MIME, nil
+	}
+	if !TranscodeAvailable() {
+		return nil, "", ErrTranscodeUnavailable
+	}
+	if err := runTranscode(ctx, t.Path, out, p); err != nil {
+		return nil, "", err
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, p.MIME, nil
+}
+
+func sameCodec(t Track, p TranscodeProfile) bool {
+	return strings.EqualFold(t.Codec, p.Codec) || (p.Codec == "mp3" && t.Format == "mp3")
+}
+
+// runTranscode 启动（或等待已在进行的）转码任务，ctx 取消只会停止等待，不会中断其他请求共用的任务
+func runTranscode(ctx context.Context, src, out string, p TranscodeProfile) error {
+	return runFFmpegOnce(ctx, out, func(tmp string) []string {
+		args := []string{"-i", src, "-map", "0:a:0", "-vn",
+			"-c:a", p.encoder, "-b:a", strconv.Itoa(p.Bitrate) + "k"}
+		args = append(args, p.extra...)
+		return append(args, "-f", p.format, tmp)
+	})
+}
+
+// runFFmpegOnce 生成 out 文件：同一输出的并发请求共用一个 ffmpeg 进程，
+// args 根据临时输出路径返回 ffmpeg 的参数（不含全局选项）
+func runFFmpegOnce(ctx context.Context, out string, args func(tmp string) []string) error {
+	job := startFFmpegJob(out, args)
+	select {
+	case <-job.done:
+		return job.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startFFmpegJob 返回生成 out 的任务，没有进行中的任务时在后台启动一个，不等待其完成
+func startFFmpegJob(out string, args func(tmp string) []string) *transcodeJob {
+	transcodeMu.Lock()
+	defer transcodeMu.Unlock()
+	job, running := transcodeJobs[out]
+	if !running {
+		job = &transcodeJob{done: make(chan struct{})}
+		transcodeJobs[out] = job
+		go func() {
+			job.err = ffmpegToFile(out, args)
+			transcodeMu.Lock()
+			delete(transcodeJobs, out)
+			transcodeMu.Unlock()
+			close(job.done)
+		}()
+	}
+	return job
+}
+
+// ffmpegToFile 占用一个转码名额运行 ffmpeg，先输出到临时文件，成功后再重命名
+func ffmpegToFile(out string, args func(tmp string) []string) error {
+	transcodeSlots <- struct{}{}
+	defer func() { <-transcodeSlots }()
+
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	// 输出可以是文件，也可以是目录（HLS 切片），失败时整个删除
+	tmp := out + ".tmp"
+	os.RemoveAll(tmp)
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, append([]string{"-nostdin", "-v", "error", "-y"}, args(tmp)...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	// 目录输出可能残留被缓存清理删掉部分文件的旧版本，先整个删除
+	os.RemoveAll(out)
+	if err := os.Rename(tmp, out); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	pruneTranscodeCache()
+	return nil
+}
+
+// pruneTranscodeCache 在缓存（含 HLS 分片）超过 TRANSCODE_CACHE_MB（默认 2048）时按修改时间删除最旧的文件
+func pruneTranscodeCache() {
+	limit := int64(envInt("TRANSCODE_CACHE_MB", 2048)) << 20
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	_ = filepath.WalkDir(transcodeCacheDir(), func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		// 跳过正在生成的临时文件与临时目录
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, cached{p, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= limit {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
@@ -338,13 +338,12 @@ func GetUserMusicFiles(userUUID string) ([]MusicFile, error) {
 	return musicFiles, nil
 }
 
-// GetMusicFileURL 获取音乐文件的访问URL
-func GetMusicFileURL(storagePath string) string {
-	return fmt.Sprintf("%s/storage/v1/object/public/music/%s", os.Getenv("SUPABASE_URL"), storagePath)
-}
-
 // GetMusicFileByID 根据ID获取音乐文件信息
 func GetMusicFileByID(musicFileID string, userUUID string) (*MusicFile, error) {
+	// ID 与用户ID会拼进查询条件，先校验字符避免被注入额外的过滤条件
+	if !uploadIDPattern.MatchString(musicFileID) || !uploadIDPattern.MatchString(userUUID) {
+		return nil, fmt.Errorf("音乐文件不存在")
+	}
 	httpClient := &http.Client{}
 	url := fmt.Sprintf("%s/rest/v1/music_files?id=eq.%s&user_id=eq.%s", os.Getenv("SUPABASE_URL"), musicFileID, userUUID)
 
@@ -402,20 +401,6 @@ func GetLocalMusicFiles() ([]MusicFile, error) {
 	return []MusicFile{}, nil
 }
 
-// HandleRangeRequest 处理范围请求（支持断点续传）
-func HandleRangeRequest(w http.ResponseWriter, r *http.Request, musicFile *MusicFile) {
-	// 解析Range头
-	rangeHeader := r.Header.Get("Range")
-	if rangeHeader == "" {
-		http.Error(w, "Range header required", http.StatusBadRequest)
-		return
-	}
-
-	// 这里实现范围请求处理逻辑
-	// 暂时返回完整文件，后续可以优化为真正的流媒体支持
-	http.Redirect(w, r, GetMusicFileURL(musicFile.StoragePath), http.StatusFound)
-}
-
 // DeleteMusicFile 删除音乐文件（从存储和数据库中删除）
 func DeleteMusicFile(musicFileID string, userUUID string) error {
 	// 首先获取文件信息
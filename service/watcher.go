@@ -0,0 +1,221 @@
+package service
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce 文件变化后等待的时间，合并批量复制/删除产生的大量事件后再重扫
+const watchDebounce = 2 * time.Second
+
+// ScanProgress 描述当前或最近一次扫描的进度
+type ScanProgress struct {
+	Running   bool        `json:"running"`
+	Total     int         `json:"total"`     // 本次需要处理的音频文件总数，遍历完成前为 0
+	Processed int         `json:"processed"` // 已处理的音频文件数
+	TagsRead  int         `json:"tagsRead"`  // 重新读取了标签的文件数
+	StartedAt time.Time   `json:"startedAt"`
+	Error     string      `json:"error,omitempty"`
+	Report    *ScanReport `json:"report,omitempty"`
+}
+
+var (
+	progressMu sync.Mutex
+	progress   ScanProgress
+	// rescanPending 扫描进行中又收到重扫请求时置位，当前扫描结束后再扫一次
+	rescanPending bool
+
+	watcherMu sync.Mutex
+	watcher   *fsnotify.Watcher
+)
+
+// GetScanProgress 返回扫描进度快照
+func GetScanProgress() ScanProgress {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	return progress
+}
+
+// StartRescan 在后台发起一次增量重扫；已有扫描在进行时返回 false
+func StartRescan() bool {
+	progressMu.Lock()
+	if progress.Running {
+		rescanPending = true
+		progressMu.Unlock()
+		return false
+	}
+	progress = ScanProgress{Running: true, StartedAt: time.Now()}
+	progressMu.Unlock()
+
+	go func() {
+		if err := RescanMusic(); err != nil {
+			log.Printf("重新扫描音乐失败: %v", err)
+		}
+	}()
+	return true
+}
+
+// beginScanProgress 标记扫描开始并清零计数
+func beginScanProgress() {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progress = ScanProgress{Running: true, StartedAt: time.Now()}
+}
+
+// setScanTotal 记录遍历得到的音频文件总数
+func setScanTotal(total int) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progress.Total = total
+}
+
+// addScanProgress 累加已处理文件数与读取标签的文件数
+func addScanProgress(processed, tagsRead int) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progress.Processed += processed
+	progress.TagsRead += tagsRead
+}
+
+// finishScanProgress 标记扫描结束并记录结果
+func finishScanProgress(report *ScanReport, err error) {
+	progressMu.Lock()
+	progress.Running = false
+	progress.Report = report
+	if err != nil {
+		progress.Error = err.Error()
+	}
+	pending := rescanPending
+	rescanPending = false
+	progressMu.Unlock()
+
+	if pending {
+		StartRescan()
+	}
+}
+
+// StartLibraryWatcher 监听所有启用的曲库根目录（含子目录）的文件变化，变化平息后自动增量重扫。
+// 重复调用会关闭旧的监听并按当前根目录配置重新监听。
+func StartLibraryWatcher() error {
+	watcherMu.Lock()
+	defer watcherMu.Unlock()
+
+	if watcher != nil {
+		watcher.Close()
+		watcher = nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	mu.RLock()
+	watchRoots := enabledRoots()
+	mu.RUnlock()
+	dirs := watchedDirs{}
+	for _, root := range watchRoots {
+		if err := watchTree(w, root.Path, dirs); err != nil {
+			log.Printf("监听曲库根目录 %s 失败: %v", root.Name, err)
+		}
+	}
+	watcher = w
+	go watchLoop(w, dirs)
+	return nil
+}
+
+// watchedDirs 记录已添加监听的目录。目录被删除或改名后路径已不存在，靠它判断事件路径是否为目录；
+// 只在 StartLibraryWatcher 与 watchLoop 所在的协程中使用
+type watchedDirs map[string]bool
+
+// remove 删除目录及其所有子目录的记录，返回该路径是否为监听中的目录
+func (d watchedDirs) remove(p string) bool {
+	if !d[p] {
+		return false
+	}
+	prefix := p + string(filepath.Separator)
+	for dir := range d {
+		if dir == p || strings.HasPrefix(dir, prefix) {
+			delete(d, dir)
+		}
+	}
+	return true
+}
+
+// watchTree 为目录及其所有子目录添加监听（fsnotify 不支持递归监听），并记入 dirs
+func watchTree(w *fsnotify.Watcher, root string, dirs watchedDirs) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, e error) error {
+		if e != nil {
+			if p == root {
+				return e
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := w.Add(p); err != nil {
+				log.Printf("监听目录失败 %s: %v", p, err)
+			} else {
+				dirs[p] = true
+			}
+		}
+		return nil
+	})
+}
+
+func watchLoop(w *fsnotify.Watcher, dirs watchedDirs) {
+	var timer *time.Timer
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+			if ev.Has(fsnotify.Chmod) && !ev.Has(fsnotify.Write) {
+				continue
+			}
+			// 新建（或移入）的目录需要补充监听
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					_ = watchTree(w, ev.Name, dirs)
+				}
+			}
+			if !isLibraryEvent(ev, dirs) {
+				continue
+			}
+			if timer == nil {
+				timer = time.AfterFunc(watchDebounce, func() { StartRescan() })
+			} else {
+				timer.Reset(watchDebounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("音乐目录监听错误: %v", err)
+		}
+	}
+}
+
+// isLibraryEvent 判断事件是否可能影响曲库：音频文件本身，或可能包含音频文件的目录
+func isLibraryEvent(ev fsnotify.Event, dirs watchedDirs) bool {
+	if _, ok := lookupAudioFormat(ev.Name); ok {
+		return true
+	}
+	if isSidecarFile(ev.Name) {
+		return true
+	}
+	// 目录被删除或改名时路径已不存在，无法 Stat，按是否为监听中的目录判断
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		return dirs.remove(ev.Name)
+	}
+	info, err := os.Stat(ev.Name)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestIsLibraryEventRemovedDir(t *testing.T) {
+	root := t.TempDir()
+	album := filepath.Join(root, "Album.2024")
+	dirs := watchedDirs{root: true, album: true, filepath.Join(album, "CD1"): true}
+
+	// 已删除的目录名带扩展名也按目录处理，同时清掉其子目录的记录
+	if !isLibraryEvent(fsnotify.Event{Name: album, Op: fsnotify.Remove}, dirs) {
+		t.Error("removed watched dir not treated as library event")
+	}
+	if dirs[filepath.Join(album, "CD1")] || !dirs[root] {
+		t.Errorf("watched dirs after removal = %v", dirs)
+	}
+	// 不是监听目录、也不是音频或外部文件的路径被改名时忽略
+	if isLibraryEvent(fsnotify.Event{Name: filepath.Join(root, "notes"), Op: fsnotify.Rename}, dirs) {
+		t.Error("renamed non-dir without extension treated as library event")
+	}
+	if !isLibraryEvent(fsnotify.Event{Name: filepath.Join(root, "a.flac"), Op: fsnotify.Remove}, dirs) {
+		t.Error("removed audio file not treated as library event")
+	}
+}
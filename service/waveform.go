@@ -0,0 +1,397 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mewkiz/flac"
+)
+
+// waveformBins 是缓存的波形分辨率，请求的点数不超过它时由缓存直接合并得到
+const waveformBins = 4096
+
+// DefaultWaveformPoints 未指定 points 时返回的点数
+const DefaultWaveformPoints = 800
+
+// ErrWaveformUnsupported 表示既不能用纯 Go 解码，也没有配置 ffmpeg
+var ErrWaveformUnsupported = errors.New("waveform is not supported for this format without ffmpeg")
+
+// Waveform 是曲目的峰值数据：每个点是该时间段内采样的最小值与最大值，范围 [-1, 1]，
+// 多声道时取所有声道的极值
+type Waveform struct {
+	ID       int          `json:"id"`
+	Duration float64      `json:"duration"`
+	Points   int          `json:"points"`
+	Peaks    [][2]float64 `json:"peaks"`
+}
+
+// waveformCache 是写入数据目录的缓存格式
+type waveformCache struct {
+	Duration float64   `json:"duration"`
+	Min      []float64 `json:"min"`
+	Max      []float64 `json:"max"`
+}
+
+var (
+	// waveformJobs 合并对同一曲目的并发计算
+	waveformMu   sync.Mutex
+	waveformJobs = map[string]*transcodeJob{}
+	// waveformSlots 限制同时解码的曲目数（FLAC、WAV 与 ffmpeg 都占用），可通过 WAVEFORM_MAX_JOBS 设置
+	waveformSlots = make(chan struct{}, envInt("WAVEFORM_MAX_JOBS", 2))
+)
+
+func waveformCacheDir() string {
+	return filepath.Join(dataDir, "waveform")
+}
+
+// GetWaveform 返回曲目的波形峰值。首次请求时解码整首曲目并缓存到数据目录，
+// 缓存文件名包含修改时间与大小，文件变化后重新计算；ctx 取消只停止等待，不中断计算
+func GetWaveform(ctx context.Context, id, points int) (*Waveform, error) {
+	t, err := getTrackByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if points <= 0 {
+		points = DefaultWaveformPoints
+	}
+	if points > waveformBins {
+		points = waveformBins
+	}
+
+	path := filepath.Join(waveformCacheDir(), fmt.Sprintf("%d_%d_%d.json", t.ID, t.ModTime, t.Size))
+	cache, err := readWaveformCache(path)
+	if err != nil {
+		if err := computeWaveformOnce(ctx, t, path); err != nil {
+			return nil, err
+		}
+		if cache, err = readWaveformCache(path); err != nil {
+			return nil, err
+		}
+	}
+
+	wf := &Waveform{ID: t.ID, Duration: cache.Duration}
+	n := len(cache.Min)
+	if points > n {
+		points = n
+	}
+	wf.Points = points
+	wf.Peaks = make([][2]float64, points)
+	for i := 0; i < points; i++ {
+		lo, hi := i*n/points, (i+1)*n/points
+		mn, mx := cache.Min[lo], cache.Max[lo]
+		for j := lo + 1; j < hi; j++ {
+			mn = math.Min(mn, cache.Min[j])
+			mx = math.Max(mx, cache.Max[j])
+		}
+		wf.Peaks[i] = [2]float64{mn, mx}
+	}
+	return wf, nil
+}
+
+func readWaveformCache(path string) (*waveformCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c waveformCache
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Min) == 0 || len(c.Min) != len(c.Max) {
+		return nil, errors.New("invalid waveform cache")
+	}
+	return &c, nil
+}
+
+// computeWaveformOnce 计算并写入缓存，同一缓存文件的并发请求共用一次计算
+func computeWaveformOnce(ctx context.Context, t Track, path string) error {
+	waveformMu.Lock()
+	job, running := waveformJobs[path]
+	if !running {
+		job = &transcodeJob{done: make(chan struct{})}
+		waveformJobs[path] = job
+		go func() {
+			job.err = computeWaveform(t, path)
+			waveformMu.Lock()
+			delete(waveformJobs, path)
+			waveformMu.Unlock()
+			close(job.done)
+		}()
+	}
+	waveformMu.Unlock()
+
+	select {
+	case <-job.done:
+		return job.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// computeWaveform 解码整首曲目计算峰值并写入缓存，解码期间占用一个 waveformSlots 名额
+func computeWaveform(t Track, path string) error {
+	waveformSlots <- struct{}{}
+	defer func() { <-waveformSlots }()
+
+	var acc *peakAccumulator
+	var err error
+	switch strings.ToLower(filepath.Ext(t.Path)) {
+	case ".flac":
+		acc, err = decodeFLACPeaks(t)
+	case ".wav":
+		acc, err = decodeWAVPeaks(t)
+	default:
+		acc, err = decodeFFmpegPeaks(t)
+	}
+	if err != nil {
+		return err
+	}
+	cache := acc.result()
+	if len(cache.Min) == 0 {
+		return errors.New("no audio samples")
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(waveformCacheDir(), 0755); err != nil {
+		return err
+	}
+	// 删除同一曲目旧版本文件的缓存
+	if old, _ := filepath.Glob(filepath.Join(waveformCacheDir(), fmt.Sprintf("%d_*.json", t.ID))); len(old) > 0 {
+		for _, p := range old {
+			if p != path {
+				os.Remove(p)
+			}
+		}
+	}
+	return writeFileAtomic(path, data)
+}
+
+// peakAccumulator 按固定的采样数分块记录极值。总采样数来自文件头，
+// 未知时按时长估算，块数与 waveformBins 不一致时在 result 中再合并
+type peakAccumulator struct {
+	sampleRate int
+	blockSize  int64
+	count      int64 // 当前块已累计的采样帧
+	total      int64
+	curMin     float64
+	curMax     float64
+	min, max   []float64
+}
+
+func newPeakAccumulator(sampleRate int, estimatedFrames int64) *peakAccumulator {
+	block := (estimatedFrames + waveformBins - 1) / waveformBins
+	if block < 1 {
+		// 长度未知时按每秒约 10 个点分块
+		block = int64(sampleRate/10) + 1
+	}
+	return &peakAccumulator{sampleRate: sampleRate, blockSize: block, curMin: math.Inf(1), curMax: math.Inf(-1)}
+}
+
+// add 记录一个采样帧内（所有声道）的最小值与最大值
+func (p *peakAccumulator) add(mn, mx float64) {
+	if mn < p.curMin {
+		p.curMin = mn
+	}
+	if mx > p.curMax {
+		p.curMax = mx
+	}
+	p.count++
+	p.total++
+	if p.count == p.blockSize {
+		p.flush()
+	}
+}
+
+func (p *peakAccumulator) flush() {
+	if p.count == 0 {
+		return
+	}
+	p.min = append(p.min, p.curMin)
+	p.max = append(p.max, p.curMax)
+	p.count, p.curMin, p.curMax = 0, math.Inf(1), math.Inf(-1)
+}
+
+func (p *peakAccumulator) result() waveformCache {
+	p.flush()
+	c := waveformCache{}
+	if p.sampleRate > 0 {
+		c.Duration = math.Round(float64(p.total)/float64(p.sampleRate)*1000) / 1000
+	}
+	n := len(p.min)
+	bins := n
+	if bins > waveformBins {
+		bins = waveformBins
+	}
+	round := func(v float64) float64 { return math.Round(v*10000) / 10000 }
+	for i := 0; i < bins; i++ {
+		lo, hi := i*n/bins, (i+1)*n/bins
+		mn, mx := p.min[lo], p.max[lo]
+		for j := lo + 1; j < hi; j++ {
+			mn = math.Min(mn, p.min[j])
+			mx = math.Max(mx, p.max[j])
+		}
+		c.Min = append(c.Min, round(mn))
+		c.Max = append(c.Max, round(mx))
+	}
+	return c
+}
+
+// decodeFLACPeaks 用纯 Go 解码 FLAC
+func decodeFLACPeaks(t Track) (*peakAccumulator, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stream, err := flac.New(f)
+	if err != nil {
+		return nil, fmt.Errorf("flac: %v", err)
+	}
+	info := stream.Info
+	scale := float64(int64(1) << (info.BitsPerSample - 1))
+	estimated := int64(info.NSamples)
+	if estimated == 0 {
+		estimated = int64(t.Duration * float64(info.SampleRate))
+	}
+	acc := newPeakAccumulator(int(info.SampleRate), estimated)
+	for {
+		frame, err := stream.ParseNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// 文件末尾损坏时保留已解码的部分
+			if acc.total > 0 {
+				break
+			}
+			return nil, fmt.Errorf("flac: %v", err)
+		}
+		if len(frame.Subframes) == 0 {
+			continue
+		}
+		for i := range frame.Subframes[0].Samples {
+			mn, mx := math.Inf(1), math.Inf(-1)
+			for _, sub := range frame.Subframes {
+				v := float64(sub.Samples[i]) / scale
+				mn, mx = math.Min(mn, v), math.Max(mx, v)
+			}
+			acc.add(mn, mx)
+		}
+	}
+	return acc, nil
+}
+
+// decodeWAVPeaks 解码 PCM WAV（8/16/24/32 位整数与 32/64 位浮点），其他编码交给 ffmpeg
+func decodeWAVPeaks(t Track) (*peakAccumulator, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	wf, size, err := readWAVHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if !(wf.Format == 1 && wf.BitDepth <= 32 && wf.BitDepth%8 == 0) && !(wf.Format == 3 && (wf.BitDepth == 32 || wf.BitDepth == 64)) {
+		return decodeFFmpegPeaks(t)
+	}
+	frameBytes := int64(wf.Channels * wf.BitDepth / 8)
+	acc := newPeakAccumulator(wf.SampleRate, size/frameBytes)
+	if err := readPCM(io.LimitReader(f, size), acc, wf.Channels, wf.BitDepth, wf.Format == 3); err != nil {
+		return nil, fmt.Errorf("wav: %v", err)
+	}
+	return acc, nil
+}
+
+// readPCM 读取交错存储的小端 PCM 采样直到 EOF，末尾不完整的帧被忽略；
+// 没有读到 EOF 就出错时返回错误，调用方不应缓存不完整的波形
+func readPCM(r io.Reader, acc *peakAccumulator, channels, bits int, float bool) error {
+	width := bits / 8
+	frame := make([]byte, channels*width)
+	br := bufio.NewReaderSize(r, 64<<10)
+	scale := float64(int64(1) << (bits - 1))
+	for {
+		if _, err := io.ReadFull(br, frame); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		mn, mx := math.Inf(1), math.Inf(-1)
+		for c := 0; c < channels; c++ {
+			b := frame[c*width : (c+1)*width]
+			var v float64
+			switch {
+			case float && width == 4:
+				v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			case float:
+				v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			case width == 1:
+				v = (float64(b[0]) - 128) / scale // 8 位 PCM 是无符号数
+			default:
+				// 按小端拼成有符号整数
+				var n int64
+				for i := width - 1; i >= 0; i-- {
+					n = n<<8 | int64(b[i])
+				}
+				n = n << (64 - bits) >> (64 - bits)
+				v = float64(n) / scale
+			}
+			mn, mx = math.Min(mn, v), math.Max(mx, v)
+		}
+		acc.add(mn, mx)
+	}
+}
+
+// waveformFFmpegRate 用 ffmpeg 解码时的采样率，波形只需要低采样率
+const waveformFFmpegRate = 8000
+
+// decodeFFmpegPeaks 用 ffmpeg 把其他格式解码为单声道 16 位 PCM，占用一个转码名额
+func decodeFFmpegPeaks(t Track) (*peakAccumulator, error) {
+	if !TranscodeAvailable() {
+		return nil, ErrWaveformUnsupported
+	}
+	transcodeSlots <- struct{}{}
+	defer func() { <-transcodeSlots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-nostdin", "-v", "error", "-i", t.Path,
+		"-map", "0:a:0", "-vn", "-ac", "1", "-ar", fmt.Sprint(waveformFFmpegRate), "-f", "s16le", "-")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	acc := newPeakAccumulator(waveformFFmpegRate, int64(t.Duration*waveformFFmpegRate))
+	readErr := readPCM(out, acc, 1, 16, false)
+	if readErr != nil {
+		// 读取中断时结束 ffmpeg，避免它阻塞在写管道上
+		cancel()
+	}
+	if err := cmd.Wait(); err != nil && readErr == nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("ffmpeg: %v", readErr)
+	}
+	return acc, nil
+}
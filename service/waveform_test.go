@@ -0,0 +1,30 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadPCM(t *testing.T) {
+	// 两个完整的 16 位立体声帧，加上末尾不完整的一个字节
+	pcm := []byte{0x00, 0x40, 0x00, 0xc0, 0xff, 0x7f, 0x00, 0x80, 0x01}
+	acc := newPeakAccumulator(8000, 2)
+	if err := readPCM(bytes.NewReader(pcm), acc, 2, 16, false); err != nil {
+		t.Fatalf("readPCM clean input: %v", err)
+	}
+	if acc.total != 2 {
+		t.Errorf("frames read = %d, want 2", acc.total)
+	}
+
+	errRead := errors.New("read failed")
+	r := io.MultiReader(bytes.NewReader(pcm[:4]), errReader{errRead})
+	if err := readPCM(r, newPeakAccumulator(8000, 2), 2, 16, false); !errors.Is(err, errRead) {
+		t.Errorf("readPCM with failing reader = %v, want %v", err, errRead)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,18 @@
+-- 分享链接所需的表结构
+
+CREATE TABLE IF NOT EXISTS share_links (
+    id TEXT PRIMARY KEY,                 -- 随机ID，令牌为 id.过期时间.签名
+    user_id UUID NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('track', 'upload', 'album')),
+    target_id TEXT NOT NULL,
+    title TEXT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    max_plays INTEGER NOT NULL DEFAULT 0, -- 0 表示不限次数
+    play_count INTEGER NOT NULL DEFAULT 0,
+    password_hash TEXT,                  -- salt$sha256，空表示不设密码
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_user_created ON share_links (user_id, created_at DESC);
+
+SELECT '分享链接表结构已创建' as status;
@@ -0,0 +1,36 @@
+-- 智能歌单与曲目评分所需的表结构
+
+-- 1. 曲目评分（1-5），智能歌单可按评分筛选
+CREATE TABLE IF NOT EXISTS track_ratings (
+    user_id UUID NOT NULL,
+    song_id TEXT NOT NULL,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    updated_at TIMESTAMPTZ DEFAULT now(),
+    PRIMARY KEY (user_id, song_id)
+);
+
+-- 2. 智能歌单：只保存规则，读取时对当前曲库求值
+CREATE TABLE IF NOT EXISTS smart_playlists (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    is_public BOOLEAN NOT NULL DEFAULT false,
+    rules JSONB NOT NULL DEFAULT '{}',   -- {match: all|any, rules: [...]} 或 {field, op, value}
+    sort_by TEXT NOT NULL DEFAULT '',    -- 逗号分隔的字段，前缀 - 表示降序；random 表示随机
+    limit_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_smart_playlists_user_updated ON smart_playlists (user_id, updated_at DESC);
+
+-- 3. 按用户与曲目汇总的播放统计，智能歌单的 playCount/lastPlayed 规则读取这里，不必逐条拉取播放历史（play_history 表由 subsonic_migration.sql 创建，需先执行）
+CREATE INDEX IF NOT EXISTS idx_play_history_user_song ON play_history (user_id, song_id);
+
+CREATE OR REPLACE VIEW play_stats AS
+SELECT user_id, song_id, count(*) AS play_count, max(played_at) AS last_played
+FROM play_history
+GROUP BY user_id, song_id;
+
+SELECT '智能歌单表结构已创建' as status;
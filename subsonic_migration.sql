@@ -0,0 +1,19 @@
+-- Subsonic 兼容 API 所需的表结构
+
+-- 1. Subsonic 客户端密码（AES-GCM 加密后保存，token 认证需要服务端还原明文）
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS subsonic_password TEXT;
+
+-- 2. 播放历史（scrobble 写入）
+CREATE TABLE IF NOT EXISTS play_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    song_id TEXT NOT NULL,
+    song_title TEXT,
+    song_artist TEXT,
+    song_album TEXT,
+    played_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_play_history_user_played ON play_history (user_id, played_at DESC);
+
+SELECT 'Subsonic 表结构已创建' as status;
@@ -151,13 +151,12 @@ window.authManager = {
   window.initCarousel();
 })();
 
-// 首页热门推荐：改为从 /api/music 动态渲染本地 FLAC
+// 首页热门推荐：改为从 /api/music 动态渲染本地音乐
 (function () {
   const grid = document.querySelector(".grid");
   if (!grid) return;
 
-  // 先尝试触发后端重扫，确保识别本地 FLAC（失败则忽略）
-  fetch("/api/rescan").catch(() => {});
+  // 后端会监听音乐目录自动更新曲库，这里直接读取列表
   fetch("/api/music")
     .then(res => res.json())
     .then(list => {
@@ -171,7 +170,7 @@ window.authManager = {
 
         const thumb = document.createElement("div");
         thumb.className = "thumb";
-        const coverUrl = item.hasCover ? ("/api/cover?id=" + item.id) : "https://picsum.photos/300/300";
+        const coverUrl = item.hasCover ? ("/api/cover?id=" + item.id + "&size=256") : "https://picsum.photos/300/300";
         thumb.style.backgroundImage = "url('" + coverUrl + "')";
         a.appendChild(thumb);
 
@@ -307,7 +306,7 @@ class CloudMusicPlayer {
       if (this.isCloudMusic) {
         miniCover.src = 'https://picsum.photos/50/50';
       } else {
-        miniCover.src = "/api/cover?id=" + encodeURIComponent(this.currentTrack.id);
+        miniCover.src = "/api/cover?id=" + encodeURIComponent(this.currentTrack.id) + "&size=128";
       }
     }
     if (trackTitle) trackTitle.textContent = this.currentTrack.title || "未知标题";
@@ -409,6 +408,22 @@ class CloudMusicPlayer {
 // 初始化云端音乐播放器
 window.cloudMusicPlayer = new CloudMusicPlayer();
 
+// 播放历史：任意页面的播放器播完一首曲库曲目时记录一次播放（未登录时接口返回 401，忽略即可）。
+// ended 事件不冒泡，在捕获阶段统一监听
+document.addEventListener('ended', (e) => {
+  const el = e.target;
+  if (!(el instanceof HTMLMediaElement) || !el.currentSrc) return;
+  const url = new URL(el.currentSrc, window.location.href);
+  const id = Number(url.searchParams.get('id'));
+  if (url.pathname !== '/api/audio' || url.searchParams.has('share') || !Number.isInteger(id)) return;
+  fetch('/api/history', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ id }),
+    keepalive: true
+  }).catch(() => {});
+}, true);
+
 // 歌曲页：根据 id 加载音频、封面与歌词
 (function () {
   const url = new URL(window.location.href);
@@ -447,7 +462,7 @@ window.cloudMusicPlayer = new CloudMusicPlayer();
 
       // 底部播放器信息与封面
       const miniCover = document.querySelector(".mini-cover");
-      if (miniCover) miniCover.src = "/api/cover?id=" + encodeURIComponent(id);
+      if (miniCover) miniCover.src = "/api/cover?id=" + encodeURIComponent(id) + "&size=128";
       const trackTitle = document.querySelector(".track .title");
       const trackArtist = document.querySelector(".track .artist");
       if (trackTitle) trackTitle.textContent = t.title || "未知标题";
@@ -718,7 +733,7 @@ window.cloudMusicPlayer = new CloudMusicPlayer();
     }
   }); // 结束 audio.addEventListener('loadedmetadata')
 }
-};
+})();
 
 // 播放器控制（歌曲页）
 (function () {
@@ -1327,7 +1342,7 @@ function initModalInteractions() {
   // 初始化
   checkAuthStatus();
   loadComments();
-});
+})();
 
 // 立即初始化模态框交互（不等待DOMContentLoaded）
 if (typeof initModalInteractions === 'function') {
@@ -1337,6 +1352,8 @@ if (typeof initModalInteractions === 'function') {
 // 初始化所有页面的登录状态
 if (window.authManager && typeof window.authManager.initAllPagesAuth === 'function') {
   window.authManager.initAllPagesAuth();
+}
+
 // 确保模态框函数存在，如果不存在则重新初始化
 if (!window.openLoginModal || !window.openRegisterModal) {
   console.warn('模态框函数未定义，重新初始化');